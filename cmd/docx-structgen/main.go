@@ -0,0 +1,63 @@
+// Command docx-structgen generates the Go struct types of the values of a DOCX template.
+//
+// Usage:
+//
+//	docx-structgen [-pkg main] [-type TemplateValues] [-o values.go] <file.docx>
+//
+// The values are rendered with the TemplateValues method of the generated structs, keyed by the template
+// field names. It is meant to be used with go:generate, so that the structs follow the edits of the Word file:
+//
+//	//go:generate go run github.com/JJJJJJack/go-template-docx/cmd/docx-structgen -pkg report -o values.go report.docx
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	gotemplatedocx "github.com/JJJJJJack/go-template-docx"
+)
+
+func main() {
+	packageName := flag.String("pkg", "main", "package name of the generated file")
+	typeName := flag.String("type", "TemplateValues", "type name of the root struct")
+	output := flag.String("o", "", "output file (default stdout)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: docx-structgen [flags] <file.docx>\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := run(flag.Arg(0), *packageName, *typeName, *output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "docx-structgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(docxFilename, packageName, typeName, output string) error {
+	docxTemplate, err := gotemplatedocx.NewDocxTemplateFromFilename(docxFilename)
+	if err != nil {
+		return err
+	}
+
+	src, err := docxTemplate.GenerateGoStructs(packageName, typeName)
+	if err != nil {
+		return err
+	}
+
+	src = append([]byte(fmt.Sprintf("// Code generated by docx-structgen from %s. DO NOT EDIT.\n\n", filepath.Base(docxFilename))), src...)
+
+	if output == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+
+	return os.WriteFile(output, src, 0644)
+}
@@ -0,0 +1,848 @@
+package gotemplatedocx
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/JJJJJJack/go-template-docx/internal/docx"
+	"github.com/JJJJJJack/go-template-docx/xml"
+	goziputils "github.com/JJJJJJack/go-zip-utils"
+)
+
+// compiledPart is a templated XML part of the docx file parsed once at compile time.
+type compiledPart struct {
+	file *zip.File
+	tmpl *docx.Template
+	// relsFile and rel are the rels file of a header or footer part, nil if it has none
+	relsFile *zip.File
+	rel      *docx.Relationship
+}
+
+// compiledChart is a templated chart part along with the embedded XLSX feeding its preview.
+type compiledChart struct {
+	compiledPart
+	chartFilename  string
+	xlsxFileTarget string
+}
+
+// compiledTemplate is an immutable docx template in which every templated part
+// has already been parsed. It is obtained with Compile and can be rendered
+// any number of times, also from multiple goroutines at once.
+type compiledTemplate struct {
+	media               docx.MediaMap
+	mediaFilenames      []string
+	document            *docx.DocumentMeta
+	copiedFiles         []*zip.File
+	contentTypesFile    *zip.File
+	contentTypes        []byte
+	documentRelsFile    *zip.File
+	documentRelsContent []byte
+	generatedFiles      map[string]*zip.File
+	rel                 *docx.Relationship
+	xlsxFiles           []*compiledXlsx
+	headers             []compiledPart
+	footers             []compiledPart
+	documentPart        compiledPart
+	charts              []compiledChart
+	filesPostProcessors []xml.HandlersMap
+	limits              Limits
+}
+
+// Compile parses all the templated parts of the DOCX file (document, headers, footers,
+// charts and embedded XLSX) once and returns an immutable compiled template.
+// The loaded media, template functions and processors are snapshotted: later changes
+// to the docxTemplate object do not affect the returned compiled template.
+func (dt *docxTemplate) Compile() (*compiledTemplate, error) {
+	return dt.compile(nil)
+}
+
+// compile implements Compile. When report is not nil the template is compiled for validation:
+// the parsing and pre-processing errors are collected into report instead of being returned,
+// the parts that can't be parsed are left without template and the image functions check
+// that their filename is a loaded media.
+func (dt *docxTemplate) compile(report *ValidationReport) (*compiledTemplate, error) {
+	input, inputSize := dt.input, dt.inputSize
+
+	// custom user pre processing
+	if len(dt.filesPreProcessors) > 0 {
+		inputBuffer := bytes.Buffer{}
+		_, err := io.Copy(&inputBuffer, io.NewSectionReader(dt.input, 0, dt.inputSize))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read DOCX file: %w", err)
+		}
+
+		err = xml.ProcessedOutput(dt.filesPreProcessors, &inputBuffer, "pre")
+		switch {
+		case err == nil:
+			input, inputSize = bytes.NewReader(inputBuffer.Bytes()), int64(inputBuffer.Len())
+		case report != nil:
+			// go on validating the original file
+			report.add(fmt.Errorf("unable to pre-process output DOCX file: %w", err))
+		default:
+			return nil, fmt.Errorf("unable to pre-process output DOCX file: %w", err)
+		}
+	}
+
+	docxZipMap, err := newZipMap(input, inputSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create DOCX zip map: %w", err)
+	}
+
+	inputTotalSize := uint64(0)
+	err = checkInputSize(docxZipMap, &inputTotalSize, dt.limits.MaxInputSize)
+	if err != nil {
+		return nil, err
+	}
+
+	document, err := docx.ParseDocumentMeta(docxZipMap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse document metadata: %w", err)
+	}
+
+	ct := compiledTemplate{
+		media:               make(docx.MediaMap, len(dt.media)),
+		document:            document,
+		filesPostProcessors: append([]xml.HandlersMap(nil), dt.filesPostProcessors...),
+		limits:              dt.limits,
+	}
+
+	// assign each loaded media to its word convention equivalent path "word/media/imageN.ext",
+	// following docx naming convention with sequential numbers
+	for filename := range dt.media {
+		ct.mediaFilenames = append(ct.mediaFilenames, filename)
+	}
+	sort.Strings(ct.mediaFilenames)
+
+	supported := ct.mediaFilenames[:0]
+	for _, filename := range ct.mediaFilenames {
+		if isSupportedMediaType(filename) {
+			supported = append(supported, filename)
+			continue
+		}
+
+		err := fmt.Errorf("unsupported media file type '%s' for '%s' (only accepting jpg/png for now)", path.Ext(filename), filename)
+		if report == nil {
+			return nil, err
+		}
+		// go on validating without the media, which can't be written into a valid DOCX file
+		report.add(err)
+	}
+	ct.mediaFilenames = supported
+
+	for _, filename := range ct.mediaFilenames {
+		imageN := document.NextImageNumber()
+
+		ct.media[filename] = &docx.Media{
+			Data:         dt.media[filename].Data,
+			WordFilename: fmt.Sprintf("image%d%s", imageN, path.Ext(filename)),
+		}
+	}
+	document.SetMediaMap(ct.media)
+	document.SetTrackChanges(dt.trackChanges)
+	document.SetUpdateTableOfContents(dt.updateTableOfContents)
+
+	config := dt.templateConfig()
+	config.MaxTableWidth = document.MaxWidthTwips()
+	if report != nil {
+		config.Funcs = dt.templateFuncs.validationConfig(ct.media).Funcs
+	}
+
+	// Copy all files except the ones that will be processed
+	documentRelsFilename := "word/_rels/document.xml.rels"
+	contentTypesFilename := "[Content_Types].xml"
+	chartsMatcher := regexp.MustCompile(`word/charts/chart\d*?\.xml`)
+	xlsxMatcher := regexp.MustCompile(`/embeddings/Microsoft_Excel_Worksheet\d*?\.xlsx`)
+	headerFooterDocumentMatcher := regexp.MustCompile(`word/(header|footer|document)\d*?\.xml`)
+	headerFooterRelsMatcher := regexp.MustCompile(`word/_rels/(header|footer)\d*?\.xml\.rels`)
+	// the parts extended while rendering (e.g. word/numbering.xml with the lists definitions) are written after it
+	ct.generatedFiles = make(map[string]*zip.File)
+	for _, filename := range docx.GeneratedPartNames {
+		if f := docxZipMap[filename]; f != nil {
+			ct.generatedFiles[filename] = f
+		}
+	}
+
+	for filename, f := range docxZipMap {
+		switch {
+		case ct.generatedFiles[filename] != nil:
+			continue
+		case
+			filename == documentRelsFilename,
+			filename == contentTypesFilename,
+			chartsMatcher.MatchString(filename),
+			xlsxMatcher.MatchString(filename),
+			headerFooterDocumentMatcher.MatchString(filename),
+			headerFooterRelsMatcher.MatchString(filename):
+			continue
+		}
+
+		ct.copiedFiles = append(ct.copiedFiles, f)
+	}
+	sort.Slice(ct.copiedFiles, func(i, j int) bool {
+		return ct.copiedFiles[i].Name < ct.copiedFiles[j].Name
+	})
+
+	// Edit [Content_Types].xml if media files are provided
+	ct.contentTypesFile = docxZipMap[contentTypesFilename]
+	if ct.contentTypesFile == nil {
+		return nil, fmt.Errorf("%s not found in the DOCX file", contentTypesFilename)
+	}
+
+	ctData, err := goziputils.ReadZipFileContent(ct.contentTypesFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read content types file '%s': %w", ct.contentTypesFile.Name, err)
+	}
+
+	contentTypes, err := docx.ParseContentTypes(ctData)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse content types file '%s': %w", ct.contentTypesFile.Name, err)
+	}
+
+	for _, filename := range ct.mediaFilenames {
+		ext := path.Ext(filename)
+
+		switch lowerExt := strings.ToLower(ext); lowerExt {
+		case ".jpg", ".jpeg", ".jfif":
+			contentTypes.AddDefaultUnique(lowerExt[1:], "image/jpeg")
+		case ".png":
+			contentTypes.AddDefaultUnique("png", "image/png")
+		}
+	}
+
+	ct.contentTypes, err = contentTypes.ToXml()
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal content types to XML: %w", err)
+	}
+
+	ct.documentRelsFile = docxZipMap[documentRelsFilename]
+	ct.documentRelsContent, err = goziputils.ReadZipFileContent(ct.documentRelsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rel file '%s': %w", documentRelsFilename, err)
+	}
+
+	ct.rel, err = docx.ParseRelationship(ct.documentRelsContent)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse rel file '%s': %w", documentRelsFilename, err)
+	}
+
+	// Map chart files to their target XLSX files
+	chartRelToTargetXlsx := make(map[string]string)
+	for i := 1; ; i++ {
+		relsChartFilename := fmt.Sprintf("word/charts/_rels/chart%d.xml.rels", i)
+		f := docxZipMap[relsChartFilename]
+		if f == nil {
+			break
+		}
+
+		fileContent, err := goziputils.ReadZipFileContent(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read chart rel file '%s': %w", f.Name, err)
+		}
+
+		chartsRelationships, _ := docx.ParseRelationship(fileContent)
+		for _, relationship := range chartsRelationships.Relationships {
+			if !xlsxMatcher.MatchString(relationship.Target) {
+				continue
+			}
+
+			targetXlsxFilename := strings.Replace(relationship.Target, "../", "word/", 1)
+			chartFilename, err := docx.ExtractChartFilename(f.Name)
+			if err != nil {
+				return nil, fmt.Errorf("unable to extract chart name from file '%s': %w", f.Name, err)
+			}
+			chartRelToTargetXlsx[chartFilename] = targetXlsxFilename
+		}
+	}
+
+	// Parse the XLSX files
+	for i := 0; ; i++ {
+		xlsxFilename := fmt.Sprintf("word/embeddings/Microsoft_Excel_Worksheet%d.xlsx", i)
+		if i == 0 {
+			xlsxFilename = "word/embeddings/Microsoft_Excel_Worksheet.xlsx"
+		}
+		f := docxZipMap[xlsxFilename]
+		if f == nil {
+			break
+		}
+
+		cx, err := compileXlsx(f, &inputTotalSize, dt.limits.MaxInputSize, config, report)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compile XLSX file '%s': %w", f.Name, err)
+		}
+
+		ct.xlsxFiles = append(ct.xlsxFiles, cx)
+	}
+
+	// Parse the header and footer files
+	ct.headers, err = compileNumberedParts(docxZipMap, config, report, "word/header%d.xml")
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile header file: %w", err)
+	}
+
+	ct.footers, err = compileNumberedParts(docxZipMap, config, report, "word/footer%d.xml")
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile footer file: %w", err)
+	}
+
+	// the rels files of the headers and footers get the relationships added while rendering them
+	for _, parts := range [][]compiledPart{ct.headers, ct.footers} {
+		err = compilePartsRels(docxZipMap, parts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Parse the main document file
+	documentFile := docxZipMap["word/document.xml"]
+	if documentFile == nil {
+		return nil, fmt.Errorf("word/document.xml not found in the DOCX file")
+	}
+
+	ct.documentPart, err = compilePart(documentFile, config, report)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile document file: %w", err)
+	}
+
+	// Parse the chart files
+	charts, err := compileNumberedParts(docxZipMap, config, report, "word/charts/chart%d.xml")
+	if err != nil {
+		return nil, fmt.Errorf("unable to compile chart file: %w", err)
+	}
+
+	for _, chart := range charts {
+		chartFilename, err := docx.ExtractChartFilename(chart.file.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to extract chart name from file '%s': %w", chart.file.Name, err)
+		}
+
+		ct.charts = append(ct.charts, compiledChart{
+			compiledPart:   chart,
+			chartFilename:  chartFilename,
+			xlsxFileTarget: chartRelToTargetXlsx[chartFilename],
+		})
+	}
+
+	return &ct, nil
+}
+
+// newZipMap creates a ZipMap reading the zip file of the given size from r.
+func newZipMap(r io.ReaderAt, size int64) (goziputils.ZipMap, error) {
+	zipReader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zip reader: %w", err)
+	}
+
+	zipMap := make(goziputils.ZipMap, len(zipReader.File))
+	for _, f := range zipReader.File {
+		zipMap[f.Name] = f
+	}
+
+	return zipMap, nil
+}
+
+// compilePart reads and parses a templated XML part.
+// When report is not nil the parsing errors are collected into it and
+// the part template is nil if the part can't be parsed.
+func compilePart(f *zip.File, config docx.TemplateConfig, report *ValidationReport) (compiledPart, error) {
+	fileContent, err := goziputils.ReadZipFileContent(f)
+	if err != nil {
+		return compiledPart{}, fmt.Errorf("unable to read file '%s': %w", f.Name, err)
+	}
+
+	if report != nil {
+		tmpl, errs, normalized := config.ParseAll(f.Name, string(fileContent))
+		report.add(errs...)
+		report.Normalized = append(report.Normalized, normalized...)
+
+		return compiledPart{
+			file: f,
+			tmpl: tmpl,
+		}, nil
+	}
+
+	tmpl, err := config.Parse(f.Name, string(fileContent))
+	if err != nil {
+		return compiledPart{}, err
+	}
+
+	return compiledPart{
+		file: f,
+		tmpl: tmpl,
+	}, nil
+}
+
+// compileNumberedParts parses the sequentially numbered parts matching
+// filenameFormat (e.g. "word/header%d.xml"), starting from 1.
+func compileNumberedParts(docxZipMap goziputils.ZipMap, config docx.TemplateConfig, report *ValidationReport, filenameFormat string) ([]compiledPart, error) {
+	parts := []compiledPart{}
+	for i := 1; ; i++ {
+		f := docxZipMap[fmt.Sprintf(filenameFormat, i)]
+		if f == nil {
+			break
+		}
+
+		part, err := compilePart(f, config, report)
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, part)
+	}
+
+	return parts, nil
+}
+
+// compilePartsRels parses the rels files of the given parts, if present.
+func compilePartsRels(docxZipMap goziputils.ZipMap, parts []compiledPart) error {
+	for i := range parts {
+		f := docxZipMap[docx.RelsFilename(parts[i].file.Name)]
+		if f == nil {
+			continue
+		}
+
+		fileContent, err := goziputils.ReadZipFileContent(f)
+		if err != nil {
+			return fmt.Errorf("unable to read file '%s': %w", f.Name, err)
+		}
+
+		rel, err := docx.ParseRelationship(fileContent)
+		if err != nil {
+			return fmt.Errorf("unable to parse rels file '%s': %w", f.Name, err)
+		}
+
+		parts[i].relsFile = f
+		parts[i].rel = rel
+	}
+
+	return nil
+}
+
+// unmarshalTemplateValues decodes the template values if they are provided as JSON bytes.
+func unmarshalTemplateValues(templateValues any) (any, error) {
+	switch v := templateValues.(type) {
+	case []byte:
+		err := json.Unmarshal(v, &templateValues)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshalling templateValues: %w", err)
+		}
+	}
+
+	return templateValues, nil
+}
+
+// Render applies the provided values to the compiled template and returns the output DOCX file bytes.
+// The templateValues parameter can be any type that can be marshalled to JSON.
+// Render does not modify the compiled template and can be called concurrently.
+func (ct *compiledTemplate) Render(templateValues any) ([]byte, error) {
+	return ct.RenderContext(context.Background(), templateValues)
+}
+
+// RenderContext is like Render but stops rendering with ctx's error as soon as ctx is done,
+// cancellation is checked between parts and their processing steps, and while executing the template
+// expressions at each printed value and loop iteration. A running template function call can't be interrupted.
+func (ct *compiledTemplate) RenderContext(ctx context.Context, templateValues any) ([]byte, error) {
+	templateValues, err := unmarshalTemplateValues(templateValues)
+	if err != nil {
+		return nil, err
+	}
+
+	output := bytes.Buffer{}
+	err = ct.render(ctx, &output, templateValues, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// custom user post processing
+	if len(ct.filesPostProcessors) > 0 {
+		err := xml.ProcessedOutput(ct.filesPostProcessors, &output, "post")
+		if err != nil {
+			return nil, fmt.Errorf("unable to post-process output DOCX file: %w", err)
+		}
+
+		if ct.limits.MaxOutputSize > 0 && int64(output.Len()) > ct.limits.MaxOutputSize {
+			return nil, &LimitError{
+				Limit: docx.LimitMaxOutputSize,
+				Max:   ct.limits.MaxOutputSize,
+			}
+		}
+	}
+
+	return output.Bytes(), nil
+}
+
+// RenderTo applies the provided values to the compiled template and streams the output DOCX file to w.
+// When post-processors are set the output is buffered in memory before being written, since
+// they need to read it back.
+func (ct *compiledTemplate) RenderTo(w io.Writer, templateValues any) error {
+	return ct.RenderToContext(context.Background(), w, templateValues)
+}
+
+// RenderToContext is like RenderTo but stops rendering with ctx's error as soon as ctx is done.
+func (ct *compiledTemplate) RenderToContext(ctx context.Context, w io.Writer, templateValues any) error {
+	if len(ct.filesPostProcessors) > 0 {
+		output, err := ct.RenderContext(ctx, templateValues)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(output)
+		if err != nil {
+			return fmt.Errorf("unable to write output DOCX file: %w", err)
+		}
+
+		return nil
+	}
+
+	templateValues, err := unmarshalTemplateValues(templateValues)
+	if err != nil {
+		return err
+	}
+
+	return ct.render(ctx, w, templateValues, nil)
+}
+
+// renderState holds everything that changes while rendering a compiled template once.
+type renderState struct {
+	ctx       context.Context
+	limits    Limits
+	zipWriter *zip.Writer
+	document  *docx.DocumentMeta
+	// relMedia are the relationships to add to the rels file of each part
+	relMedia       map[string][]docx.MediaRel
+	images         int
+	xlsxChartsMeta xlsxChartsMap
+	templateValues any
+	report         *ValidationReport
+}
+
+// render writes the output DOCX zip into w. Every state that changes while
+// rendering (ids, relationships, charts values) is local to the call.
+// When report is not nil the errors of the single parts are collected into it
+// and the rendering goes on, see Validate.
+func (ct *compiledTemplate) render(ctx context.Context, w io.Writer, templateValues any, report *ValidationReport) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ct.limits.MaxOutputSize > 0 {
+		w = &limitedWriter{
+			w:   w,
+			max: ct.limits.MaxOutputSize,
+		}
+	}
+
+	rs := renderState{
+		ctx:            ctx,
+		limits:         ct.limits,
+		zipWriter:      zip.NewWriter(w),
+		document:       ct.document.Clone(),
+		relMedia:       make(map[string][]docx.MediaRel),
+		xlsxChartsMeta: make(xlsxChartsMap),
+		templateValues: templateValues,
+		report:         report,
+	}
+
+	// put loaded medias into the new docx file
+	for _, filename := range ct.mediaFilenames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		media := ct.media[filename]
+
+		filepath := path.Join("word/media", media.WordFilename)
+		err := goziputils.WriteFile(rs.zipWriter, filepath, media.Data)
+		if err != nil {
+			return fmt.Errorf("unable to write media file '%s': %w", filepath, err)
+		}
+	}
+
+	// raw copy of the untouched files, without decompressing them
+	for _, f := range ct.copiedFiles {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := rs.zipWriter.Copy(f)
+		if err != nil {
+			return fmt.Errorf("unable to copy original file '%s': %w", f.Name, err)
+		}
+	}
+
+	// Apply template to the XLSX files
+	for _, cx := range ct.xlsxFiles {
+		err := rs.fail(cx.writeIntoZip(&rs))
+		if err != nil {
+			return fmt.Errorf("unable to apply template to XLSX file '%s': %w", cx.file.Name, err)
+		}
+	}
+
+	// Apply template to the header files
+	for _, header := range ct.headers {
+		err := rs.fail(rs.renderPart(header))
+		if err != nil {
+			return fmt.Errorf("unable to apply template to header file '%s': %w", header.file.Name, err)
+		}
+	}
+
+	// Apply template to the footer files
+	for _, footer := range ct.footers {
+		err := rs.fail(rs.renderPart(footer))
+		if err != nil {
+			return fmt.Errorf("unable to apply template to footer file '%s': %w", footer.file.Name, err)
+		}
+	}
+
+	for _, parts := range [][]compiledPart{ct.headers, ct.footers} {
+		for _, part := range parts {
+			err := rs.writePartRels(part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Apply template to the main document file
+	err := rs.fail(rs.renderPart(ct.documentPart))
+	if err != nil {
+		return fmt.Errorf("unable to apply template to document file: %w", err)
+	}
+
+	// Apply template to the chart files
+	for _, chart := range ct.charts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fileContent, ok, err := rs.execute(chart.compiledPart)
+		if err != nil {
+			return fmt.Errorf("unable to apply template to chart file '%s': %w", chart.file.Name, err)
+		}
+		if !ok {
+			continue
+		}
+
+		fileContent, err = docx.UpdateChart(fileContent, rs.xlsxChartsMeta[chart.xlsxFileTarget])
+		if err != nil {
+			if err := rs.fail(err); err != nil {
+				return fmt.Errorf("unable to update preview chart file '%s': %w", chart.file.Name, err)
+			}
+			continue
+		}
+
+		err = goziputils.RewriteFileIntoZipWriter(rs.zipWriter, chart.file, fileContent)
+		if err != nil {
+			return fmt.Errorf("unable to rewrite chart file '%s': %w", chart.file.Name, err)
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// the generated parts (e.g. the lists definitions of word/numbering.xml) are created if missing
+	createdParts := []docx.GeneratedPart{}
+	for _, part := range rs.document.GeneratedParts() {
+		f := ct.generatedFiles[part.Name]
+		switch {
+		case part.Changed && f == nil:
+			err = goziputils.WriteFile(rs.zipWriter, part.Name, part.Content)
+			createdParts = append(createdParts, part)
+		case part.Changed:
+			err = goziputils.RewriteFileIntoZipWriter(rs.zipWriter, f, part.Content)
+		case f != nil:
+			err = rs.zipWriter.Copy(f)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to write file '%s': %w", part.Name, err)
+		}
+	}
+
+	contentTypesContent := ct.contentTypes
+	if len(createdParts) != 0 {
+		contentTypes, err := docx.ParseContentTypes(ct.contentTypes)
+		if err != nil {
+			return fmt.Errorf("unable to parse content types file '%s': %w", ct.contentTypesFile.Name, err)
+		}
+
+		for _, part := range createdParts {
+			contentTypes.AddOverrideUnique("/"+part.Name, part.ContentType)
+		}
+		contentTypesContent, err = contentTypes.ToXml()
+		if err != nil {
+			return fmt.Errorf("unable to marshal content types to XML: %w", err)
+		}
+	}
+
+	err = goziputils.RewriteFileIntoZipWriter(rs.zipWriter, ct.contentTypesFile, contentTypesContent)
+	if err != nil {
+		return fmt.Errorf("unable to replace content types file '%s': %w", ct.contentTypesFile.Name, err)
+	}
+
+	documentRelContent := ct.documentRelsContent
+	documentMedia := rs.relMedia[ct.documentPart.file.Name]
+	if len(documentMedia) != 0 || len(createdParts) != 0 {
+		rel := ct.rel.Clone()
+		rel.AddMediaToRels(documentMedia)
+		for _, part := range createdParts {
+			rel.AddGeneratedPart(part, fmt.Sprintf("rId%d", rs.document.NextRId()))
+		}
+
+		documentRelContent, err = rel.ToXml()
+		if err != nil {
+			return fmt.Errorf("unable to marshal rels: %w", err)
+		}
+	}
+
+	err = goziputils.RewriteFileIntoZipWriter(rs.zipWriter, ct.documentRelsFile, documentRelContent)
+	if err != nil {
+		return fmt.Errorf("unable to replace rel file '%s': %w", ct.documentRelsFile.Name, err)
+	}
+
+	err = rs.zipWriter.Close()
+	if err != nil {
+		return fmt.Errorf("unable to close zip writer: %w", err)
+	}
+
+	return nil
+}
+
+// renderPart executes a compiled document, header or footer part and writes it into the zip.
+func (rs *renderState) renderPart(part compiledPart) error {
+	if err := rs.ctx.Err(); err != nil {
+		return err
+	}
+
+	appliedTemplate, ok, err := rs.execute(part)
+	if err != nil || !ok {
+		return err
+	}
+
+	if err := rs.ctx.Err(); err != nil {
+		return err
+	}
+
+	output, media, err := rs.document.ProcessOutput(part.file.Name, appliedTemplate)
+	if err != nil {
+		return err
+	}
+
+	if err := rs.ctx.Err(); err != nil {
+		return err
+	}
+
+	if rs.report != nil {
+		rs.report.add(docx.UnresolvedPlaceholders(part.file.Name, output)...)
+	}
+
+	rs.relMedia[part.file.Name] = append(rs.relMedia[part.file.Name], media...)
+	for _, m := range media {
+		if m.Type == docx.ImageMediaType {
+			rs.images++
+		}
+	}
+	if rs.limits.MaxImages > 0 && rs.images > rs.limits.MaxImages {
+		return &LimitError{
+			Limit: docx.LimitMaxImages,
+			Max:   int64(rs.limits.MaxImages),
+			Part:  part.file.Name,
+		}
+	}
+
+	err = goziputils.RewriteFileIntoZipWriter(rs.zipWriter, part.file, output)
+	if err != nil {
+		return fmt.Errorf("unable to rewrite file '%s' in zip: %w", part.file.Name, err)
+	}
+
+	return nil
+}
+
+// writePartRels writes the rels file of a header or footer part along with the
+// relationships added while rendering it, creating the file if missing.
+func (rs *renderState) writePartRels(part compiledPart) error {
+	media := rs.relMedia[part.file.Name]
+	if len(media) == 0 {
+		if part.relsFile == nil {
+			return nil
+		}
+
+		err := rs.zipWriter.Copy(part.relsFile)
+		if err != nil {
+			return fmt.Errorf("unable to copy original file '%s': %w", part.relsFile.Name, err)
+		}
+
+		return nil
+	}
+
+	rel := &docx.Relationship{}
+	if part.rel != nil {
+		rel = part.rel.Clone()
+	}
+	rel.AddMediaToRels(media)
+
+	relContent, err := rel.ToXml()
+	if err != nil {
+		return fmt.Errorf("unable to marshal rels: %w", err)
+	}
+
+	if part.relsFile == nil {
+		err = goziputils.WriteFile(rs.zipWriter, docx.RelsFilename(part.file.Name), relContent)
+	} else {
+		err = goziputils.RewriteFileIntoZipWriter(rs.zipWriter, part.relsFile, relContent)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write rel file of '%s': %w", part.file.Name, err)
+	}
+
+	return nil
+}
+
+// execute executes the template of a compiled part. When validating, the execution
+// errors are collected into the report and ok is false if the part has no template
+// or its execution could not be completed, so that the part must be skipped.
+func (rs *renderState) execute(part compiledPart) (output []byte, ok bool, err error) {
+	if rs.report == nil {
+		output, err = docx.ExecuteXmlTemplate(rs.ctx, part.tmpl, rs.templateValues, rs.limits.MaxPartSize)
+
+		return output, err == nil, err
+	}
+
+	if part.tmpl == nil {
+		return nil, false, nil
+	}
+
+	output, errs, err := docx.ValidateXmlTemplate(rs.ctx, part.tmpl, rs.templateValues, rs.limits.MaxPartSize)
+	rs.report.add(errs...)
+
+	return output, output != nil, err
+}
+
+// fail returns err unless validating, in which case err is collected into the report
+// and nil is returned so that the rendering goes on. Context errors are always returned.
+func (rs *renderState) fail(err error) error {
+	if err == nil || rs.report == nil || rs.ctx.Err() != nil {
+		return err
+	}
+
+	rs.report.add(err)
+
+	return nil
+}
+
+// isSupportedMediaType reports whether the media file can be added to a DOCX file, i.e. it's a JPEG or PNG image.
+func isSupportedMediaType(filename string) bool {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".jpg", ".jpeg", ".jfif", ".png":
+		return true
+	}
+
+	return false
+}
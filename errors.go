@@ -0,0 +1,33 @@
+package gotemplatedocx
+
+import "github.com/JJJJJJack/go-template-docx/internal/docx"
+
+// TemplateError describes a template error located in the docx file: the part, the
+// expression, its paragraph or table cell and the visible text of the paragraph.
+// It can be inspected with errors.As.
+type TemplateError = docx.TemplateError
+
+type TemplateErrorKind = docx.TemplateErrorKind
+
+const (
+	TemplateErrorParse      = docx.TemplateErrorParse
+	TemplateErrorMissingKey = docx.TemplateErrorMissingKey
+	TemplateErrorFunc       = docx.TemplateErrorFunc
+	TemplateErrorExec       = docx.TemplateErrorExec
+)
+
+// MissingKeyPolicy is the way the template actions referencing a missing map key are executed,
+// see SetMissingKeyPolicy.
+type MissingKeyPolicy = docx.MissingKeyPolicy
+
+type MissingKeyMode = docx.MissingKeyMode
+
+const (
+	MissingKeyError       = docx.MissingKeyError
+	MissingKeyZero        = docx.MissingKeyZero
+	MissingKeyPlaceholder = docx.MissingKeyPlaceholder
+)
+
+// NormalizedExpression is a template expression in which the typographic characters inserted
+// by Word (e.g. smart quotes) were replaced by their ASCII equivalents, see ValidationReport.
+type NormalizedExpression = docx.NormalizedExpression
@@ -1,15 +1,14 @@
 package gotemplatedocx
 
 import (
-	"archive/zip"
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
-	"strings"
 	"text/template"
 
 	"github.com/JJJJJJack/go-template-docx/internal/docx"
@@ -19,39 +18,41 @@ import (
 )
 
 type docxTemplate struct {
-	input    bytes.Buffer
-	output   bytes.Buffer
-	rel      *docx.Relationship
-	relMedia []docx.MediaRel
+	input     io.ReaderAt
+	inputSize int64
+	output    bytes.Buffer
 	// filename : { data, wordFilename }
-	media               docx.MediaMap
-	xlsxChartsMeta      xlsxChartsMap
-	templateFuncs       template.FuncMap
-	filesPreProcessors  []xml.HandlersMap
-	filesPostProcessors []xml.HandlersMap
+	media                 docx.MediaMap
+	templateFuncs         *funcRegistry
+	filesPreProcessors    []xml.HandlersMap
+	filesPostProcessors   []xml.HandlersMap
+	limits                Limits
+	missingKey            MissingKeyPolicy
+	partsMissingKey       map[string]MissingKeyPolicy
+	delims                docx.Delims
+	trackChanges          TrackChanges
+	updateTableOfContents bool
 }
 
-// NewDocxTemplateFromBytes creates a new docxTemplate object from the provided DOCX file bytes.
-// The docxTemplate object can be used through the exposed high-level APIs.
-func NewDocxTemplateFromBytes(docxBytes []byte) (*docxTemplate, error) {
-	inputBuffer := bytes.Buffer{}
-
-	_, err := inputBuffer.Write(docxBytes)
-	if err != nil {
-		return nil, fmt.Errorf("unable to write DOCX bytes to buffer: %w", err)
-	}
-
+func newDocxTemplate(input io.ReaderAt, inputSize int64) *docxTemplate {
 	return &docxTemplate{
-		input:               inputBuffer,
+		input:               input,
+		inputSize:           inputSize,
 		output:              bytes.Buffer{},
-		media:               docx.MediaMap{},
-		rel:                 &docx.Relationship{},
-		relMedia:            []docx.MediaRel{},
-		xlsxChartsMeta:      make(xlsxChartsMap),
-		templateFuncs:       docx.TemplateFuncs,
+		media:               make(docx.MediaMap),
+		templateFuncs:       newFuncRegistry(),
 		filesPreProcessors:  []xml.HandlersMap{},
 		filesPostProcessors: []xml.HandlersMap{},
-	}, nil
+		partsMissingKey:     map[string]MissingKeyPolicy{},
+	}
+}
+
+// NewDocxTemplateFromBytes creates a new docxTemplate object from the provided DOCX file bytes.
+// The docxTemplate object can be used through the exposed high-level APIs.
+func NewDocxTemplateFromBytes(docxBytes []byte) (*docxTemplate, error) {
+	input := append([]byte(nil), docxBytes...)
+
+	return newDocxTemplate(bytes.NewReader(input), int64(len(input))), nil
 }
 
 // NewDocxTemplateFromFilename creates a new docxTemplate object from the provided DOCX filename (reading from disk).
@@ -62,27 +63,50 @@ func NewDocxTemplateFromFilename(docxFilename string) (*docxTemplate, error) {
 		return nil, fmt.Errorf("unable to read file %s: %w", docxFilename, err)
 	}
 
-	inputBuffer := bytes.Buffer{}
-	_, err = inputBuffer.Write(docxBytes)
+	return newDocxTemplate(bytes.NewReader(docxBytes), int64(len(docxBytes))), nil
+}
+
+// NewDocxTemplateFromReaderAt creates a new docxTemplate object reading the DOCX file
+// of the given size from r, without copying it into memory (e.g. from an *os.File).
+// r is read every time the template is compiled, so it must stay valid and unchanged
+// for the whole lifetime of the docxTemplate object.
+func NewDocxTemplateFromReaderAt(r io.ReaderAt, size int64) (*docxTemplate, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid DOCX size %d", size)
+	}
+
+	return newDocxTemplate(r, size), nil
+}
+
+// NewDocxTemplateFromFS creates a new docxTemplate object from the DOCX file
+// with the given name in fsys (e.g. an embed.FS or os.DirFS).
+// When the opened file is an io.ReaderAt of known size, like the files of embed.FS and os.DirFS,
+// it is not copied into memory but kept open and read every time the template is compiled,
+// as with NewDocxTemplateFromReaderAt. Otherwise the file is read into memory and closed.
+func NewDocxTemplateFromFS(fsys fs.FS, docxFilename string) (*docxTemplate, error) {
+	f, err := fsys.Open(docxFilename)
 	if err != nil {
-		return nil, fmt.Errorf("unable to write DOCX bytes to buffer: %w", err)
+		return nil, fmt.Errorf("unable to open file %s: %w", docxFilename, err)
 	}
 
-	return &docxTemplate{
-		input:               inputBuffer,
-		output:              bytes.Buffer{},
-		media:               make(docx.MediaMap),
-		rel:                 &docx.Relationship{},
-		relMedia:            []docx.MediaRel{},
-		xlsxChartsMeta:      make(xlsxChartsMap),
-		templateFuncs:       docx.TemplateFuncs,
-		filesPreProcessors:  []xml.HandlersMap{},
-		filesPostProcessors: []xml.HandlersMap{},
-	}, nil
+	if r, ok := f.(io.ReaderAt); ok {
+		info, err := f.Stat()
+		if err == nil && info.Mode().IsRegular() {
+			return newDocxTemplate(r, info.Size()), nil
+		}
+	}
+	defer f.Close()
+
+	docxBytes, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read file %s: %w", docxFilename, err)
+	}
+
+	return newDocxTemplate(bytes.NewReader(docxBytes), int64(len(docxBytes))), nil
 }
 
 // Media adds a media file to the docxTemplate object.
-// Supported media types are currently limited to JPEG and PNG images.
+// Supported media types are currently limited to JPEG and PNG images, the other ones make the compilation fail.
 // The filename match the string you pass in the template expression using the image function.
 // For example {{ image "computer.png" }} will load the docx.Media that have "computer.png" as its filename.
 // The data should be the byte content of the media file.
@@ -95,12 +119,90 @@ func (dt *docxTemplate) Media(filename string, data []byte) {
 	}
 }
 
+// MediaFromFS adds the media file with the given name in fsys (e.g. an embed.FS)
+// to the docxTemplate object, see Media.
+func (dt *docxTemplate) MediaFromFS(fsys fs.FS, filename string) error {
+	data, err := fs.ReadFile(fsys, filename)
+	if err != nil {
+		return fmt.Errorf("unable to read media file %s: %w", filename, err)
+	}
+
+	dt.Media(path.Base(filename), data)
+
+	return nil
+}
+
 // AddTemplateFuncs adds your custom template functions to evaluate when applying the template.
 // Existing functions will be shadowed if the same name is used.
+// The functions are only available to this docxTemplate object.
+// The names made of a namespace, "__" and a function name are reserved to the namespaced functions:
+// they can't be called directly in the templates.
 func (dt *docxTemplate) AddTemplateFuncs(funcMap template.FuncMap) {
-	for funcName, fn := range funcMap {
-		dt.templateFuncs[funcName] = fn
+	dt.templateFuncs.add(funcMap)
+}
+
+// AddNamespacedTemplateFuncs adds your custom template functions under the given namespace,
+// they can be called in the template expressions as {{namespace.funcName}}.
+// For example registering "formatIBAN" in the "acme" namespace enables {{acme.formatIBAN .IBAN}}.
+// The namespace and the functions names must be valid Go identifiers without "__": the functions
+// are registered as "namespace__funcName", the name reported by the execution errors of their calls.
+// Namespaced functions are never shadowed by the ones added with AddTemplateFuncs.
+func (dt *docxTemplate) AddNamespacedTemplateFuncs(namespace string, funcMap template.FuncMap) error {
+	return dt.templateFuncs.addNamespaced(namespace, funcMap)
+}
+
+// DisableBuiltinTemplateFuncs removes the given built-in template functions (e.g. "image")
+// from this docxTemplate object, or all of them if no function name is provided.
+// Custom functions added with AddTemplateFuncs are not affected.
+func (dt *docxTemplate) DisableBuiltinTemplateFuncs(funcNames ...string) {
+	dt.templateFuncs.disableBuiltins(funcNames...)
+}
+
+// SetMissingKeyPolicy sets how the template actions referencing a missing map key
+// (or a nil value along a fields chain) are executed in every part, by default they fail.
+// For example MissingKeyPolicy{Mode: MissingKeyPlaceholder, Placeholder: "N/A"} prints "N/A".
+func (dt *docxTemplate) SetMissingKeyPolicy(policy MissingKeyPolicy) {
+	dt.missingKey = policy
+}
+
+// SetPartMissingKeyPolicy overrides the missing key policy of the part with the given
+// name, e.g. "word/header1.xml" or "word/embeddings/Microsoft_Excel_Worksheet.xlsx".
+func (dt *docxTemplate) SetPartMissingKeyPolicy(partName string, policy MissingKeyPolicy) {
+	dt.partsMissingKey[partName] = policy
+}
+
+// SetDelims sets the delimiters of the template actions, "{{" and "}}" by default,
+// e.g. "[[" and "]]", "<<" and ">>" or "«" and "»". With other delimiters the literal
+// "{{" and "}}" found in the document are kept as text.
+// An empty delimiter is the default one.
+func (dt *docxTemplate) SetDelims(left, right string) error {
+	delims := docx.Delims{
+		Left:  left,
+		Right: right,
+	}
+
+	if left != "" && left == right {
+		return fmt.Errorf("invalid template delimiters '%s' and '%s': they must be different", left, right)
 	}
+
+	dt.delims = delims
+
+	return nil
+}
+
+// templateConfig returns the configuration to parse the templated parts with.
+func (dt *docxTemplate) templateConfig() docx.TemplateConfig {
+	config := dt.templateFuncs.config()
+
+	config.Delims = dt.delims
+	config.TrackChanges = dt.trackChanges.Author != ""
+	config.MissingKey = dt.missingKey
+	config.PartsMissingKey = make(map[string]docx.MissingKeyPolicy, len(dt.partsMissingKey))
+	for partName, policy := range dt.partsMissingKey {
+		config.PartsMissingKey[partName] = policy
+	}
+
+	return config
 }
 
 // AddPreProcessors adds XML pre-processing maps in which the key is the XML file path
@@ -120,11 +222,13 @@ func (dt *docxTemplate) AddPostProcessors(filesPostProcessors ...xml.HandlersMap
 // GetTemplateVariables extracts and returns all template variables used in the DOCX file
 // as a map.
 func (dt *docxTemplate) GetTemplateVariables() (map[string]struct{}, error) {
-	zipMap, err := goziputils.NewZipMapFromBytes(dt.input.Bytes())
+	zipMap, err := newZipMap(dt.input, dt.inputSize)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create DOCX zip map: %w", err)
 	}
 
+	config := dt.templateConfig()
+
 	vars := map[string]struct{}{}
 	for _, f := range zipMap {
 		b, err := goziputils.ReadZipFileContent(f)
@@ -132,12 +236,12 @@ func (dt *docxTemplate) GetTemplateVariables() (map[string]struct{}, error) {
 			return nil, fmt.Errorf("unable to read file '%s': %w", f.Name, err)
 		}
 
-		tmpl, err := template.New(path.Base(f.Name)).Funcs(dt.templateFuncs).Parse(docx.PatchXml(string(b)))
+		tmpl, err := config.Parse(path.Base(f.Name), string(b))
 		if err != nil {
-			return nil, fmt.Errorf("unable to parse template in file '%s': %w", f.Name, err)
+			return nil, err
 		}
 
-		x := docxtemplate.ExtractAllVariables(tmpl)
+		x := docxtemplate.ExtractAllVariables(tmpl.Template)
 		for k := range x {
 			vars[k] = struct{}{}
 		}
@@ -148,276 +252,45 @@ func (dt *docxTemplate) GetTemplateVariables() (map[string]struct{}, error) {
 
 // Apply applies the template with the provided values to the DOCX file.
 // The templateValues parameter can be any type that can be marshalled to JSON.
+// To render the same template many times, use Compile once and call Render on the result.
 func (dt *docxTemplate) Apply(templateValues any) error {
-	switch v := templateValues.(type) {
-	case []byte:
-		err := json.Unmarshal(v, &templateValues)
-		if err != nil {
-			return fmt.Errorf("error unmarshalling templateValues: %w", err)
-		}
-	}
-
-	// custom user pre processing
-	if len(dt.filesPreProcessors) > 0 {
-		err := xml.ProcessedOutput(dt.filesPreProcessors, &dt.input, "pre")
-		if err != nil {
-			return fmt.Errorf("unable to pre-process output DOCX file: %w", err)
-		}
-	}
-
-	zipWriter := zip.NewWriter(&dt.output)
-
-	docxZipMap, err := goziputils.NewZipMapFromBytes(dt.input.Bytes())
-	if err != nil {
-		return fmt.Errorf("unable to create DOCX zip map: %w", err)
-	}
-
-	document, err := docx.ParseDocumentMeta(docxZipMap, dt.templateFuncs)
-	if err != nil {
-		return fmt.Errorf("unable to parse document metadata: %w", err)
-	}
-
-	// put loaded medias into the new docx file, following docx naming convention with sequential numbers
-	for filename, media := range dt.media {
-		// assign each filename to its word convention equivalent path "word/media/imageN.ext"
-		imageN := document.NextImageNumber()
-		wordFilename := fmt.Sprintf("image%d%s", imageN, path.Ext(filename))
-
-		dt.media[filename].WordFilename = wordFilename
-
-		filepath := path.Join("word/media", media.WordFilename)
-		err := goziputils.WriteFile(zipWriter, filepath, media.Data)
-		if err != nil {
-			return fmt.Errorf("unable to write media file '%s': %w", filepath, err)
-		}
-	}
-	document.SetMediaMap(dt.media)
-
-	// Copy all files except the ones that will be processed
-	documentRelsFilename := "word/_rels/document.xml.rels"
-	contentTypesFilename := "[Content_Types].xml"
-	chartsMatcher := regexp.MustCompile(`word/charts/chart\d*?\.xml`)
-	xlsxMatcher := regexp.MustCompile(`/embeddings/Microsoft_Excel_Worksheet\d*?\.xlsx`)
-	headerFooterDocumentMatcher := regexp.MustCompile(`word/(header|footer|document)\d*?\.xml`)
-	for filename, f := range docxZipMap {
-		switch {
-		case
-			filename == documentRelsFilename,
-			filename == contentTypesFilename,
-			chartsMatcher.MatchString(filename),
-			xlsxMatcher.MatchString(filename),
-			headerFooterDocumentMatcher.MatchString(filename):
-			continue
-		}
-
-		err := goziputils.CopyFile(zipWriter, f)
-		if err != nil {
-			return fmt.Errorf("unable to copy original file '%s': %w", f.Name, err)
-		}
-	}
-
-	// Edit [Content_Types].xml if media files are provided
-	ctFile := docxZipMap[contentTypesFilename]
-	ctData, err := goziputils.ReadZipFileContent(ctFile)
-	if err != nil {
-		return fmt.Errorf("unable to read content types file '%s': %w", ctFile.Name, err)
-	}
-
-	contentTypes, err := docx.ParseContentTypes(ctData)
-	if err != nil {
-		return fmt.Errorf("unable to parse content types file '%s': %w", ctFile.Name, err)
-	}
-
-	for filename := range dt.media {
-		ext := path.Ext(filename)
-
-		switch lowerExt := strings.ToLower(ext); lowerExt {
-		case ".jpg", ".jpeg", ".jfif":
-			contentTypes.AddDefaultUnique(lowerExt[1:], "image/jpeg")
-		case ".png":
-			contentTypes.AddDefaultUnique("png", "image/png")
-		default:
-			fmt.Println("Unsupported media file type (only accepting jpg/png for now):", filename)
-			continue
-		}
-	}
-
-	updatedCt, err := contentTypes.ToXml()
-	if err != nil {
-		return fmt.Errorf("unable to marshal content types to XML: %w", err)
-	}
-
-	err = goziputils.RewriteFileIntoZipWriter(zipWriter, ctFile, []byte(updatedCt))
-	if err != nil {
-		return fmt.Errorf("unable to replace content types file '%s': %w", ctFile.Name, err)
-	}
-
-	relData, err := goziputils.ReadZipFileContent(docxZipMap[documentRelsFilename])
-	if err != nil {
-		return fmt.Errorf("unable to read rel file '%s': %w", documentRelsFilename, err)
-	}
-
-	dt.rel, err = docx.ParseRelationship(relData)
-	if err != nil {
-		return fmt.Errorf("unable to parse rel file '%s': %w", documentRelsFilename, err)
-	}
-
-	// Map chart files to their target XLSX files
-	chartRelToTargetXlsx := make(map[string]string)
-	for i := 1; ; i++ {
-		relsChartFilename := fmt.Sprintf("word/charts/_rels/chart%d.xml.rels", i)
-		f := docxZipMap[relsChartFilename]
-		if f == nil {
-			break
-		}
-
-		fileContent, err := goziputils.ReadZipFileContent(f)
-		if err != nil {
-			return fmt.Errorf("unable to read chart rel file '%s': %w", f.Name, err)
-		}
-
-		chartsRelationships, _ := docx.ParseRelationship(fileContent)
-		for _, relationship := range chartsRelationships.Relationships {
-			if !xlsxMatcher.MatchString(relationship.Target) {
-				continue
-			}
-
-			targetXlsxFilename := strings.Replace(relationship.Target, "../", "word/", 1)
-			chartFilename, err := docx.ExtractChartFilename(f.Name)
-			if err != nil {
-				return fmt.Errorf("unable to extract chart name from file '%s': %w", f.Name, err)
-			}
-			chartRelToTargetXlsx[chartFilename] = targetXlsxFilename
-		}
-	}
-
-	// Apply template to the XLSX files
-	for i := 0; ; i++ {
-		xlsxFilename := fmt.Sprintf("word/embeddings/Microsoft_Excel_Worksheet%d.xlsx", i)
-		if i == 0 {
-			xlsxFilename = "word/embeddings/Microsoft_Excel_Worksheet.xlsx"
-		}
-		f := docxZipMap[xlsxFilename]
-		if f == nil {
-			break
-		}
-
-		err := dt.writeXlsxIntoZip(f, zipWriter, templateValues)
-		if err != nil {
-			return fmt.Errorf("unable to apply template to XLSX file '%s': %w", f.Name, err)
-		}
-	}
-
-	// Apply template to the header files
-	for i := 1; ; i++ {
-		headerFilename := fmt.Sprintf("word/header%d.xml", i)
-		f := docxZipMap[headerFilename]
-		if f == nil {
-			break
-		}
-
-		media, err := document.ApplyTemplate(f, zipWriter, templateValues)
-		if err != nil {
-			return fmt.Errorf("unable to apply template to header file '%s': %w", f.Name, err)
-		}
-
-		dt.relMedia = append(dt.relMedia, media...)
-	}
-
-	// Apply template to the footer files
-	for i := 1; ; i++ {
-		footerFilename := fmt.Sprintf("word/footer%d.xml", i)
-		f := docxZipMap[footerFilename]
-		if f == nil {
-			break
-		}
-
-		media, err := document.ApplyTemplate(f, zipWriter, templateValues)
-		if err != nil {
-			return fmt.Errorf("unable to apply template to footer file '%s': %w", f.Name, err)
-		}
-
-		dt.relMedia = append(dt.relMedia, media...)
-	}
-
-	// Apply template to the main document file
-	documentFile := docxZipMap["word/document.xml"]
-	if documentFile == nil {
-		return fmt.Errorf("word/document.xml not found in the DOCX file")
-	}
+	return dt.ApplyContext(context.Background(), templateValues)
+}
 
-	media, err := document.ApplyTemplate(documentFile, zipWriter, templateValues)
+// ApplyContext is like Apply but stops rendering with ctx's error as soon as ctx is done,
+// cancellation is checked between parts and their processing steps, and while executing the template
+// expressions at each printed value and loop iteration. A running template function call can't be interrupted.
+func (dt *docxTemplate) ApplyContext(ctx context.Context, templateValues any) error {
+	compiled, err := dt.Compile()
 	if err != nil {
-		return fmt.Errorf("unable to apply template to document file: %w", err)
-	}
-
-	dt.relMedia = append(dt.relMedia, media...)
-
-	// Apply template to the chart files
-	for i := 1; ; i++ {
-		chartN := fmt.Sprintf("word/charts/chart%d.xml", i)
-
-		f := docxZipMap[chartN]
-		if f == nil {
-			break
-		}
-
-		fileContent, err := docx.ApplyTemplateToXml(f, templateValues, dt.templateFuncs)
-		if err != nil {
-			return fmt.Errorf("unable to apply template to chart file '%s': %w", f.Name, err)
-		}
-
-		chartFilename, err := docx.ExtractChartFilename(f.Name)
-		if err != nil {
-			return fmt.Errorf("unable to extract chart name from file '%s': %w", f.Name, err)
-		}
-
-		xlsxFileTarget := chartRelToTargetXlsx[chartFilename]
-		fileContent, err = docx.UpdateChart(fileContent, dt.xlsxChartsMeta[xlsxFileTarget])
-		if err != nil {
-			return fmt.Errorf("unable to update preview chart file '%s': %w", f.Name, err)
-		}
-
-		err = goziputils.RewriteFileIntoZipWriter(zipWriter, f, fileContent)
-		if err != nil {
-			return fmt.Errorf("unable to rewrite chart file '%s': %w", f.Name, err)
-		}
+		return err
 	}
 
-	documentRelFile := docxZipMap[documentRelsFilename]
-	documentRelContent, err := goziputils.ReadZipFileContent(documentRelFile)
+	output, err := compiled.RenderContext(ctx, templateValues)
 	if err != nil {
-		return fmt.Errorf("unable to read rel file '%s': %w", documentRelsFilename, err)
+		return err
 	}
 
-	if len(dt.relMedia) != 0 {
-		dt.rel.AddMediaToRels(dt.relMedia)
+	dt.output = *bytes.NewBuffer(output)
 
-		documentRelContent, err = dt.rel.ToXml()
-		if err != nil {
-			return fmt.Errorf("unable to marshal rels: %w", err)
-		}
-	}
+	return nil
+}
 
-	err = goziputils.RewriteFileIntoZipWriter(zipWriter, documentRelFile, documentRelContent)
-	if err != nil {
-		return fmt.Errorf("unable to replace rel file '%s': %w", documentRelsFilename, err)
-	}
+// ApplyTo applies the template with the provided values and streams the output
+// DOCX file to w (e.g. an http.ResponseWriter or an *os.File), without keeping
+// it in memory: Save and Bytes are not affected.
+func (dt *docxTemplate) ApplyTo(w io.Writer, templateValues any) error {
+	return dt.ApplyToContext(context.Background(), w, templateValues)
+}
 
-	err = zipWriter.Close()
+// ApplyToContext is like ApplyTo but stops rendering with ctx's error as soon as ctx is done.
+func (dt *docxTemplate) ApplyToContext(ctx context.Context, w io.Writer, templateValues any) error {
+	compiled, err := dt.Compile()
 	if err != nil {
-		return fmt.Errorf("unable to close zip writer: %w", err)
+		return err
 	}
 
-	// custom user post processing
-	if len(dt.filesPostProcessors) > 0 {
-		err := xml.ProcessedOutput(dt.filesPostProcessors, &dt.output, "post")
-		if err != nil {
-			return fmt.Errorf("unable to post-process output DOCX file: %w", err)
-		}
-	}
-
-	return nil
+	return compiled.RenderToContext(ctx, w, templateValues)
 }
 
 // Save saves the modified docx file to the specified filename.
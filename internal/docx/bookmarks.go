@@ -0,0 +1,151 @@
+package docx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	// bookmarkNameMaxLength is the maximum length of the bookmark names accepted by Word.
+	bookmarkNameMaxLength = 40
+
+	DOCX_BOOKMARK_INJECT_F = `</w:t></w:r><w:bookmarkStart w:id="[[BOOKMARK:%[1]s]]"/>` +
+		`<w:r><w:t xml:space="preserve">%[2]s</w:t></w:r><w:bookmarkEnd w:id="[[BOOKMARK:%[1]s]]"/><w:r><w:t>`
+)
+
+var (
+	// bookmarkPattern matches the bookmarks injected by bookmark, before their placeholders are resolved
+	bookmarkPattern = `<w:bookmarkStart w:id="\[\[BOOKMARK:\w*\]\]"/><w:r>(?:` + runPropsPattern + `)?<w:t[^>]*>[^<]*</w:t></w:r>` +
+		`<w:bookmarkEnd w:id="\[\[BOOKMARK:\w*\]\]"/>`
+	bookmarkRe = regexp.MustCompile(`<w:bookmarkStart w:id="\[\[BOOKMARK:(\w*)\]\]"/>(<w:r>(?:` + runPropsPattern + `)?<w:t[^>]*>([^<]*)</w:t></w:r>)` +
+		`<w:bookmarkEnd w:id="\[\[BOOKMARK:\w*\]\]"/>`)
+	bookmarkStartRe  = regexp.MustCompile(`<w:bookmarkStart\b[^>]*>`)
+	bookmarkIdAttrRe = regexp.MustCompile(`\bw:id="(\d+)"`)
+	bookmarkNameRe   = regexp.MustCompile(`\bw:name="([^"]*)"`)
+)
+
+// bookmark marks the given text with a bookmark named name, the target of the links inserted with linkTo.
+// The bookmarks created more than once with the same name (e.g. inside a range) or whose name is already
+// used by the template are renamed with a numbered suffix ("name_2", "name_3"...).
+func bookmark(name, text string) (string, error) {
+	bookmarkName, err := sanitizeBookmarkName(name)
+	if err != nil {
+		return "", fmt.Errorf("func 'bookmark': %w", err)
+	}
+
+	return fmt.Sprintf(DOCX_BOOKMARK_INJECT_F, bookmarkName, text), nil
+}
+
+// sanitizeBookmarkName replaces the characters not allowed in the bookmark names with underscores,
+// the names not starting with a letter get an underscore prefix (which hides them in Word).
+func sanitizeBookmarkName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("empty bookmark name")
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+
+	if r := []rune(name)[0]; !unicode.IsLetter(r) && r != '_' {
+		name = "_" + name
+	}
+
+	return name, nil
+}
+
+// truncateBookmarkName truncates the bookmark name so that it fits the maximum length along with the suffix.
+func truncateBookmarkName(name, suffix string) string {
+	runes := []rune(name)
+	if limit := bookmarkNameMaxLength - len(suffix); len(runes) > limit {
+		runes = runes[:limit]
+	}
+
+	return string(runes) + suffix
+}
+
+// parseBookmarks collects the ids and the names of the bookmarks of the XML part,
+// so that the created bookmarks don't collide with them.
+func (d *DocumentMeta) parseBookmarks(srcXML string) error {
+	for _, tag := range bookmarkStartRe.FindAllString(srcXML, -1) {
+		if m := bookmarkIdAttrRe.FindStringSubmatch(tag); m != nil {
+			id, err := strconv.ParseUint(m[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("could not parse bookmark id '%s': %w", m[1], err)
+			}
+
+			if id > d.greaterBookmarkId {
+				d.greaterBookmarkId = id
+			}
+		}
+
+		if m := bookmarkNameRe.FindStringSubmatch(tag); m != nil {
+			d.bookmarkNames[m[1]] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// nextBookmark returns a new bookmark id and the unique name of a new bookmark with the given name.
+func (d *DocumentMeta) nextBookmark(name string) (uint64, string) {
+	d.greaterBookmarkId++
+
+	unique := truncateBookmarkName(name, "")
+	for i := 2; ; i++ {
+		if _, ok := d.bookmarkNames[unique]; !ok {
+			break
+		}
+		unique = truncateBookmarkName(name, "_"+strconv.Itoa(i))
+	}
+
+	d.bookmarkNames[unique] = struct{}{}
+	d.bookmarks[name] = append(d.bookmarks[name], unique)
+
+	return d.greaterBookmarkId, unique
+}
+
+// bookmarkTarget returns the name of the bookmark targeted by the n-th link (starting from 1)
+// to the given name: the n-th bookmark created with that name, or the last one if there are fewer.
+// When no bookmark was created with that name the link targets a bookmark of the template.
+func (d *DocumentMeta) bookmarkTarget(name string, n int) string {
+	names := d.bookmarks[name]
+	switch {
+	case len(names) == 0:
+		return truncateBookmarkName(name, "")
+	case n > len(names):
+		return names[len(names)-1]
+	}
+
+	return names[n-1]
+}
+
+// applyBookmarks replaces the [[BOOKMARK:name]] placeholders with the ids of new bookmarks and sets their
+// unique names, the run split by a bookmark keeps its properties on the bookmarked text and after it.
+func (d *DocumentMeta) applyBookmarks(srcXML string) string {
+	if !bookmarkRe.MatchString(srcXML) {
+		return srcXML
+	}
+
+	srcXML = keepSplitRunProps(srcXML, bookmarkPattern)
+	srcXML = removeEmptyRunsAround(srcXML, bookmarkPattern)
+
+	return bookmarkRe.ReplaceAllStringFunc(srcXML, func(match string) string {
+		m := bookmarkRe.FindStringSubmatch(match)
+		id, name := d.nextBookmark(m[1])
+
+		run := m[2]
+		if m[3] == "" {
+			run = ""
+		}
+
+		return fmt.Sprintf(`<w:bookmarkStart w:id="%d" w:name="%s"/>%s<w:bookmarkEnd w:id="%d"/>`, id, name, run, id)
+	})
+}
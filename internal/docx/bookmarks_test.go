@@ -0,0 +1,75 @@
+package docx
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var testBookmarkRe = regexp.MustCompile(`<w:bookmarkStart w:id="(\d+)" w:name="([^"]*)"/>|w:anchor="([^"]*)"`)
+
+// bookmarksAndLinks returns the "id:name" of the bookmarks and the "#name" of the links of the given XML, in order.
+func bookmarksAndLinks(content string) []string {
+	found := []string{}
+	for _, m := range testBookmarkRe.FindAllStringSubmatch(content, -1) {
+		if m[3] != "" {
+			found = append(found, "#"+m[3])
+		} else {
+			found = append(found, m[1]+":"+m[2])
+		}
+	}
+
+	return found
+}
+
+func TestBookmarks(t *testing.T) {
+	data := map[string]any{"Sections": []string{"One", "Two"}}
+
+	tests := []struct {
+		name       string
+		body       string
+		want       []string
+		paragraphs []string
+	}{
+		{
+			name:       "link to a bookmark",
+			body:       p(`{{linkTo "Intro" "see the intro"}}`) + p(`Chapter: {{bookmark "Intro" "Introduction"}}.`),
+			want:       []string{"#Intro", "1:Intro"},
+			paragraphs: []string{"see the intro", "Chapter: Introduction."},
+		},
+		{
+			name: "name used by the template",
+			body: `<w:p><w:bookmarkStart w:id="5" w:name="Intro"/>` + `<w:r><w:t>old</w:t></w:r><w:bookmarkEnd w:id="5"/></w:p>` +
+				p(`{{bookmark "Intro" "new"}}`) + p(`{{linkTo "Intro" "link"}}`) + p(`{{linkTo "_GoBack" "template"}}`),
+			want:       []string{"5:Intro", "6:Intro_2", "#Intro_2", "#_GoBack"},
+			paragraphs: []string{"old", "new", "link", "template"},
+		},
+		{
+			name: "repeated bookmarks",
+			body: p(`{{range .Sections}}{{linkTo "Section" .}} {{end}}`) +
+				`{{range .Sections}}` + p(`{{bookmark "Section" .}}`) + `{{end}}`,
+			want:       []string{"#Section", "#Section_2", "1:Section", "2:Section_2"},
+			paragraphs: []string{"One Two ", "One", "Two"},
+		},
+		{
+			name:       "sanitized names",
+			body:       p(`{{bookmark "1 a-b" "x"}}{{linkTo "1 a-b" "y"}}`),
+			want:       []string{"1:_1_a_b", "#_1_a_b"},
+			paragraphs: []string{"xy"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := renderTestDocument(t, tt.body, data)
+
+			if got := bookmarksAndLinks(output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got bookmarks and links %q, want %q\n%s", got, tt.want, output)
+			}
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+		})
+	}
+}
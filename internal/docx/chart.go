@@ -1,15 +1,10 @@
 package docx
 
 import (
-	"archive/zip"
-	"bytes"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
-
-	goziputils "github.com/JJJJJJack/go-zip-utils"
 )
 
 // TODO: parse and unmarshal xml instead of using regex
@@ -53,28 +48,6 @@ func UpdateChart(fileContent []byte, cellAndValues map[string]string) ([]byte, e
 	return updated, nil
 }
 
-func ApplyTemplateToXml(f *zip.File, templateValues any, templateFuncs template.FuncMap) ([]byte, error) {
-	fileContent, err := goziputils.ReadZipFileContent(f)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read chart file '%s': %w", f.Name, err)
-	}
-
-	tmpl, err := template.New(f.Name).
-		Option("missingkey=error").
-		Funcs(templateFuncs).
-		Parse(PatchXml(string(fileContent)))
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse template: %w", err)
-	}
-
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, templateValues); err != nil {
-		return nil, fmt.Errorf("unable to execute template: %w", err)
-	}
-
-	return buf.Bytes(), nil
-}
-
 // ExtractChartFilename now only works with a single submatch
 func ExtractChartFilename(path string) (string, error) {
 	re := regexp.MustCompile(`(chart\d+)\.xml`)
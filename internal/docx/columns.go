@@ -0,0 +1,300 @@
+package docx
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ColRangeKeyword is the keyword of the column loops, e.g. "{{colRange .Months}}"
+// placed in a table cell to repeat its column, in every row of the table, once per item.
+const ColRangeKeyword = "colRange"
+
+const (
+	colRangeVariablePrefix = "$__colRange"
+	colRangeWidthFunc      = "__colRangeWidth"
+	colRangeSpanWidthFunc  = "__colRangeSpanWidth"
+	colRangeGridSpanFunc   = "__colRangeGridSpan"
+)
+
+// colRangeFuncs are the functions called by the actions generated by expandColRanges.
+var colRangeFuncs = map[string]any{
+	colRangeWidthFunc:     colRangeWidth,
+	colRangeSpanWidthFunc: colRangeSpanWidth,
+	colRangeGridSpanFunc:  colRangeGridSpan,
+}
+
+var (
+	colRangeDeclarationRe = regexp.MustCompile(`(?s)^(\$\w*(?:\s*,\s*\$\w*)?\s*:?=)\s*(.*)$`)
+	xmlWidthAttrRe        = regexp.MustCompile(`\bw:w="(\d+)"`)
+	xmlValAttrRe          = regexp.MustCompile(`\bw:val="(\d+)"`)
+	xmlTypeAttrRe         = regexp.MustCompile(`\bw:type="(\w+)"`)
+)
+
+type colRangeCell struct {
+	start, end int
+	// gridStart is the index of the first grid column covered by the cell
+	gridStart int
+	span      int
+	// the <w:gridSpan/> and <w:tcW/> tokens, -1 if missing
+	gridSpanToken int
+	widthToken    int
+}
+
+type colRangeTable struct {
+	start      int
+	widthToken int
+	gridCols   []int
+	rows       [][]*colRangeCell
+	// gridBefore is the number of grid columns skipped before the first cell of each row
+	gridBefore []int
+	marker     *colRangeCell
+	// action is the column loop found in the marker cell, at location in the original XML source
+	action   string
+	location sourceLocation
+}
+
+// expandColRanges replaces the column loop found in a table cell with range actions wrapping
+// the cells covering the same grid columns in every row of the table, so the whole column is
+// repeated once per item. The loop pipeline is evaluated once, before the table.
+// The grid columns are repeated too and, like the widths of the table and of the cells,
+// they are shrunk so the table fits maxWidth twips (0 means no limit) once rendered;
+// the cells spanning the repeated columns (e.g. a title in a header row) get a wider gridSpan.
+// Only the first column loop of each table is expanded.
+func expandColRanges(tokens []xmlToken, d Delims, maxWidth int) {
+	keywordRe := d.actionKeywordRe()
+	actionRe := d.actionRe()
+	tables := []*colRangeTable{}
+	count := 0
+	for i, token := range tokens {
+		var table *colRangeTable
+		if len(tables) > 0 {
+			table = tables[len(tables)-1]
+		}
+
+		var row []*colRangeCell
+		var cell *colRangeCell
+		if table != nil && len(table.rows) > 0 {
+			row = table.rows[len(table.rows)-1]
+			if len(row) > 0 && row[len(row)-1].end < 0 {
+				cell = row[len(row)-1]
+			}
+		}
+
+		name := token.qualifiedName()
+		switch {
+		case token.isOpening() && name == "w:tbl":
+			tables = append(tables, &colRangeTable{start: i, widthToken: -1})
+		case token.isClosing() && name == "w:tbl" && table != nil:
+			tables = tables[:len(tables)-1]
+			if table.marker != nil {
+				table.expand(tokens, d, count, maxWidth)
+				count++
+			}
+		case table == nil:
+			continue
+		case name == "w:tblW" && len(table.rows) == 0:
+			table.widthToken = i
+		case name == "w:gridCol" && len(table.rows) == 0:
+			table.gridCols = append(table.gridCols, i)
+		case token.isOpening() && name == "w:tr":
+			table.rows = append(table.rows, []*colRangeCell{})
+			table.gridBefore = append(table.gridBefore, 0)
+		case name == "w:gridBefore" && len(table.rows) > 0 && cell == nil:
+			table.gridBefore[len(table.rows)-1] = xmlIntAttr(token.value, xmlValAttrRe, 0)
+		case token.isOpening() && name == "w:tc" && len(table.rows) > 0:
+			gridStart := table.gridBefore[len(table.rows)-1]
+			if len(row) > 0 {
+				last := row[len(row)-1]
+				gridStart = last.gridStart + last.span
+			}
+			table.rows[len(table.rows)-1] = append(row, &colRangeCell{
+				start:         i,
+				end:           -1,
+				gridStart:     gridStart,
+				span:          1,
+				gridSpanToken: -1,
+				widthToken:    -1,
+			})
+		case cell == nil:
+			continue
+		case token.isClosing() && name == "w:tc":
+			cell.end = i
+		case name == "w:gridSpan":
+			cell.span = xmlIntAttr(token.value, xmlValAttrRe, 1)
+			cell.gridSpanToken = i
+		case name == "w:tcW":
+			cell.widthToken = i
+		case token.patched && !token.isTag && table.marker == nil:
+			tokens[i].value = actionRe.ReplaceAllStringFunc(token.value, func(action string) string {
+				m := keywordRe.FindStringSubmatchIndex(action)
+				if table.marker != nil || m == nil || action[m[2]:m[3]] != ColRangeKeyword {
+					return action
+				}
+
+				table.marker = cell
+				table.action = action
+				table.location = token.location
+				return ""
+			})
+		}
+	}
+}
+
+// expand wraps the cells covering the marker cell grid columns with range actions.
+func (t *colRangeTable) expand(tokens []xmlToken, d Delims, n int, maxWidth int) {
+	variable := colRangeVariablePrefix + strconv.Itoa(n)
+
+	// e.g. "{{colRange $i, $month := .Months -}}" declares "$i, $month :=" and evaluates ".Months"
+	pipeline := strings.TrimSpace(t.action[len(d.Left) : len(t.action)-len(d.Right)])
+	pipeline = strings.TrimSpace(strings.TrimPrefix(pipeline, "- "))
+	pipeline = strings.TrimSpace(strings.TrimPrefix(pipeline, ColRangeKeyword))
+	pipeline = strings.TrimSpace(strings.TrimSuffix(pipeline, " -"))
+	declaration := ""
+	if m := colRangeDeclarationRe.FindStringSubmatch(pipeline); m != nil {
+		declaration, pipeline = m[1]+" ", m[2]
+	}
+
+	first, last := t.marker.gridStart, t.marker.gridStart+t.marker.span
+	isRepeated := func(col int) bool { return col >= first && col < last }
+
+	groupWidth, fixedWidth := 0, 0
+	for col, gridCol := range t.gridCols {
+		width := xmlIntAttr(tokens[gridCol].value, xmlWidthAttrRe, 0)
+		if isRepeated(col) {
+			groupWidth += width
+		} else {
+			fixedWidth += width
+		}
+	}
+
+	// widthAction returns the action computing the rendered width of a repeated
+	// column (or cell), or of a cell (or table) spanning the repeated columns
+	widthAction := func(width int, spanning bool) string {
+		funcName := colRangeWidthFunc
+		if spanning {
+			funcName = colRangeSpanWidthFunc
+		}
+		return d.action(fmt.Sprintf("%s %d %d %d %d %s", funcName, width, groupWidth, fixedWidth, maxWidth, variable))
+	}
+
+	// setWidth replaces the dxa width of the token with the width action
+	setWidth := func(i int, spanning bool) {
+		if i < 0 {
+			return
+		}
+		if m := xmlTypeAttrRe.FindStringSubmatch(tokens[i].value); m != nil && m[1] != "dxa" {
+			return
+		}
+		m := xmlWidthAttrRe.FindStringSubmatch(tokens[i].value)
+		if m == nil {
+			return
+		}
+		width, _ := strconv.Atoi(m[1])
+		tokens[i] = tokens[i].patchedWith(strings.Replace(tokens[i].value, m[0], `w:w="`+widthAction(width, spanning)+`"`, 1))
+	}
+
+	wrap := func(start, end int, rangeAction string) {
+		tokens[start] = tokens[start].patchedWith(rangeAction + tokens[start].value)
+		tokens[end] = tokens[end].patchedWith(tokens[end].value + d.action("end"))
+	}
+
+	for _, row := range t.rows {
+		for _, cell := range row {
+			cellFirst, cellLast := cell.gridStart, cell.gridStart+cell.span
+			switch {
+			case cellFirst == first && cellLast == last && cell.end >= 0:
+				setWidth(cell.widthToken, false)
+				wrap(cell.start, cell.end, d.action("range "+declaration+variable))
+			case cellFirst <= first && cellLast >= last && cell.gridSpanToken >= 0:
+				setWidth(cell.widthToken, true)
+				tokens[cell.gridSpanToken] = tokens[cell.gridSpanToken].patchedWith(xmlValAttrRe.ReplaceAllLiteralString(tokens[cell.gridSpanToken].value,
+					`w:val="`+d.action(fmt.Sprintf("%s %d %d %s", colRangeGridSpanFunc, cell.span, t.marker.span, variable))+`"`))
+			}
+		}
+	}
+
+	for col, gridCol := range t.gridCols {
+		if isRepeated(col) {
+			setWidth(gridCol, false)
+		}
+	}
+	if last <= len(t.gridCols) {
+		wrap(t.gridCols[first], t.gridCols[last-1], d.action("range "+variable))
+	}
+
+	setWidth(t.widthToken, true)
+
+	tokens[t.start] = tokens[t.start].patchedWith(d.action(variable+" := "+pipeline) + tokens[t.start].value)
+	tokens[t.start].location = t.location
+}
+
+// xmlIntAttr returns the integer value of the attribute matched by attrRe in the tag, or def.
+func xmlIntAttr(tag string, attrRe *regexp.Regexp, def int) int {
+	m := attrRe.FindStringSubmatch(tag)
+	if m == nil {
+		return def
+	}
+
+	v, err := strconv.Atoi(m[1])
+	if err != nil {
+		return def
+	}
+
+	return v
+}
+
+// colRangeLen returns the number of items of a column loop.
+func colRangeLen(items any) (int, error) {
+	v := reflect.ValueOf(items)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return 0, nil
+	case reflect.Array, reflect.Slice, reflect.Map:
+		return v.Len(), nil
+	}
+
+	return 0, fmt.Errorf("func '%s': can't iterate over %v", ColRangeKeyword, items)
+}
+
+// colRangeScale returns the factor by which the repeated columns are shrunk to fit maxWidth.
+func colRangeScale(groupWidth, fixedWidth, maxWidth, n int) float64 {
+	total := groupWidth * n
+	if maxWidth <= 0 || total == 0 || fixedWidth+total <= maxWidth || maxWidth <= fixedWidth {
+		return 1
+	}
+
+	return float64(maxWidth-fixedWidth) / float64(total)
+}
+
+// colRangeWidth returns the rendered width of a repeated grid column or cell.
+func colRangeWidth(width, groupWidth, fixedWidth, maxWidth int, items any) (int, error) {
+	n, err := colRangeLen(items)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(float64(width) * colRangeScale(groupWidth, fixedWidth, maxWidth, n)), nil
+}
+
+// colRangeSpanWidth returns the rendered width of a cell, or table, spanning the repeated columns.
+func colRangeSpanWidth(width, groupWidth, fixedWidth, maxWidth int, items any) (int, error) {
+	n, err := colRangeLen(items)
+	if err != nil {
+		return 0, err
+	}
+
+	return width - groupWidth + int(float64(groupWidth*n)*colRangeScale(groupWidth, fixedWidth, maxWidth, n)), nil
+}
+
+// colRangeGridSpan returns the rendered gridSpan of a cell spanning the repeated columns.
+func colRangeGridSpan(span, groupSpan int, items any) (int, error) {
+	n, err := colRangeLen(items)
+	if err != nil {
+		return 0, err
+	}
+
+	return span + (n-1)*groupSpan, nil
+}
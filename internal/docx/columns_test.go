@@ -0,0 +1,90 @@
+package docx
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var testGridColRe = regexp.MustCompile(`<w:gridCol w:w="(\d+)"/>`)
+
+// gridWidths returns the widths of the grid columns of the given XML.
+func gridWidths(t *testing.T, content string) []int {
+	t.Helper()
+
+	widths := []int{}
+	for _, m := range testGridColRe.FindAllStringSubmatch(content, -1) {
+		w, err := strconv.Atoi(m[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		widths = append(widths, w)
+	}
+
+	return widths
+}
+
+func TestColRange(t *testing.T) {
+	data := map[string]any{
+		"Months": []string{"Jan", "Feb", "Mar"},
+		"Values": map[string]int{"Jan": 1, "Feb": 2, "Mar": 3},
+	}
+
+	body := tbl([]int{2000, 1000},
+		tr(tc(p("Name")), tc(p("{{colRange .Months}}{{.}}"))),
+		tr(tc(p("x")), tc(p("{{index $.Values .}}"))),
+	)
+
+	tests := []struct {
+		name     string
+		maxWidth int
+		widths   []int
+	}{
+		{
+			name:   "unlimited width",
+			widths: []int{2000, 1000, 1000, 1000},
+		},
+		{
+			name:     "shrunk to the max width",
+			maxWidth: 3000,
+			widths:   []int{2000, 333, 333, 333},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDocumentMeta(t, body, nil)
+			output := renderTestPart(t, d, TemplateConfig{MaxTableWidth: tt.maxWidth}, "word/document.xml", testDocument(body), data)
+
+			want := [][]string{{"Name", "Jan", "Feb", "Mar"}, {"x", "1", "2", "3"}}
+			if got := rowsTexts(output); !reflect.DeepEqual(got, want) {
+				t.Errorf("got rows %q, want %q\n%s", got, want, output)
+			}
+
+			if got := gridWidths(t, output); !reflect.DeepEqual(got, tt.widths) {
+				t.Errorf("got grid widths %v, want %v\n%s", got, tt.widths, output)
+			}
+		})
+	}
+}
+
+func TestColRangeSpanningCell(t *testing.T) {
+	data := map[string]any{"Months": []string{"Jan", "Feb"}}
+
+	body := tbl([]int{2000, 1000},
+		tr(`<w:tc><w:tcPr><w:tcW w:w="3000" w:type="dxa"/><w:gridSpan w:val="2"/></w:tcPr>`+p("Title")+`</w:tc>`),
+		tr(tc(p("Name")), tc(p("{{colRange .Months}}{{.}}"))),
+	)
+
+	output := renderTestDocument(t, body, data)
+
+	want := [][]string{{"Title"}, {"Name", "Jan", "Feb"}}
+	if got := rowsTexts(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("got rows %q, want %q\n%s", got, want, output)
+	}
+
+	if !regexp.MustCompile(`<w:tcW w:w="4000" w:type="dxa"/><w:gridSpan w:val="3"/>`).MatchString(output) {
+		t.Errorf("the title cell doesn't span the repeated columns\n%s", output)
+	}
+}
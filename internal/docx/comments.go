@@ -0,0 +1,229 @@
+package docx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	goziputils "github.com/JJJJJJack/go-zip-utils"
+)
+
+const (
+	// CommentsContentType and CommentsExtendedContentType are the content types
+	// of word/comments.xml and word/commentsExtended.xml.
+	CommentsContentType         = "application/vnd.openxmlformats-officedocument.wordprocessingml.comments+xml"
+	CommentsExtendedContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.commentsExtended+xml"
+
+	DOCX_COMMENT_INJECT_F = `</w:t></w:r><w:commentRangeStart w:id="[[COMMENT:%[1]s]]"/>%[2]s<w:commentRangeEnd w:id="[[COMMENT:%[1]s]]"/>` +
+		`<w:r><w:rPr><w:rStyle w:val="CommentReference"/></w:rPr><w:commentReference w:id="[[COMMENT:%[1]s]]"/></w:r><w:r><w:t>`
+
+	// commentDateLayout is the layout of the comments dates
+	commentDateLayout = "2006-01-02T15:04:05Z"
+)
+
+var (
+	// commentPattern matches the comments injected by comment and commentOn, before their placeholders are resolved
+	commentPattern = `<w:commentRangeStart w:id="\[\[COMMENT:[\w+/=]*\]\]"/>(?:<w:r>(?:` + runPropsPattern + `)?<w:t[^>]*>[^<]*</w:t></w:r>)?` +
+		`<w:commentRangeEnd w:id="\[\[COMMENT:[\w+/=]*\]\]"/>` +
+		`<w:r><w:rPr><w:rStyle w:val="CommentReference"/></w:rPr><w:commentReference w:id="\[\[COMMENT:[\w+/=]*\]\]"/></w:r>`
+	commentRe = regexp.MustCompile(`<w:commentRangeStart w:id="\[\[COMMENT:([\w+/=]*)\]\]"/>((?:<w:r>(?:` + runPropsPattern + `)?<w:t[^>]*>([^<]*)</w:t></w:r>)?)` +
+		`<w:commentRangeEnd w:id="\[\[COMMENT:[\w+/=]*\]\]"/>` +
+		`<w:r><w:rPr><w:rStyle w:val="CommentReference"/></w:rPr><w:commentReference w:id="\[\[COMMENT:[\w+/=]*\]\]"/></w:r>`)
+	commentIdRe = regexp.MustCompile(`<w:comment\b[^>]*\bw:id="(\d+)"`)
+)
+
+// commentData is the content of a comment, encoded in the [[COMMENT:...]] placeholders.
+type commentData struct {
+	Text   string `json:"t"`
+	Author string `json:"a"`
+}
+
+// comment inserts a Word review comment with the given text and author at the call site.
+func comment(text, author string) (string, error) {
+	return commentPlaceholder("comment", "", text, author)
+}
+
+// commentOn inserts the anchor text along with a Word review comment on it, with the given text and author.
+func commentOn(anchor, text, author string) (string, error) {
+	return commentPlaceholder("commentOn", `<w:r><w:t xml:space="preserve">`+anchor+`</w:t></w:r>`, text, author)
+}
+
+// commentPlaceholder returns the comment range, wrapping the given anchor run, and the reference
+// to the comment, whose placeholders hold the comment text and author.
+func commentPlaceholder(funcName, anchorRun, text, author string) (string, error) {
+	data, err := json.Marshal(commentData{Text: text, Author: author})
+	if err != nil {
+		return "", fmt.Errorf("func '%s': unable to encode the comment: %w", funcName, err)
+	}
+
+	return fmt.Sprintf(DOCX_COMMENT_INJECT_F, base64.StdEncoding.EncodeToString(data), anchorRun), nil
+}
+
+// comments holds the comments of word/comments.xml and the ones added while rendering,
+// along with their word/commentsExtended.xml entries when the document has that part.
+type comments struct {
+	// source and extendedSource are the contents of comments.xml and commentsExtended.xml,
+	// empty if the document doesn't have them
+	source         string
+	extendedSource string
+	maxId          int
+	added          []string
+	addedExtended  []string
+}
+
+// parseCommentsFiles returns the comments of word/comments.xml and word/commentsExtended.xml, if present.
+func parseCommentsFiles(zm goziputils.ZipMap) (comments, error) {
+	c := comments{maxId: -1}
+	for filename, source := range map[string]*string{
+		"word/comments.xml":         &c.source,
+		"word/commentsExtended.xml": &c.extendedSource,
+	} {
+		f := zm[filename]
+		if f == nil {
+			continue
+		}
+
+		content, err := goziputils.ReadZipFileContent(f)
+		if err != nil {
+			return comments{}, fmt.Errorf("could not read zip file content: %w", err)
+		}
+
+		*source = string(content)
+	}
+
+	for _, m := range commentIdRe.FindAllStringSubmatch(c.source, -1) {
+		if id, err := strconv.Atoi(m[1]); err == nil && id > c.maxId {
+			c.maxId = id
+		}
+	}
+
+	return c, nil
+}
+
+// clone returns a copy of the comments that can be extended without affecting the original.
+func (c comments) clone() comments {
+	c.added = append([]string(nil), c.added...)
+	c.addedExtended = append([]string(nil), c.addedExtended...)
+
+	return c
+}
+
+// add adds a comment with the given paragraph properties and content and returns its id.
+// The comment paragraph gets a paraId to be referenced by commentsExtended.xml, when the document has it.
+func (c *comments) add(author, date, pPr, content string) int {
+	c.maxId++
+
+	paragraph := "<w:p>"
+	if c.extendedSource != "" && strings.Contains(c.source, `xmlns:w14="`) {
+		paraId := fmt.Sprintf("%08X", bijective32(uint32(c.maxId)+1)&0x7FFFFFFF)
+		paragraph = `<w:p w14:paraId="` + paraId + `" w14:textId="77777777">`
+		c.addedExtended = append(c.addedExtended, `<w15:commentEx w15:paraId="`+paraId+`" w15:done="0"/>`)
+	}
+
+	c.added = append(c.added, fmt.Sprintf(`<w:comment w:id="%d" w:author="%s" w:date="%s" w:initials="%s">%s%s%s</w:p></w:comment>`,
+		c.maxId, xmlTextEscaper.Replace(author), date, xmlTextEscaper.Replace(authorInitials(author)), paragraph, pPr, content))
+
+	return c.maxId
+}
+
+// xml returns the content of comments.xml along with the comments added while rendering, and whether any was added.
+func (c comments) xml() ([]byte, bool) {
+	if len(c.added) == 0 {
+		return []byte(c.source), false
+	}
+
+	source := c.source
+	if source == "" {
+		source = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<w:comments xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"></w:comments>`
+	}
+
+	end := strings.LastIndex(source, "</w:comments>")
+	if end < 0 {
+		return []byte(c.source), false
+	}
+
+	return []byte(source[:end] + strings.Join(c.added, "") + source[end:]), true
+}
+
+// extendedXml returns the content of commentsExtended.xml along with the entries of the comments
+// added while rendering, and whether any was added. The part is never created.
+func (c comments) extendedXml() ([]byte, bool) {
+	end := strings.LastIndex(c.extendedSource, "</w15:commentsEx>")
+	if len(c.addedExtended) == 0 || end < 0 {
+		return []byte(c.extendedSource), false
+	}
+
+	return []byte(c.extendedSource[:end] + strings.Join(c.addedExtended, "") + c.extendedSource[end:]), true
+}
+
+// authorInitials returns the initials of the author name, e.g. "JD" for "John Doe".
+func authorInitials(author string) string {
+	initials := ""
+	for _, word := range strings.Fields(author) {
+		initials += string(unicode.ToUpper([]rune(word)[0]))
+	}
+
+	return initials
+}
+
+// applyComments replaces the [[COMMENT:...]] placeholders with the ids of new comments holding their text,
+// dated with the rendering time. The run split by a comment keeps its properties on the anchor text and after it.
+func (d *DocumentMeta) applyComments(srcXML string) (string, error) {
+	if !commentRe.MatchString(srcXML) {
+		return srcXML, nil
+	}
+
+	srcXML = keepSplitRunProps(srcXML, commentPattern)
+	srcXML = removeEmptyRunsAround(srcXML, commentPattern)
+
+	referenceProps := ""
+	if d.HasCharacterStyle("CommentReference") {
+		referenceProps = `<w:rPr><w:rStyle w:val="CommentReference"/></w:rPr>`
+	}
+
+	paragraphProps := ""
+	if d.HasParagraphStyle("CommentText") {
+		paragraphProps = `<w:pPr><w:pStyle w:val="CommentText"/></w:pPr>`
+	}
+
+	date := time.Now().UTC().Format(commentDateLayout)
+
+	var err error
+	srcXML = commentRe.ReplaceAllStringFunc(srcXML, func(match string) string {
+		m := commentRe.FindStringSubmatch(match)
+		encoded, decodeErr := base64.StdEncoding.DecodeString(m[1])
+		if decodeErr != nil {
+			err = fmt.Errorf("unable to decode comment: %w", decodeErr)
+			return match
+		}
+
+		var data commentData
+		if decodeErr := json.Unmarshal(encoded, &data); decodeErr != nil {
+			err = fmt.Errorf("unable to decode comment: %w", decodeErr)
+			return match
+		}
+
+		id := d.comments.add(data.Author, date, paragraphProps,
+			`<w:r>`+referenceProps+`<w:annotationRef/></w:r>`+
+				`<w:r><w:t xml:space="preserve">`+xmlTextEscaper.Replace(data.Text)+`</w:t></w:r>`)
+
+		anchor := m[2]
+		if m[3] == "" {
+			anchor = ""
+		}
+
+		return fmt.Sprintf(`<w:commentRangeStart w:id="%[1]d"/>%[2]s<w:commentRangeEnd w:id="%[1]d"/><w:r>%[3]s<w:commentReference w:id="%[1]d"/></w:r>`,
+			id, anchor, referenceProps)
+	})
+	if err != nil {
+		return srcXML, err
+	}
+
+	return srcXML, nil
+}
@@ -0,0 +1,96 @@
+package docx
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const (
+	testComments = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<w:comments xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:w14="http://schemas.microsoft.com/office/word/2010/wordml">` +
+		`<w:comment w:id="2" w:author="Old" w:initials="O"><w:p w14:paraId="00000001" w14:textId="77777777"><w:r><w:t>old</w:t></w:r></w:p></w:comment>` +
+		`</w:comments>`
+	testCommentsExtended = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<w15:commentsEx xmlns:w15="http://schemas.microsoft.com/office/word/2012/wordml">` +
+		`<w15:commentEx w15:paraId="00000001" w15:done="0"/>` +
+		`</w15:commentsEx>`
+)
+
+var testCommentDateRe = regexp.MustCompile(` w:date="[^"]*"`)
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		parts      map[string]string
+		paragraphs []string
+		contains   []string
+		comments   []string
+		// commentsContains and extendedContains are in comments.xml and commentsExtended.xml, without the dates
+		commentsContains []string
+		extendedContains []string
+	}{
+		{
+			name:             "comment creating the comments part",
+			body:             p(`Check{{comment "is it &lt;right&gt;?" "Jane &amp; John Doe"}} this`),
+			paragraphs:       []string{"Check this"},
+			contains:         []string{`<w:commentRangeStart w:id="0"/><w:commentRangeEnd w:id="0"/><w:r><w:commentReference w:id="0"/></w:r>`},
+			comments:         []string{"is it &lt;right&gt;?"},
+			commentsContains: []string{`<w:comment w:id="0" w:author="Jane &amp; John Doe" w:initials="J&amp;JD"><w:p>`},
+		},
+		{
+			name:       "comment on an anchor keeping the run properties",
+			body:       `<w:p><w:r><w:rPr><w:b/></w:rPr><w:t>A {{commentOn "word" "note" "Me"}} B</w:t></w:r></w:p>`,
+			parts:      map[string]string{"word/comments.xml": testComments, "word/commentsExtended.xml": testCommentsExtended},
+			paragraphs: []string{"A word B"},
+			contains: []string{
+				`<w:commentRangeStart w:id="3"/><w:r><w:rPr><w:b/></w:rPr><w:t xml:space="preserve">word</w:t></w:r><w:commentRangeEnd w:id="3"/>`,
+				`<w:commentReference w:id="3"/></w:r><w:r><w:rPr><w:b/></w:rPr><w:t`,
+			},
+			comments:         []string{"old", "note"},
+			commentsContains: []string{`<w:comment w:id="3" w:author="Me" w:initials="M"><w:p w14:paraId="`},
+			extendedContains: []string{`<w15:commentEx w15:paraId="00000001" w15:done="0"/><w15:commentEx w15:paraId="`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDocumentMeta(t, tt.body, tt.parts)
+			output := renderTestPart(t, d, TemplateConfig{}, "word/document.xml", testDocument(tt.body), nil)
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+
+			for _, s := range tt.contains {
+				if !strings.Contains(output, s) {
+					t.Errorf("output doesn't contain %s\n%s", s, output)
+				}
+			}
+
+			part := generatedPart(t, d, "word/comments.xml")
+			if got := paragraphsTexts(string(part.Content)); !reflect.DeepEqual(got, tt.comments) {
+				t.Errorf("got comments %q, want %q\n%s", got, tt.comments, part.Content)
+			}
+
+			content := testCommentDateRe.ReplaceAllString(string(part.Content), "")
+			for _, s := range tt.commentsContains {
+				if !strings.Contains(content, s) {
+					t.Errorf("comments.xml doesn't contain %s\n%s", s, content)
+				}
+			}
+
+			extended := generatedPart(t, d, "word/commentsExtended.xml")
+			if extended.Changed != (tt.extendedContains != nil) {
+				t.Errorf("got commentsExtended.xml changed %v, want %v", extended.Changed, tt.extendedContains != nil)
+			}
+			for _, s := range tt.extendedContains {
+				if !strings.Contains(string(extended.Content), s) {
+					t.Errorf("commentsExtended.xml doesn't contain %s\n%s", s, extended.Content)
+				}
+			}
+		})
+	}
+}
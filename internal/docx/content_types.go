@@ -44,6 +44,20 @@ func (ct *contentTypes) AddDefaultUnique(extension, contentType string) {
 	})
 }
 
+// AddOverrideUnique adds the content type of a part if it does not already exist in the list.
+func (ct *contentTypes) AddOverrideUnique(partName, contentType string) {
+	for _, o := range ct.Overrides {
+		if o.PartName == partName {
+			return
+		}
+	}
+
+	ct.Overrides = append(ct.Overrides, tagOverride{
+		PartName:    partName,
+		ContentType: contentType,
+	})
+}
+
 // replaceEmptyTags replaces specific XML empty tags patterns.
 func replaceEmptyTags(data []byte) []byte {
 	data = bytes.ReplaceAll(data, []byte("></Default>"), []byte(" />"))
@@ -0,0 +1,111 @@
+package docx
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Delims are the delimiters of the template actions, e.g. "[[" and "]]" or "«" and "»".
+// An empty delimiter is the default one, like in text/template.
+type Delims struct {
+	Left  string
+	Right string
+}
+
+// DefaultDelims are the text/template delimiters.
+var DefaultDelims = Delims{
+	Left:  "{{",
+	Right: "}}",
+}
+
+// orDefault returns the delimiters with the default ones in place of the empty ones.
+func (d Delims) orDefault() Delims {
+	if d.Left == "" {
+		d.Left = DefaultDelims.Left
+	}
+
+	if d.Right == "" {
+		d.Right = DefaultDelims.Right
+	}
+
+	return d
+}
+
+// action returns the action with the given text, e.g. "{{end}}".
+func (d Delims) action(text string) string {
+	return d.Left + text + d.Right
+}
+
+// delimsRegexps are the regexps matching the actions of some delimiters.
+type delimsRegexps struct {
+	action        *regexp.Regexp
+	actionKeyword *regexp.Regexp
+	silentAction  *regexp.Regexp
+	xmlAction     *regexp.Regexp
+}
+
+// delimsRegexpsCache maps the delimiters to their regexps, compiled once.
+var delimsRegexpsCache sync.Map
+
+// regexps returns the regexps of the delimiters, compiling them on first use.
+func (d Delims) regexps() *delimsRegexps {
+	if res, ok := delimsRegexpsCache.Load(d); ok {
+		return res.(*delimsRegexps)
+	}
+
+	left, right := regexp.QuoteMeta(d.Left), regexp.QuoteMeta(d.Right)
+	res, _ := delimsRegexpsCache.LoadOrStore(d, &delimsRegexps{
+		action:        regexp.MustCompile(left + `[\s\S]*?` + right),
+		actionKeyword: regexp.MustCompile(`^` + left + `-?\s*(\w+)`),
+		silentAction:  regexp.MustCompile(`^` + left + `-?\s*(?:/\*|\$\w*\s*:?=)`),
+		xmlAction:     regexp.MustCompile(xmlDelimPattern(d.Left) + `[\s\S]*?` + xmlDelimPattern(d.Right)),
+	})
+
+	return res.(*delimsRegexps)
+}
+
+// actionRe matches the actions of a patched XML source.
+func (d Delims) actionRe() *regexp.Regexp {
+	return d.regexps().action
+}
+
+// actionKeywordRe matches the first word of an action, e.g. "range" in "{{- range .Items}}".
+func (d Delims) actionKeywordRe() *regexp.Regexp {
+	return d.regexps().actionKeyword
+}
+
+// silentActionRe matches the comments and the variables declarations or assignments,
+// e.g. "{{/* comment */}}" or "{{$total := 0}}".
+func (d Delims) silentActionRe() *regexp.Regexp {
+	return d.regexps().silentAction
+}
+
+// xmlActionRe matches the actions of an unpatched XML text or tag, in which
+// the delimiters are XML escaped (e.g. "&lt;&lt;" for "<<").
+func (d Delims) xmlActionRe() *regexp.Regexp {
+	return d.regexps().xmlAction
+}
+
+// xmlDelimPattern returns the pattern matching a delimiter in an unpatched XML source.
+func xmlDelimPattern(delim string) string {
+	var sb strings.Builder
+	for _, r := range delim {
+		switch r {
+		case '<':
+			sb.WriteString(`&lt;`)
+		case '>':
+			sb.WriteString(`&gt;`)
+		case '&':
+			sb.WriteString(`&amp;`)
+		case '"':
+			sb.WriteString(`(?:"|&quot;)`)
+		case '\'':
+			sb.WriteString(`(?:'|&apos;)`)
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	return sb.String()
+}
@@ -1,32 +1,59 @@
 package docx
 
 import (
-	"archive/zip"
-	"bytes"
 	"encoding/xml"
 	"fmt"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
 
 	goziputils "github.com/JJJJJJack/go-zip-utils"
 )
 
-type documentMeta struct {
+type DocumentMeta struct {
 	docPrIdsBijectiveIndex uint32
 	docPrIds               []uint32
 	// greaterCNvPrId         uint64
 	greaterRId uint64
+	// partsGreaterRId are the greatest rIds of the headers and footers rels files
+	partsGreaterRId map[string]uint64
 	// greaterWP14DocId       uint64
 	greaterPictureNumber uint64
 	// greaterChartNumber     uint64
 	greaterImageNumber uint64
 	maxWidthInches     float64
 	maxHeightInches    float64
-	templateFuncs      template.FuncMap
 	mediaMap           MediaMap
+	// tableStyles are the ids of the table styles defined in styles.xml
+	tableStyles map[string]struct{}
+	// characterStyles are the ids of the character styles defined in styles.xml
+	characterStyles map[string]struct{}
+	// paragraphStyles are the ids of the paragraph styles defined in styles.xml
+	paragraphStyles map[string]struct{}
+	// outlineLevels and paragraphStyleNames are the outline levels and the names of the paragraph styles
+	outlineLevels       map[string]int
+	paragraphStyleNames map[string]string
+	// numbering are the list definitions of numbering.xml
+	numbering numbering
+	// footnotes and endnotes are the notes of footnotes.xml and endnotes.xml
+	footnotes notes
+	endnotes  notes
+	// comments are the comments of comments.xml
+	comments comments
+	// greaterBookmarkId and bookmarkNames are the greatest id and the names of the bookmarks,
+	// bookmarks are the unique names of the bookmarks created with each name
+	greaterBookmarkId uint64
+	bookmarkNames     map[string]struct{}
+	bookmarks         map[string][]string
+	// trackChanges is the author and the date of the tracked changes, greaterRevisionId
+	// is the greatest id of the revisions (<w:ins>, <w:del>...)
+	trackChanges      TrackChanges
+	greaterRevisionId uint64
+	// settings is the content of settings.xml, updateTableOfContents reports whether
+	// the tables of contents are rebuilt and updated by Word when the document is opened
+	settings              string
+	updateTableOfContents bool
 }
 
 const DOC_PR_ID_ROOF = 2_147_483_647 // docx id attributes are 32-bit signed integers
@@ -44,7 +71,7 @@ func bijective32(x uint32) uint32 {
 	return x
 }
 
-func (d *documentMeta) RandUniqueDocPrId() (uint32, error) {
+func (d *DocumentMeta) RandUniqueDocPrId() (uint32, error) {
 	if d.docPrIdsBijectiveIndex == 0 {
 		d.docPrIdsBijectiveIndex = 1
 	}
@@ -75,25 +102,83 @@ findNextPrId:
 	return nextDocPrId, nil
 }
 
-func (d *documentMeta) NextPictureNumber() uint64 {
+func (d *DocumentMeta) NextPictureNumber() uint64 {
 	d.greaterPictureNumber++
 	return d.greaterPictureNumber
 }
 
-func (d *documentMeta) NextImageNumber() uint64 {
+func (d *DocumentMeta) NextImageNumber() uint64 {
 	d.greaterImageNumber++
 	return d.greaterImageNumber
 }
 
-func (d *documentMeta) NextRId() uint64 {
+func (d *DocumentMeta) NextRId() uint64 {
 	d.greaterRId++
 	return d.greaterRId
 }
 
-func (d *documentMeta) SetMediaMap(mm MediaMap) {
+// NextPartRId returns the next free rId of the rels file of the given part (document, header or footer).
+func (d *DocumentMeta) NextPartRId(name string) uint64 {
+	if name == "word/document.xml" {
+		return d.NextRId()
+	}
+
+	d.partsGreaterRId[name]++
+	return d.partsGreaterRId[name]
+}
+
+// HasTableStyle reports whether the table style is defined in styles.xml.
+func (d *DocumentMeta) HasTableStyle(styleId string) bool {
+	_, ok := d.tableStyles[styleId]
+	return ok
+}
+
+// HasCharacterStyle reports whether the character style is defined in styles.xml.
+func (d *DocumentMeta) HasCharacterStyle(styleId string) bool {
+	_, ok := d.characterStyles[styleId]
+	return ok
+}
+
+// HasParagraphStyle reports whether the paragraph style is defined in styles.xml.
+func (d *DocumentMeta) HasParagraphStyle(styleId string) bool {
+	_, ok := d.paragraphStyles[styleId]
+	return ok
+}
+
+// MaxWidthTwips returns the usable width of the document pages in twips.
+func (d *DocumentMeta) MaxWidthTwips() int {
+	return int(d.maxWidthInches * twipsPerInch)
+}
+
+func (d *DocumentMeta) SetMediaMap(mm MediaMap) {
 	d.mediaMap = mm
 }
 
+// Clone returns a copy of the document metadata that can be used to render
+// the document once, without affecting the ids and counters of the original.
+func (d *DocumentMeta) Clone() *DocumentMeta {
+	c := *d
+	c.docPrIds = append([]uint32(nil), d.docPrIds...)
+	c.numbering = d.numbering.clone()
+	c.footnotes = d.footnotes.clone()
+	c.endnotes = d.endnotes.clone()
+	c.comments = d.comments.clone()
+	c.partsGreaterRId = make(map[string]uint64, len(d.partsGreaterRId))
+	for name, rId := range d.partsGreaterRId {
+		c.partsGreaterRId[name] = rId
+	}
+	c.bookmarkNames = make(map[string]struct{}, len(d.bookmarkNames))
+	for name := range d.bookmarkNames {
+		c.bookmarkNames[name] = struct{}{}
+	}
+	c.bookmarks = make(map[string][]string, len(d.bookmarks))
+	for name, names := range d.bookmarks {
+		c.bookmarks[name] = append([]string(nil), names...)
+	}
+
+	return &c
+}
+
 type sectPr struct {
 	PgSz struct {
 		W int `xml:"w,attr"`
@@ -128,10 +213,8 @@ func parseDocumentSettings(docXML []byte) (usableWidthInches, usableHeightInches
 }
 
 // TODO: use xml parsing instead of regex
-func ParseDocumentMeta(zm goziputils.ZipMap, tf template.FuncMap) (*documentMeta, error) {
-	d := documentMeta{
-		templateFuncs: tf,
-	}
+func ParseDocumentMeta(zm goziputils.ZipMap) (*DocumentMeta, error) {
+	d := DocumentMeta{}
 
 	// work on word/document.xml
 
@@ -172,6 +255,42 @@ func ParseDocumentMeta(zm goziputils.ZipMap, tf template.FuncMap) (*documentMeta
 		}
 	}
 
+	// work on the bookmarks of word/document.xml, headers, footers and notes
+
+	d.bookmarkNames = map[string]struct{}{}
+	d.bookmarks = map[string][]string{}
+	err = d.parseBookmarks(string(documentContent))
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.parseRevisions(string(documentContent))
+	if err != nil {
+		return nil, err
+	}
+
+	storiesRe := regexp.MustCompile(`^word/(?:header\d*|footer\d*|footnotes|endnotes|comments)\.xml$`)
+	for filename, f := range zm {
+		if !storiesRe.MatchString(filename) {
+			continue
+		}
+
+		storyContent, err := goziputils.ReadZipFileContent(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read zip file content: %w", err)
+		}
+
+		err = d.parseBookmarks(string(storyContent))
+		if err != nil {
+			return nil, err
+		}
+
+		err = d.parseRevisions(string(storyContent))
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// work on word/_rels/document.xml.rels
 
 	wordDocumentRelsFile := zm["word/_rels/document.xml.rels"]
@@ -198,6 +317,105 @@ func ParseDocumentMeta(zm goziputils.ZipMap, tf template.FuncMap) (*documentMeta
 		}
 	}
 
+	// work on word/_rels/headerN.xml.rels and word/_rels/footerN.xml.rels
+
+	d.partsGreaterRId = map[string]uint64{}
+	partRelsRe := regexp.MustCompile(`^word/_rels/((?:header|footer)\d*\.xml)\.rels$`)
+	for filename, f := range zm {
+		m := partRelsRe.FindStringSubmatch(filename)
+		if m == nil {
+			continue
+		}
+
+		relsContent, err := goziputils.ReadZipFileContent(f)
+		if err != nil {
+			return nil, fmt.Errorf("could not read zip file content: %w", err)
+		}
+
+		for _, match := range rIdNRegEx.FindAllStringSubmatch(string(relsContent), -1) {
+			num, err := strconv.ParseUint(match[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse rId '%s': %w", match[1], err)
+			}
+
+			if part := "word/" + m[1]; num > d.partsGreaterRId[part] {
+				d.partsGreaterRId[part] = num
+			}
+		}
+	}
+
+	// work on word/styles.xml
+
+	d.tableStyles = map[string]struct{}{}
+	d.characterStyles = map[string]struct{}{}
+	d.paragraphStyles = map[string]struct{}{}
+	if stylesFile := zm["word/styles.xml"]; stylesFile != nil {
+		stylesContent, err := goziputils.ReadZipFileContent(stylesFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read zip file content: %w", err)
+		}
+
+		styleRe := regexp.MustCompile(`<w:style\b[^>]*>`)
+		styleTypeRe := regexp.MustCompile(`\bw:type="(\w+)"`)
+		styleIdRe := regexp.MustCompile(`\bw:styleId="([^"]*)"`)
+		for _, style := range styleRe.FindAllString(string(stylesContent), -1) {
+			m, t := styleIdRe.FindStringSubmatch(style), styleTypeRe.FindStringSubmatch(style)
+			switch {
+			case m == nil || t == nil:
+			case t[1] == "table":
+				d.tableStyles[m[1]] = struct{}{}
+			case t[1] == "character":
+				d.characterStyles[m[1]] = struct{}{}
+			case t[1] == "paragraph":
+				d.paragraphStyles[m[1]] = struct{}{}
+			}
+		}
+
+		d.outlineLevels, d.paragraphStyleNames = parseOutlineLevels(string(stylesContent))
+	}
+
+	// work on word/settings.xml
+
+	if settingsFile := zm["word/settings.xml"]; settingsFile != nil {
+		settingsContent, err := goziputils.ReadZipFileContent(settingsFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read zip file content: %w", err)
+		}
+
+		d.settings = string(settingsContent)
+	}
+
+	// work on word/numbering.xml
+
+	d.numbering = parseNumbering("")
+	if numberingFile := zm["word/numbering.xml"]; numberingFile != nil {
+		numberingContent, err := goziputils.ReadZipFileContent(numberingFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read zip file content: %w", err)
+		}
+
+		d.numbering = parseNumbering(string(numberingContent))
+	}
+
+	// work on word/footnotes.xml and word/endnotes.xml
+
+	d.footnotes, err = parseNotesFile(zm, "footnote")
+	if err != nil {
+		return nil, err
+	}
+
+	d.endnotes, err = parseNotesFile(zm, "endnote")
+	if err != nil {
+		return nil, err
+	}
+
+	// work on word/comments.xml and word/commentsExtended.xml
+
+	d.comments, err = parseCommentsFiles(zm)
+	if err != nil {
+		return nil, err
+	}
+
 	// work on word/media/images
 	for filename := range zm {
 		if !strings.HasPrefix(filename, "word/media/image") {
@@ -220,53 +438,77 @@ func ParseDocumentMeta(zm goziputils.ZipMap, tf template.FuncMap) (*documentMeta
 	return &d, nil
 }
 
-func (d *documentMeta) ApplyTemplate(f *zip.File, zipWriter *zip.Writer, data any) ([]MediaRel, error) {
-	documentXml, err := goziputils.ReadZipFileContent(f)
+// ProcessOutput resolves the placeholders left by the template functions (images, colors...)
+// in the executed template output of the given document, header or footer part.
+// It returns the rendered XML along with the relationships (images, hyperlinks) to add to the rels file of the part.
+func (d *DocumentMeta) ProcessOutput(name string, appliedTemplate []byte) ([]byte, []MediaRel, error) {
+	output, media, err := d.applyImages(name, string(appliedTemplate))
 	if err != nil {
-		return nil, fmt.Errorf("unable to read document file '%s': %w", f.Name, err)
+		return nil, nil, fmt.Errorf("unable to apply images in file '%s': %w", name, err)
 	}
 
-	documentXml = []byte(PatchXml(string(documentXml)))
+	output, replaceMedia, err := d.replaceImages(name, output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to replace images in file '%s': %w", name, err)
+	}
+
+	media = append(media, replaceMedia...)
 
-	tmpl, err := template.New(f.Name).
-		Option("missingkey=error").
-		Funcs(d.templateFuncs).
-		Parse(string(documentXml))
+	output = d.applyBookmarks(output)
+
+	output, err = d.applyNotes(name, output)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse template in file '%s': %w", f.Name, err)
+		return nil, nil, fmt.Errorf("unable to apply notes in file '%s': %w", name, err)
 	}
 
-	appliedTemplate := bytes.Buffer{}
-	err = tmpl.Execute(&appliedTemplate, data)
+	output, err = d.applyComments(output)
 	if err != nil {
-		return nil, fmt.Errorf("unable to execute template in file '%s': %w", f.Name, err)
+		return nil, nil, fmt.Errorf("unable to apply comments in file '%s': %w", name, err)
 	}
 
-	output, media, err := d.applyImages(appliedTemplate.String())
+	output, hyperlinks, err := d.applyHyperlinks(name, output)
 	if err != nil {
-		return nil, fmt.Errorf("unable to apply images in file '%s': %w", f.Name, err)
+		return nil, nil, fmt.Errorf("unable to apply hyperlinks in file '%s': %w", name, err)
 	}
 
-	output, replaceMedia := d.replaceImages(output)
+	media = append(media, hyperlinks...)
 
-	media = append(media, replaceMedia...)
+	output, err = d.applySectionBreaks(name, output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to apply section breaks in file '%s': %w", name, err)
+	}
+
+	output, err = d.applyTables(output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to apply tables in file '%s': %w", name, err)
+	}
+
+	output, err = d.applyLists(output)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to apply lists in file '%s': %w", name, err)
+	}
 
 	output = d.applyShapesBgFillColor(output)
 
 	output = d.replaceTableCellBgColors(output)
 
+	output = replaceTableCellMerges(output)
+
 	output = flattenNestedTextRuns(output)
 
 	output = propagateRunPropsAfterBreak(output)
 
+	output = d.applyTrackChanges(output)
+
 	output = ensureXmlSpacePreserve(output)
 
 	output = removeEmptyTableRows(output)
 
-	err = goziputils.RewriteFileIntoZipWriter(zipWriter, f, []byte(output))
-	if err != nil {
-		return nil, fmt.Errorf("unable to rewrite file '%s' in zip: %w", f.Name, err)
-	}
+	output = removeEmptyTables(output)
+
+	output = d.applyTableOfContents(name, output)
+
+	output = ensureContainersParagraph(output)
 
-	return media, nil
+	return []byte(output), media, nil
 }
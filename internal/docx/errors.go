@@ -0,0 +1,385 @@
+package docx
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type TemplateErrorKind string
+
+const (
+	// TemplateErrorParse is a syntax error or a call to an undefined function
+	TemplateErrorParse TemplateErrorKind = "parse"
+	// TemplateErrorMissingKey is a missing map key or struct field in the template values
+	TemplateErrorMissingKey TemplateErrorKind = "missing key"
+	// TemplateErrorFunc is an error returned by a template function
+	TemplateErrorFunc TemplateErrorKind = "function"
+	// TemplateErrorExec is any other error occurred while executing the template
+	TemplateErrorExec TemplateErrorKind = "execution"
+)
+
+// TemplateError describes a template error and where it is located
+// in the docx file, so that it can be mapped back to the Word document.
+type TemplateError struct {
+	Kind TemplateErrorKind
+	// Part is the docx file part containing the expression (e.g. "word/document.xml")
+	Part string
+	// Expression is the template expression text (e.g. "{{.Customer.Fax}}"), empty if unknown
+	Expression string
+	// Paragraph is the index of the paragraph containing the expression in the part, -1 if unknown
+	Paragraph int
+	// TableCell is the index of the table cell containing the expression in the part, -1 if not in a table
+	TableCell int
+	// ParagraphText is the visible text of the paragraph containing the expression
+	ParagraphText string
+	// Message is the text/template error message, without the position prefix
+	Message string
+	// Err is the original text/template error
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	msg := fmt.Sprintf("%s error in file '%s'", e.Kind, e.Part)
+	if e.Expression != "" {
+		msg += fmt.Sprintf(" at %s", e.Expression)
+	}
+	if e.ParagraphText != "" {
+		msg += fmt.Sprintf(" in paragraph \"%s\"", e.ParagraphText)
+	}
+
+	return msg + ": " + e.Message
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	paragraphStartRe   = regexp.MustCompile(`<w:p[\s>]`)
+	tableCellStartRe   = regexp.MustCompile(`<w:tc[\s>]`)
+	visibleTextRe      = regexp.MustCompile(`(?s)<w:t\b[^>]*>(.*?)</w:t>`)
+	execCallerRe       = regexp.MustCompile(`^template: .*?:(\d+):(\d+): executing ".*?" at <.*?>: `)
+	parseCallerRe      = regexp.MustCompile(`^template: .*?:(\d+): `)
+	missingKeyErrorsRe = regexp.MustCompile(`map has no entry for key|can't evaluate field|nil pointer evaluating`)
+)
+
+// sourceLocation is the location of a token of the XML source or, in a sourceMap, of the patched
+// source from offset on: the indexes of the paragraph and of the table cell containing it
+// in the original XML source, -1 if none.
+type sourceLocation struct {
+	offset    int
+	paragraph int
+	tableCell int
+}
+
+// sourceMap maps the offsets of a patched source to their location in the original XML source,
+// since the patching removes the control paragraphs and repeats the table rows and columns.
+// Its locations are sorted by offset.
+type sourceMap []sourceLocation
+
+// locateTokens sets the location of the tokens of the original XML source.
+func locateTokens(tokens []xmlToken) {
+	paragraphs, tableCells := 0, 0
+	openParagraphs, openTableCells := []int{}, []int{}
+	for i, token := range tokens {
+		name := token.qualifiedName()
+		switch {
+		case token.isOpening() && name == "w:p":
+			openParagraphs = append(openParagraphs, paragraphs)
+			paragraphs++
+		case token.isOpening() && name == "w:tc":
+			openTableCells = append(openTableCells, tableCells)
+			tableCells++
+		case token.isTag && !token.isClosing() && name == "w:p":
+			// an empty paragraph, e.g. <w:p/>
+			paragraphs++
+		case token.isTag && !token.isClosing() && name == "w:tc":
+			tableCells++
+		}
+
+		location := sourceLocation{paragraph: -1, tableCell: -1}
+		if len(openParagraphs) > 0 {
+			location.paragraph = openParagraphs[len(openParagraphs)-1]
+		}
+		if len(openTableCells) > 0 {
+			location.tableCell = openTableCells[len(openTableCells)-1]
+		}
+		tokens[i].location = location
+
+		switch {
+		case token.isClosing() && name == "w:p" && len(openParagraphs) > 0:
+			openParagraphs = openParagraphs[:len(openParagraphs)-1]
+		case token.isClosing() && name == "w:tc" && len(openTableCells) > 0:
+			openTableCells = openTableCells[:len(openTableCells)-1]
+		}
+	}
+}
+
+// add maps the patched source from offset on to the location, if it differs from the last one.
+func (m *sourceMap) add(offset int, location sourceLocation) {
+	location.offset = offset
+	if n := len(*m); n > 0 && (*m)[n-1].paragraph == location.paragraph && (*m)[n-1].tableCell == location.tableCell {
+		return
+	}
+
+	*m = append(*m, location)
+}
+
+// locate returns the location of the offset of the patched source, and the offset
+// at which the patched source stops having this location.
+func (m sourceMap) locate(offset, sourceLen int) (sourceLocation, int) {
+	i := sort.Search(len(m), func(i int) bool { return m[i].offset > offset }) - 1
+	if i < 0 {
+		return sourceLocation{paragraph: -1, tableCell: -1}, sourceLen
+	}
+
+	end := sourceLen
+	if i+1 < len(m) {
+		end = m[i+1].offset
+	}
+
+	return m[i], end
+}
+
+// remove returns the map of the patched source from which the text [start, start+length) was removed.
+func (m sourceMap) remove(start, length int) sourceMap {
+	removed := make(sourceMap, 0, len(m))
+	for _, location := range m {
+		switch {
+		case location.offset >= start+length:
+			location.offset -= length
+		case location.offset > start:
+			location.offset = start
+		}
+		removed = append(removed, location)
+	}
+
+	return removed
+}
+
+// newParseTemplateError locates the expression that made the parsing fail by parsing each
+// expression of the source on its own, since text/template only reports the line of parse errors.
+func newParseTemplateError(name, source string, locations sourceMap, funcs template.FuncMap, d Delims, err error) *TemplateError {
+	actionKeywordRe := d.actionKeywordRe()
+
+	offset := -1
+	for _, loc := range d.actionRe().FindAllStringIndex(source, -1) {
+		action := source[loc[0]:loc[1]]
+
+		keyword := ""
+		if m := actionKeywordRe.FindStringSubmatch(action); m != nil {
+			keyword = m[1]
+		}
+
+		switch keyword {
+		case "end", "else", "break", "continue":
+			continue
+		case "if", "range", "with", "define", "block":
+			action += d.action("end")
+		}
+
+		if _, actionErr := template.New(name).Delims(d.Left, d.Right).Funcs(funcs).Parse(action); actionErr != nil {
+			offset = loc[0]
+			break
+		}
+	}
+
+	if offset < 0 {
+		offset = locateUnbalancedAction(source, d)
+	}
+
+	message := err.Error()
+	if m := parseCallerRe.FindStringSubmatch(message); m != nil {
+		message = strings.TrimPrefix(message, m[0])
+
+		if offset < 0 {
+			line, _ := strconv.Atoi(m[1])
+			offset = lineOffset(source, line)
+		}
+	}
+
+	return newTemplateError(TemplateErrorParse, name, source, locations, d, offset, message, err)
+}
+
+// locateUnbalancedAction returns the offset of the first unclosed "{{", of an {{end}} without
+// its opening action or of the last opening action without its {{end}}, -1 if none is found.
+func locateUnbalancedAction(source string, d Delims) int {
+	for offset := 0; ; {
+		start := strings.Index(source[offset:], d.Left)
+		if start < 0 {
+			break
+		}
+		start += offset
+
+		end := strings.Index(source[start+len(d.Left):], d.Right)
+		next := strings.Index(source[start+len(d.Left):], d.Left)
+		if end < 0 || (next >= 0 && next < end) {
+			return start
+		}
+		offset = start + len(d.Left) + end
+	}
+
+	actionKeywordRe := d.actionKeywordRe()
+	opened := []int{}
+	for _, loc := range d.actionRe().FindAllStringIndex(source, -1) {
+		m := actionKeywordRe.FindStringSubmatch(source[loc[0]:loc[1]])
+		if m == nil {
+			continue
+		}
+
+		switch m[1] {
+		case "if", "range", "with", "define", "block":
+			opened = append(opened, loc[0])
+		case "end":
+			if len(opened) == 0 {
+				return loc[0]
+			}
+			opened = opened[:len(opened)-1]
+		}
+	}
+
+	if len(opened) > 0 {
+		return opened[len(opened)-1]
+	}
+
+	return -1
+}
+
+// newExecTemplateError returns a TemplateError if err is an error of the template
+// execution, otherwise (e.g. a failed write) it returns nil.
+func newExecTemplateError(name, source string, locations sourceMap, d Delims, err error) *TemplateError {
+	var execErr template.ExecError
+	if !errors.As(err, &execErr) {
+		return nil
+	}
+
+	offset := -1
+	message := err.Error()
+	if m := execCallerRe.FindStringSubmatch(message); m != nil {
+		message = strings.TrimPrefix(message, m[0])
+		offset = execErrorOffset(source, m)
+	}
+
+	kind := TemplateErrorExec
+	switch {
+	case strings.HasPrefix(message, "error calling "+MissingKeyLookupFunc+": "):
+		// the fields rewritten by the missing key policy
+		message = strings.TrimPrefix(message, "error calling "+MissingKeyLookupFunc+": ")
+		if missingKeyErrorsRe.MatchString(message) {
+			kind = TemplateErrorMissingKey
+		}
+	case strings.HasPrefix(message, "error calling "):
+		kind = TemplateErrorFunc
+	case missingKeyErrorsRe.MatchString(message):
+		kind = TemplateErrorMissingKey
+	}
+
+	return newTemplateError(kind, name, source, locations, d, offset, message, err)
+}
+
+// execErrorOffset returns the offset in source of the node that made the execution fail,
+// given the execCallerRe submatches of the error message.
+func execErrorOffset(source string, m []string) int {
+	line, _ := strconv.Atoi(m[1])
+	col, _ := strconv.Atoi(m[2])
+
+	return lineOffset(source, line) + col
+}
+
+// lineOffset returns the offset of the first byte of the given 1-based line.
+func lineOffset(source string, line int) int {
+	offset := 0
+	for i := 1; i < line; i++ {
+		next := strings.IndexByte(source[offset:], '\n')
+		if next < 0 {
+			break
+		}
+		offset += next + 1
+	}
+
+	return offset
+}
+
+// newTemplateError returns the error of the expression at the offset of the patched source,
+// located in the original XML source with locations if not nil.
+func newTemplateError(kind TemplateErrorKind, name, source string, locations sourceMap, d Delims, offset int, message string, err error) *TemplateError {
+	te := TemplateError{
+		Kind:      kind,
+		Part:      name,
+		Paragraph: -1,
+		TableCell: -1,
+		Message:   message,
+		Err:       err,
+	}
+
+	if offset < 0 || offset > len(source) {
+		return &te
+	}
+
+	// the expression enclosing the offset
+	searchEnd := offset + len(d.Left)
+	if searchEnd > len(source) {
+		searchEnd = len(source)
+	}
+	if start := strings.LastIndex(source[:searchEnd], d.Left); start >= 0 {
+		end := strings.Index(source[start+len(d.Left):], d.Right)
+		next := strings.Index(source[start+len(d.Left):], d.Left)
+		switch {
+		case end >= 0 && (next < 0 || end < next):
+			te.Expression = source[start : start+len(d.Left)+end+len(d.Right)]
+		default:
+			// unclosed expression, take the rest of its text
+			te.Expression = source[start:]
+			if textEnd := strings.IndexByte(te.Expression, '<'); textEnd >= 0 {
+				te.Expression = te.Expression[:textEnd]
+			}
+		}
+	}
+
+	before := source[:offset]
+
+	if pStarts := paragraphStartRe.FindAllStringIndex(before, -1); len(pStarts) > 0 {
+		pStart := pStarts[len(pStarts)-1][0]
+		if !strings.Contains(source[pStart:offset], "</w:p>") {
+			te.Paragraph = len(pStarts) - 1
+
+			pEnd := strings.Index(source[offset:], "</w:p>")
+			if pEnd < 0 {
+				pEnd = len(source) - offset
+			}
+
+			texts := []string{}
+			for _, m := range visibleTextRe.FindAllStringSubmatch(source[pStart:offset+pEnd], -1) {
+				texts = append(texts, html.UnescapeString(m[1]))
+			}
+			te.ParagraphText = strings.Join(texts, "")
+		}
+	}
+
+	if tcStarts := tableCellStartRe.FindAllStringIndex(before, -1); len(tcStarts) > 0 {
+		tcStart := tcStarts[len(tcStarts)-1][0]
+		if !strings.Contains(source[tcStart:offset], "</w:tc>") {
+			te.TableCell = len(tcStarts) - 1
+		}
+	}
+
+	if locations == nil {
+		return &te
+	}
+
+	// the indexes in the patched source don't match the original ones
+	location, end := locations.locate(offset, len(source))
+	if te.Paragraph < 0 && location.paragraph >= 0 {
+		// a control paragraph, replaced by its actions
+		te.ParagraphText = source[location.offset:end]
+	}
+	te.Paragraph, te.TableCell = location.paragraph, location.tableCell
+
+	return &te
+}
@@ -0,0 +1,77 @@
+package docx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTemplateErrorLocation(t *testing.T) {
+	row := func(cells ...string) string { return `<w:tr>` + cells[0] + cells[1] + `</w:tr>` }
+	table := `<w:tbl><w:tblGrid><w:gridCol w:w="2000"/><w:gridCol w:w="2000"/></w:tblGrid>` +
+		row(tc(p("Name")), tc(p("Qty"))) +
+		row(tc(p("{{rowRange .Items}}"), p("{{.Name}}")), tc(p("{{.Qty.Missing}}"))) +
+		`</w:tbl>`
+
+	tests := []struct {
+		name          string
+		body          string
+		expression    string
+		paragraph     int
+		tableCell     int
+		paragraphText string
+	}{
+		{
+			name:          "after a removed range paragraph",
+			body:          p("Title") + p("{{range .Items}}") + p("- {{.Name}}") + p("{{end}}") + p("{{.Total.Missing}}"),
+			expression:    "{{.Total.Missing}}",
+			paragraph:     4,
+			tableCell:     -1,
+			paragraphText: "{{.Total.Missing}}",
+		},
+		{
+			name:          "in a removed range paragraph",
+			body:          p("Title") + p("{{range .Flag}}") + p("- {{.Name}}") + p("{{end}}"),
+			expression:    "{{range .Flag}}",
+			paragraph:     1,
+			tableCell:     -1,
+			paragraphText: "{{range .Flag}}",
+		},
+		{
+			name:          "in a row loop",
+			body:          p("Title") + table,
+			expression:    "{{.Qty.Missing}}",
+			paragraph:     5,
+			tableCell:     3,
+			paragraphText: "{{.Qty.Missing}}",
+		},
+	}
+
+	data := map[string]any{
+		"Items": []map[string]any{{"Name": "a", "Qty": 1}},
+		"Total": 3,
+		"Flag":  true,
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := TemplateConfig{Funcs: TemplateFuncs}.Parse("word/document.xml", testDocument(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ExecuteXmlTemplate(context.Background(), tmpl, data, 0)
+
+			var templateErr *TemplateError
+			if !errors.As(err, &templateErr) {
+				t.Fatalf("got error %v, want a *TemplateError", err)
+			}
+			if templateErr.Expression != tt.expression || templateErr.Paragraph != tt.paragraph ||
+				templateErr.TableCell != tt.tableCell || templateErr.ParagraphText != tt.paragraphText {
+				t.Errorf("got %q in paragraph %d %q, table cell %d, want %q in paragraph %d %q, table cell %d",
+					templateErr.Expression, templateErr.Paragraph, templateErr.ParagraphText, templateErr.TableCell,
+					tt.expression, tt.paragraph, tt.paragraphText, tt.tableCell)
+			}
+		})
+	}
+}
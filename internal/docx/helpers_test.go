@@ -0,0 +1,217 @@
+package docx
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	goziputils "github.com/JJJJJJack/go-zip-utils"
+)
+
+const testDocumentRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+// testDocument returns a document.xml whose body holds the given XML, followed by an A4 section.
+func testDocument(body string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" ` +
+		`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><w:body>` + body +
+		`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440"/></w:sectPr>` +
+		`</w:body></w:document>`
+}
+
+// p returns a paragraph with a single run holding the given text.
+func p(text string) string {
+	return `<w:p><w:r><w:t xml:space="preserve">` + text + `</w:t></w:r></w:p>`
+}
+
+// tc returns a table cell holding the given paragraphs.
+func tc(paragraphs ...string) string {
+	return `<w:tc><w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>` + strings.Join(paragraphs, "") + `</w:tc>`
+}
+
+// tr returns a table row holding the given cells.
+func tr(cells ...string) string {
+	return `<w:tr>` + strings.Join(cells, "") + `</w:tr>`
+}
+
+// tbl returns a table with the given grid columns widths, holding the given rows.
+func tbl(gridCols []int, rows ...string) string {
+	var grid strings.Builder
+	for _, w := range gridCols {
+		fmt.Fprintf(&grid, `<w:gridCol w:w="%d"/>`, w)
+	}
+
+	return `<w:tbl><w:tblPr><w:tblW w:w="0" w:type="auto"/></w:tblPr><w:tblGrid>` + grid.String() + `</w:tblGrid>` +
+		strings.Join(rows, "") + `</w:tbl>`
+}
+
+// newTestDocumentMeta parses the metadata of a docx file made of the given body
+// and of the other given parts (e.g. "word/styles.xml").
+func newTestDocumentMeta(t *testing.T, body string, parts map[string]string) *DocumentMeta {
+	t.Helper()
+
+	files := map[string]string{
+		"word/document.xml":            testDocument(body),
+		"word/_rels/document.xml.rels": testDocumentRels,
+	}
+	for name, content := range parts {
+		files[name] = content
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zm, err := goziputils.NewZipMapFromBytes(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ParseDocumentMeta(zm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// renderTestPart parses, executes and processes the given part content with the built-in functions.
+func renderTestPart(t *testing.T, d *DocumentMeta, config TemplateConfig, name, content string, data any) string {
+	t.Helper()
+
+	output, _ := renderTestPartRels(t, d, config, name, content, data)
+
+	return output
+}
+
+// renderTestPartRels is renderTestPart, also returning the relationships to add to the part.
+func renderTestPartRels(t *testing.T, d *DocumentMeta, config TemplateConfig, name, content string, data any) (string, []MediaRel) {
+	t.Helper()
+
+	funcs := make(map[string]any, len(TemplateFuncs)+len(config.Funcs))
+	for funcName, fn := range TemplateFuncs {
+		funcs[funcName] = fn
+	}
+	for funcName, fn := range config.Funcs {
+		funcs[funcName] = fn
+	}
+	config.Funcs = funcs
+
+	tmpl, err := config.Parse(name, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := ExecuteXmlTemplate(context.Background(), tmpl, data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, rels, err := d.ProcessOutput(name, applied)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertWellFormed(t, string(output))
+
+	return string(output), rels
+}
+
+// renderTestDocument renders the document.xml made of the given body with the built-in functions.
+func renderTestDocument(t *testing.T, body string, data any) string {
+	t.Helper()
+
+	d := newTestDocumentMeta(t, body, nil)
+
+	return renderTestPart(t, d, TemplateConfig{}, "word/document.xml", testDocument(body), data)
+}
+
+// generatedPart returns the generated part with the given name, after checking it is well-formed.
+func generatedPart(t *testing.T, d *DocumentMeta, name string) GeneratedPart {
+	t.Helper()
+
+	for _, part := range d.GeneratedParts() {
+		if part.Name == name {
+			if part.Changed {
+				assertWellFormed(t, string(part.Content))
+			}
+			return part
+		}
+	}
+
+	t.Fatalf("generated part %s not found", name)
+	return GeneratedPart{}
+}
+
+// assertWellFormed fails the test if the given XML is not well-formed.
+func assertWellFormed(t *testing.T, content string) {
+	t.Helper()
+
+	decoder := xml.NewDecoder(strings.NewReader(content))
+	for {
+		_, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("malformed XML: %v\n%s", err, content)
+		}
+	}
+}
+
+// rowsTexts returns the paragraphs texts of each cell of each row of the given XML,
+// which must not contain nested tables.
+func rowsTexts(content string) [][]string {
+	rows := [][]string{}
+	for _, row := range testRowRe.FindAllString(content, -1) {
+		cells := []string{}
+		for _, cell := range testCellRe.FindAllString(row, -1) {
+			cells = append(cells, strings.Join(paragraphsTexts(cell), "\n"))
+		}
+		rows = append(rows, cells)
+	}
+
+	return rows
+}
+
+var (
+	testRowRe       = regexp.MustCompile(`(?s)<w:tr(?:\s[^>]*)?>.*?</w:tr>`)
+	testCellRe      = regexp.MustCompile(`(?s)<w:tc(?:\s[^>]*)?>.*?</w:tc>`)
+	testParagraphRe = regexp.MustCompile(`(?s)<w:p(?:\s[^>]*)?>.*?</w:p>|<w:p(?:\s[^>]*)?/>`)
+	testTextRe      = regexp.MustCompile(`(?s)<w:t(?:\s[^>]*)?>(.*?)</w:t>`)
+)
+
+// paragraphsTexts returns the text of each paragraph of the given XML, which must not contain nested paragraphs.
+func paragraphsTexts(content string) []string {
+	texts := []string{}
+	for _, paragraph := range testParagraphRe.FindAllString(content, -1) {
+		var text strings.Builder
+		for _, m := range testTextRe.FindAllStringSubmatch(paragraph, -1) {
+			text.WriteString(m[1])
+		}
+		texts = append(texts, text.String())
+	}
+
+	return texts
+}
@@ -0,0 +1,129 @@
+package docx
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// HyperlinkStyleId is the id of the character style of the hyperlinks.
+	HyperlinkStyleId = "Hyperlink"
+	// hyperlinkColor and hyperlinkUnderline are the default Hyperlink style formatting,
+	// set on the hyperlinks when the style is not defined in styles.xml
+	hyperlinkColor     = `<w:color w:val="0563C1"/>`
+	hyperlinkUnderline = `<w:u w:val="single"/>`
+
+	DOCX_HYPERLINK_INJECT_F = `</w:t></w:r><w:hyperlink r:id="[[HYPERLINK:%s]]" w:history="1"` +
+		` xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<w:r><w:rPr><w:rStyle w:val="` + HyperlinkStyleId + `"/>%s</w:rPr><w:t xml:space="preserve">%s</w:t></w:r></w:hyperlink><w:r><w:t>`
+	DOCX_LINK_TO_INJECT_F = `</w:t></w:r><w:hyperlink w:anchor="[[LINK_TO:%s]]" w:history="1">` +
+		`<w:r><w:rPr><w:rStyle w:val="` + HyperlinkStyleId + `"/>%s</w:rPr><w:t xml:space="preserve">%s</w:t></w:r></w:hyperlink><w:r><w:t>`
+)
+
+var (
+	hyperlinkPlaceholderRe = regexp.MustCompile(`\[\[(HYPERLINK|LINK_TO):([\w+/=]*)\]\]`)
+	// hyperlinkPattern matches the hyperlinks injected by hyperlink and linkTo, before their placeholders are resolved
+	hyperlinkPattern = `<w:hyperlink (?:r:id|w:anchor)="\[\[(?:HYPERLINK|LINK_TO):[\w+/=]*\]\]"[^>]*>` +
+		`<w:r>` + runPropsPattern + `<w:t[^>]*>[^<]*</w:t></w:r></w:hyperlink>`
+	hyperlinkRunPrRe = regexp.MustCompile(`(<w:hyperlink (?:r:id|w:anchor)="\[\[(?:HYPERLINK|LINK_TO):[\w+/=]*\]\]"[^>]*><w:r><w:rPr>)([^<]*(?:<[^>]+/>[^<]*)*)(</w:rPr>)`)
+	// the run properties following the color and the underline, as ordered by the schema
+	rPrAfterColorRe = regexp.MustCompile(`<w:(?:spacing|w|kern|position|sz|szCs|highlight|u|effect|bdr|shd|fitText|vertAlign|rtl|cs|em|lang|eastAsianLayout|specVanish|oMath)\b|$`)
+	rPrAfterURe     = regexp.MustCompile(`<w:(?:effect|bdr|shd|fitText|vertAlign|rtl|cs|em|lang|eastAsianLayout|specVanish|oMath)\b|$`)
+)
+
+// hyperlink inserts a link to the url with the given text, styled with the Hyperlink
+// character style and the optional styles of inlineStyledText (e.g. "b", "#FF0000").
+func hyperlink(url, text string, styles ...interface{}) (string, error) {
+	if url == "" {
+		return "", fmt.Errorf("func 'hyperlink': empty url")
+	}
+
+	stylesTags, err := formatStylesTags(styles, "hyperlink")
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(DOCX_HYPERLINK_INJECT_F, base64.StdEncoding.EncodeToString([]byte(url)), stylesTags, text), nil
+}
+
+// linkTo inserts a link to the bookmark with the given name (see bookmark), styled like hyperlink.
+// When the bookmark is repeated (e.g. inside a range) the n-th link to the name jumps to the n-th bookmark.
+func linkTo(name, text string, styles ...interface{}) (string, error) {
+	bookmarkName, err := sanitizeBookmarkName(name)
+	if err != nil {
+		return "", fmt.Errorf("func 'linkTo': %w", err)
+	}
+
+	stylesTags, err := formatStylesTags(styles, "linkTo")
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(DOCX_LINK_TO_INJECT_F, bookmarkName, stylesTags, text), nil
+}
+
+// applyHyperlinks replaces the [[HYPERLINK:...]] placeholders with the ids of new external
+// relationships to their url, to add to the rels file of the given part, and the [[LINK_TO:name]]
+// placeholders with the names of the bookmarks. The run split by a link keeps its properties after it
+// and, when the Hyperlink style is not defined in styles.xml, its default color and underline are set on the links.
+func (d *DocumentMeta) applyHyperlinks(name, srcXML string) (string, []MediaRel, error) {
+	mediaRels := []MediaRel{}
+	if !hyperlinkPlaceholderRe.MatchString(srcXML) {
+		return srcXML, mediaRels, nil
+	}
+
+	srcXML = keepSplitRunProps(srcXML, hyperlinkPattern)
+	srcXML = removeEmptyRunsAround(srcXML, hyperlinkPattern)
+
+	if !d.HasCharacterStyle(HyperlinkStyleId) {
+		srcXML = hyperlinkRunPrRe.ReplaceAllStringFunc(srcXML, func(match string) string {
+			m := hyperlinkRunPrRe.FindStringSubmatch(match)
+
+			rPr := m[2]
+			if !strings.Contains(rPr, "<w:color ") {
+				i := rPrAfterColorRe.FindStringIndex(rPr)[0]
+				rPr = rPr[:i] + hyperlinkColor + rPr[i:]
+			}
+			if !strings.Contains(rPr, "<w:u ") {
+				i := rPrAfterURe.FindStringIndex(rPr)[0]
+				rPr = rPr[:i] + hyperlinkUnderline + rPr[i:]
+			}
+
+			return m[1] + rPr + m[3]
+		})
+	}
+
+	links := map[string]int{}
+
+	var err error
+	srcXML = hyperlinkPlaceholderRe.ReplaceAllStringFunc(srcXML, func(placeholder string) string {
+		m := hyperlinkPlaceholderRe.FindStringSubmatch(placeholder)
+		if m[1] == "LINK_TO" {
+			links[m[2]]++
+			return d.bookmarkTarget(m[2], links[m[2]])
+		}
+
+		url, decodeErr := base64.StdEncoding.DecodeString(m[2])
+		if decodeErr != nil {
+			err = fmt.Errorf("unable to decode hyperlink url: %w", decodeErr)
+			return placeholder
+		}
+
+		rId := fmt.Sprintf("rId%d", d.NextPartRId(name))
+		mediaRels = append(mediaRels, MediaRel{
+			Type:       HyperlinkMediaType,
+			RefID:      rId,
+			Source:     string(url),
+			TargetMode: "External",
+		})
+
+		return rId
+	})
+	if err != nil {
+		return srcXML, mediaRels, err
+	}
+
+	return srcXML, mediaRels, nil
+}
@@ -0,0 +1,103 @@
+package docx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testHyperlinkStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:style w:type="character" w:styleId="Hyperlink"><w:name w:val="Hyperlink"/></w:style>` +
+	`</w:styles>`
+
+func TestHyperlink(t *testing.T) {
+	data := map[string]any{"Url": "https://example.com/?a=1&b=2"}
+	link := MediaRel{Type: HyperlinkMediaType, RefID: "rId2", Source: "https://example.com/?a=1&b=2", TargetMode: "External"}
+
+	tests := []struct {
+		name       string
+		part       string
+		styles     string
+		body       string
+		paragraphs []string
+		contains   []string
+		excludes   []string
+		rels       []MediaRel
+	}{
+		{
+			name:       "default style",
+			part:       "word/document.xml",
+			body:       p(`See {{hyperlink .Url "the site"}} now`),
+			paragraphs: []string{"See the site now"},
+			contains:   []string{`<w:hyperlink r:id="rId2" w:history="1"`, `<w:rStyle w:val="Hyperlink"/><w:color w:val="0563C1"/><w:u w:val="single"/>`},
+			rels:       []MediaRel{link},
+		},
+		{
+			name:       "Hyperlink style of the template",
+			part:       "word/document.xml",
+			styles:     testHyperlinkStyles,
+			body:       p(`{{hyperlink .Url "site" "b"}}`),
+			paragraphs: []string{"site"},
+			contains:   []string{`<w:rPr><w:rStyle w:val="Hyperlink"/><w:b /><w:bCs /></w:rPr>`},
+			excludes:   []string{`<w:color w:val="0563C1"/>`},
+			rels:       []MediaRel{link},
+		},
+		{
+			name:       "run properties kept after the link",
+			part:       "word/document.xml",
+			styles:     testHyperlinkStyles,
+			body:       `<w:p><w:r><w:rPr><w:i/></w:rPr><w:t>A {{hyperlink .Url "site"}} B</w:t></w:r></w:p>`,
+			paragraphs: []string{"A site B"},
+			contains:   []string{`</w:hyperlink><w:r><w:rPr><w:i/></w:rPr><w:t`},
+			rels:       []MediaRel{link},
+		},
+		{
+			name:       "header part",
+			part:       "word/header1.xml",
+			styles:     testHyperlinkStyles,
+			body:       p(`{{hyperlink .Url "site"}}`),
+			paragraphs: []string{"site"},
+			contains:   []string{`<w:hyperlink r:id="rId1"`},
+			rels:       []MediaRel{{Type: HyperlinkMediaType, RefID: "rId1", Source: "https://example.com/?a=1&b=2", TargetMode: "External"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := map[string]string{}
+			if tt.styles != "" {
+				parts["word/styles.xml"] = tt.styles
+			}
+
+			d := newTestDocumentMeta(t, "", parts)
+			output, rels := renderTestPartRels(t, d, TemplateConfig{}, tt.part, testDocument(tt.body), data)
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+
+			for _, s := range tt.contains {
+				if !strings.Contains(output, s) {
+					t.Errorf("output doesn't contain %s\n%s", s, output)
+				}
+			}
+
+			for _, s := range tt.excludes {
+				if strings.Contains(output, s) {
+					t.Errorf("output contains %s\n%s", s, output)
+				}
+			}
+
+			if !reflect.DeepEqual(rels, tt.rels) {
+				t.Errorf("got relationships %+v, want %+v", rels, tt.rels)
+			}
+		})
+	}
+}
+
+func TestHyperlinkErrors(t *testing.T) {
+	if _, err := hyperlink("", "text"); err == nil || err.Error() != "func 'hyperlink': empty url" {
+		t.Errorf("got error %v, want func 'hyperlink': empty url", err)
+	}
+}
@@ -0,0 +1,97 @@
+package docx
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	LimitMaxOutputSize = "MaxOutputSize"
+	LimitMaxPartSize   = "MaxPartSize"
+	LimitMaxImages     = "MaxImages"
+	LimitMaxInputSize  = "MaxInputSize"
+)
+
+// LimitError is returned when a template exceeds one of the configured resource limits.
+type LimitError struct {
+	// Limit is the name of the exceeded limit (e.g. "MaxPartSize")
+	Limit string
+	// Max is the configured value of the limit
+	Max int64
+	// Part is the docx file part being processed, empty if the limit is not part specific
+	Part string
+}
+
+func (e *LimitError) Error() string {
+	if e.Part != "" {
+		return fmt.Sprintf("limit %s of %d exceeded in file '%s'", e.Limit, e.Max, e.Part)
+	}
+
+	return fmt.Sprintf("limit %s of %d exceeded", e.Limit, e.Max)
+}
+
+// executionWriter collects the output of a template execution, aborting it
+// as soon as the context is done or the output grows over maxSize (0 means no limit).
+// The context is checked on each write, even an empty one (see rewriteContextChecks).
+type executionWriter struct {
+	ctx     context.Context
+	buf     bytes.Buffer
+	maxSize int64
+	name    string
+}
+
+func (w *executionWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if w.maxSize > 0 && int64(w.buf.Len()+len(p)) > w.maxSize {
+		return 0, &LimitError{
+			Limit: LimitMaxPartSize,
+			Max:   w.maxSize,
+			Part:  w.name,
+		}
+	}
+
+	return w.buf.Write(p)
+}
+
+// rewriteContextChecks prepends an empty text to the body of the range actions of the parsed template.
+// Since the texts are always written, even when empty, the execution writer checks the context at each
+// iteration of the loops that print nothing. A running function call can't be interrupted though.
+func rewriteContextChecks(tmpl *template.Template) {
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+
+		addContextChecks(t.Tree.Root)
+	}
+}
+
+func addContextChecks(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.IfNode:
+			addContextChecks(n.List)
+			addContextChecks(n.ElseList)
+		case *parse.RangeNode:
+			addContextChecks(n.List)
+			addContextChecks(n.ElseList)
+			if n.List != nil {
+				check := &parse.TextNode{NodeType: parse.NodeText, Pos: n.List.Pos}
+				n.List.Nodes = append([]parse.Node{check}, n.List.Nodes...)
+			}
+		case *parse.WithNode:
+			addContextChecks(n.List)
+			addContextChecks(n.ElseList)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package docx
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExecuteXmlTemplateStopsSilentLoops(t *testing.T) {
+	tmpl, err := TemplateConfig{}.Parse("word/document.xml", `{{range .}}{{$n := .}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = ExecuteXmlTemplate(ctx, tmpl, make([]int, 1_000_000), 0)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestExecuteXmlTemplateMaxPartSize(t *testing.T) {
+	tmpl, err := TemplateConfig{}.Parse("word/document.xml", `{{range .}}{{.}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ExecuteXmlTemplate(context.Background(), tmpl, []string{"abc", "def"}, 5)
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitMaxPartSize {
+		t.Fatalf("got error %v, want a %s limit error", err, LimitMaxPartSize)
+	}
+}
@@ -0,0 +1,347 @@
+package docx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NumberingContentType is the content type of word/numbering.xml.
+const NumberingContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"
+
+// listLevels is the number of levels of a list definition.
+const listLevels = 9
+
+// listItem is an item of a list generated by the bulletList and numberedList
+// template functions, passed to the output processing in their placeholder.
+type listItem struct {
+	Text  string     `json:"t"`
+	Items []listItem `json:"i,omitempty"`
+}
+
+var (
+	listPlaceholderRe  = regexp.MustCompile(`\[\[(BULLET_LIST|NUMBERED_LIST):([A-Za-z0-9+/=]*)\]\]`)
+	numPrRe            = regexp.MustCompile(`(?s)<w:numPr>.*?</w:numPr>|<w:numPr\b[^>]*/>`)
+	numIdRe            = regexp.MustCompile(`<w:numId w:val="(\d+)"`)
+	ilvlRe             = regexp.MustCompile(`<w:ilvl w:val="(\d+)"`)
+	indRe              = regexp.MustCompile(`(?s)<w:ind\b[^>]*/>|<w:ind\b[^>]*>.*?</w:ind>`)
+	pPrAfterNumPrRe    = regexp.MustCompile(`<w:(?:suppressLineNumbers|pBdr|shd|tabs|suppressAutoHyphens|kinsoku|wordWrap|overflowPunct|topLinePunct|autoSpaceDE|autoSpaceDN|bidi|adjustRightInd|snapToGrid|spacing|ind|contextualSpacing|mirrorIndents|suppressOverlap|jc|textDirection|textAlignment|textboxTightWrap|outlineLvl|divId|cnfStyle|rPr|sectPr|pPrChange)\b|</w:pPr>`)
+	numRe              = regexp.MustCompile(`(?s)<w:num\b[^>]*\bw:numId="(\d+)"[^>]*>.*?<w:abstractNumId w:val="(\d+)"`)
+	abstractNumIdRe    = regexp.MustCompile(`<w:abstractNum\b[^>]*\bw:abstractNumId="(\d+)"`)
+	firstNumRe         = regexp.MustCompile(`<w:num\b|<w:numIdMacAtCleanup\b|</w:numbering>`)
+	numbersEndRe       = regexp.MustCompile(`<w:numIdMacAtCleanup\b|</w:numbering>`)
+	bulletLevelsText   = []string{"•", "◦", "▪"}
+	numberLevelsFormat = []string{"decimal", "lowerLetter", "lowerRoman"}
+)
+
+// bulletList generates a bulleted list, a paragraph per item. The items which are
+// slices are the sub-list of the previous item.
+func bulletList(items any) (string, error) {
+	return listPlaceholder("bulletList", "BULLET_LIST", items)
+}
+
+// numberedList generates a numbered list, a paragraph per item, whose numbering starts at 1
+// at each call. The items which are slices are the sub-list of the previous item.
+func numberedList(items any) (string, error) {
+	return listPlaceholder("numberedList", "NUMBERED_LIST", items)
+}
+
+// listPlaceholder returns the placeholder of the list generated by funcName.
+func listPlaceholder(funcName, kind string, items any) (string, error) {
+	list, err := toListItems(reflect.ValueOf(items))
+	if err != nil {
+		return "", fmt.Errorf("func '%s': %w", funcName, err)
+	}
+
+	b, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("func '%s': unable to encode list: %w", funcName, err)
+	}
+
+	return fmt.Sprintf("[[%s:%s]]", kind, base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// toListItems returns the items of a list, the items which are slices being the sub-list of the previous item.
+func toListItems(v reflect.Value) ([]listItem, error) {
+	v = indirect(v)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Array, reflect.Slice:
+	default:
+		return nil, fmt.Errorf("can't iterate over %v", v.Interface())
+	}
+
+	items := []listItem{}
+	for i := 0; i < v.Len(); i++ {
+		item := indirect(v.Index(i))
+		if !isSubList(item) {
+			text := ""
+			if item.IsValid() {
+				text = fmt.Sprint(item.Interface())
+			}
+			items = append(items, listItem{Text: text})
+			continue
+		}
+
+		if len(items) == 0 {
+			return nil, fmt.Errorf("the sub-list %v must follow an item", item.Interface())
+		}
+
+		subItems, err := toListItems(item)
+		if err != nil {
+			return nil, err
+		}
+
+		last := &items[len(items)-1]
+		last.Items = append(last.Items, subItems...)
+	}
+
+	return items, nil
+}
+
+// isSubList reports whether the list item is a sub-list, i.e. a slice other than []byte.
+func isSubList(item reflect.Value) bool {
+	switch item.Kind() {
+	case reflect.Array, reflect.Slice:
+		return item.Type().Elem().Kind() != reflect.Uint8
+	}
+
+	return false
+}
+
+// numbering holds the list definitions of word/numbering.xml and the ones added while rendering.
+type numbering struct {
+	// source is the content of word/numbering.xml, empty if the document has none
+	source string
+	// abstractNumIds maps the num ids of numbering.xml to the id of their abstractNum
+	abstractNumIds      map[int]int
+	maxNumId            int
+	maxAbstractNumId    int
+	addedAbstracts      []string
+	addedNums           []string
+	bulletAbstractNumId int
+	numberAbstractNumId int
+	bulletNumId         int
+}
+
+// parseNumbering returns the list definitions of the numbering.xml source.
+func parseNumbering(source string) numbering {
+	n := numbering{
+		source:         source,
+		abstractNumIds: map[int]int{},
+	}
+
+	for _, m := range numRe.FindAllStringSubmatch(source, -1) {
+		numId, _ := strconv.Atoi(m[1])
+		abstractNumId, _ := strconv.Atoi(m[2])
+		n.abstractNumIds[numId] = abstractNumId
+		if numId > n.maxNumId {
+			n.maxNumId = numId
+		}
+	}
+
+	for _, m := range abstractNumIdRe.FindAllStringSubmatch(source, -1) {
+		if id, _ := strconv.Atoi(m[1]); id > n.maxAbstractNumId {
+			n.maxAbstractNumId = id
+		}
+	}
+
+	return n
+}
+
+// clone returns a copy of the numbering that can be extended without affecting the original.
+func (n numbering) clone() numbering {
+	n.addedAbstracts = append([]string(nil), n.addedAbstracts...)
+	n.addedNums = append([]string(nil), n.addedNums...)
+
+	return n
+}
+
+// addAbstractNum adds a list definition with bullets or numbers and returns its id.
+func (n *numbering) addAbstractNum(numbered bool) int {
+	n.maxAbstractNumId++
+	id := n.maxAbstractNumId
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<w:abstractNum w:abstractNumId="%d"><w:multiLevelType w:val="hybridMultilevel"/>`, id)
+	for lvl := 0; lvl < listLevels; lvl++ {
+		format, text := "bullet", bulletLevelsText[lvl%len(bulletLevelsText)]
+		if numbered {
+			format, text = numberLevelsFormat[lvl%len(numberLevelsFormat)], fmt.Sprintf("%%%d.", lvl+1)
+		}
+		fmt.Fprintf(&sb, `<w:lvl w:ilvl="%d"><w:start w:val="1"/><w:numFmt w:val="%s"/><w:lvlText w:val="%s"/>`+
+			`<w:lvlJc w:val="left"/><w:pPr><w:ind w:left="%d" w:hanging="360"/></w:pPr></w:lvl>`, lvl, format, text, 720*(lvl+1))
+	}
+	sb.WriteString(`</w:abstractNum>`)
+	n.addedAbstracts = append(n.addedAbstracts, sb.String())
+
+	return id
+}
+
+// addNum adds a num instance of the list definition and returns its id. When restart is true
+// the numbering of all its levels starts over instead of continuing the one of the other instances.
+func (n *numbering) addNum(abstractNumId int, restart bool) int {
+	n.maxNumId++
+	id := n.maxNumId
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<w:num w:numId="%d"><w:abstractNumId w:val="%d"/>`, id, abstractNumId)
+	if restart {
+		for lvl := 0; lvl < listLevels; lvl++ {
+			fmt.Fprintf(&sb, `<w:lvlOverride w:ilvl="%d"><w:startOverride w:val="1"/></w:lvlOverride>`, lvl)
+		}
+	}
+	sb.WriteString(`</w:num>`)
+	n.addedNums = append(n.addedNums, sb.String())
+
+	return id
+}
+
+// listNumId returns the num id of a generated list, based on the list definition of the template
+// paragraph num id if any (0 if none) or on the one added for the kind of list. Each numbered list
+// gets its own num instance, so its numbering starts at 1.
+func (n *numbering) listNumId(numbered bool, templateNumId int) int {
+	if abstractNumId, ok := n.abstractNumIds[templateNumId]; ok && templateNumId > 0 {
+		if !numbered {
+			return templateNumId
+		}
+		return n.addNum(abstractNumId, true)
+	}
+
+	if numbered {
+		if n.numberAbstractNumId == 0 {
+			n.numberAbstractNumId = n.addAbstractNum(true)
+		}
+		return n.addNum(n.numberAbstractNumId, true)
+	}
+
+	if n.bulletNumId == 0 {
+		n.bulletAbstractNumId = n.addAbstractNum(false)
+		n.bulletNumId = n.addNum(n.bulletAbstractNumId, false)
+	}
+
+	return n.bulletNumId
+}
+
+// numberingXml returns the content of word/numbering.xml along with the list
+// definitions added while rendering, and whether any was added.
+func (d *DocumentMeta) numberingXml() ([]byte, bool) {
+	n := d.numbering
+	if len(n.addedNums) == 0 {
+		return []byte(n.source), false
+	}
+
+	source := n.source
+	if source == "" {
+		source = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"></w:numbering>`
+	}
+
+	// the abstractNum elements precede the num ones
+	loc := firstNumRe.FindStringIndex(source)
+	if loc == nil {
+		return []byte(n.source), false
+	}
+	source = source[:loc[0]] + strings.Join(n.addedAbstracts, "") + source[loc[0]:]
+
+	loc = numbersEndRe.FindStringIndex(source)
+	source = source[:loc[0]] + strings.Join(n.addedNums, "") + source[loc[0]:]
+
+	return []byte(source), true
+}
+
+// applyLists replaces the [[BULLET_LIST:...]] and [[NUMBERED_LIST:...]] placeholders with a paragraph
+// per item, styled as the paragraph and the run containing the placeholder. The list definition of the
+// paragraph, if it is a list item, is used for the generated list, otherwise one is added to numbering.xml.
+// The paragraph is split around the list and the parts left without content are removed.
+func (d *DocumentMeta) applyLists(srcXML string) (string, error) {
+	for {
+		loc := listPlaceholderRe.FindStringSubmatchIndex(srcXML)
+		if loc == nil {
+			return srcXML, nil
+		}
+
+		b, err := base64.StdEncoding.DecodeString(srcXML[loc[4]:loc[5]])
+		if err != nil {
+			return srcXML, fmt.Errorf("unable to decode list: %w", err)
+		}
+
+		var items []listItem
+		if err := json.Unmarshal(b, &items); err != nil {
+			return srcXML, fmt.Errorf("unable to decode list: %w", err)
+		}
+
+		tokens := tokenizeXml(srcXML)
+		k, offset := textTokenAt(tokens, loc[0])
+		if k < 0 {
+			return srcXML, fmt.Errorf("unable to locate list placeholder")
+		}
+
+		pStart, pEnd := enclosingElement(tokens, k, "w:p")
+		if pStart < 0 {
+			return srcXML, fmt.Errorf("list placeholder outside of a paragraph")
+		}
+
+		pPr := ""
+		if tokens[pStart+1].qualifiedName() == "w:pPr" {
+			pPr = joinTokens(tokens[pStart+1 : elementEnd(tokens, pStart+1)+1])
+		}
+
+		rPr := ""
+		if rStart, _ := enclosingElement(tokens, k, "w:r"); rStart >= 0 && tokens[rStart+1].qualifiedName() == "w:rPr" {
+			rPr = joinTokens(tokens[rStart+1 : elementEnd(tokens, rStart+1)+1])
+		}
+
+		templateNumId, level := 0, 0
+		if m := numIdRe.FindStringSubmatch(pPr); m != nil {
+			templateNumId, _ = strconv.Atoi(m[1])
+		}
+		if m := ilvlRe.FindStringSubmatch(pPr); m != nil {
+			level, _ = strconv.Atoi(m[1])
+		}
+
+		numId := d.numbering.listNumId(srcXML[loc[2]:loc[3]] == "NUMBERED_LIST", templateNumId)
+
+		paragraphs := []string{}
+		writeListItems(&paragraphs, items, pPr, rPr, numId, level)
+
+		beforeXml, afterXml := splitParagraph(tokens, pStart, pEnd, k, loc[0]-offset, loc[1]-offset)
+		if isBlankParagraph(beforeXml) {
+			beforeXml = ""
+		}
+		if isBlankParagraph(afterXml) {
+			afterXml = ""
+		}
+
+		srcXML = joinTokens(tokens[:pStart]) + beforeXml + strings.Join(paragraphs, "") + afterXml + joinTokens(tokens[pEnd+1:])
+	}
+}
+
+// writeListItems appends a paragraph per item to paragraphs, the sub-lists one level deeper.
+// The indentation and the section break of the paragraph properties are removed, since the
+// list definition sets the indentation of each level.
+func writeListItems(paragraphs *[]string, items []listItem, pPr, rPr string, numId, level int) {
+	if level >= listLevels {
+		level = listLevels - 1
+	}
+
+	if !strings.HasSuffix(pPr, "</w:pPr>") {
+		pPr = "<w:pPr></w:pPr>"
+	}
+	pPr = numPrRe.ReplaceAllLiteralString(pPr, "")
+	pPr = indRe.ReplaceAllLiteralString(pPr, "")
+	pPr = sectPrRe.ReplaceAllLiteralString(pPr, "")
+	i := pPrAfterNumPrRe.FindStringIndex(pPr)[0]
+	pPr = pPr[:i] + fmt.Sprintf(`<w:numPr><w:ilvl w:val="%d"/><w:numId w:val="%d"/></w:numPr>`, level, numId) + pPr[i:]
+
+	for _, item := range items {
+		*paragraphs = append(*paragraphs, fmt.Sprintf(`<w:p>%s<w:r>%s<w:t xml:space="preserve">%s</w:t></w:r></w:p>`,
+			pPr, rPr, xmlTextEscaper.Replace(item.Text)))
+		writeListItems(paragraphs, item.Items, pPr, rPr, numId, level+1)
+	}
+}
@@ -0,0 +1,103 @@
+package docx
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testNumbering = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<w:numbering xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:numFmt w:val="decimal"/></w:lvl></w:abstractNum>` +
+	`<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>` +
+	`</w:numbering>`
+
+var testNumPrRe = regexp.MustCompile(`<w:numPr><w:ilvl w:val="(\d+)"/><w:numId w:val="(\d+)"/></w:numPr>`)
+
+// numPrs returns the "level/numId" of the list paragraphs of the given XML.
+func numPrs(content string) []string {
+	numPrs := []string{}
+	for _, m := range testNumPrRe.FindAllStringSubmatch(content, -1) {
+		numPrs = append(numPrs, m[1]+"/"+m[2])
+	}
+
+	return numPrs
+}
+
+func TestLists(t *testing.T) {
+	data := map[string]any{
+		"L": []any{"a", "b", []string{"b1", "b2"}, "c"},
+		"N": []string{"x", "y"},
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		numbering  string
+		paragraphs []string
+		numPrs     []string
+		// added are the elements added to numbering.xml, nil if unchanged
+		added []string
+	}{
+		{
+			name:       "bullet list with a sub-list",
+			body:       p("{{bulletList .L}}"),
+			paragraphs: []string{"a", "b", "b1", "b2", "c"},
+			numPrs:     []string{"0/1", "0/1", "1/1", "1/1", "0/1"},
+			added:      []string{`<w:abstractNum w:abstractNumId="1">`, `<w:lvlText w:val="•"/>`, `<w:num w:numId="1"><w:abstractNumId w:val="1"/></w:num>`},
+		},
+		{
+			name:       "numbered lists restarting at 1",
+			body:       p("{{numberedList .N}}") + p("then") + p("{{numberedList .N}}"),
+			numbering:  testNumbering,
+			paragraphs: []string{"x", "y", "then", "x", "y"},
+			numPrs:     []string{"0/2", "0/2", "0/3", "0/3"},
+			added:      []string{`<w:abstractNum w:abstractNumId="1">`, `<w:num w:numId="2"><w:abstractNumId w:val="1"/><w:lvlOverride w:ilvl="0"><w:startOverride w:val="1"/>`, `<w:num w:numId="3">`},
+		},
+		{
+			name:       "list paragraph of the template",
+			body:       `<w:p><w:pPr><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr></w:pPr><w:r><w:t>{{bulletList .N}}</w:t></w:r></w:p>`,
+			numbering:  testNumbering,
+			paragraphs: []string{"x", "y"},
+			numPrs:     []string{"0/1", "0/1"},
+		},
+		{
+			name:       "list in a text paragraph",
+			body:       p("Items: {{bulletList .N}}"),
+			paragraphs: []string{"Items: ", "x", "y"},
+			numPrs:     []string{"0/1", "0/1"},
+			added:      []string{`<w:num w:numId="1">`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := map[string]string{}
+			if tt.numbering != "" {
+				parts["word/numbering.xml"] = tt.numbering
+			}
+
+			d := newTestDocumentMeta(t, tt.body, parts)
+			output := renderTestPart(t, d, TemplateConfig{}, "word/document.xml", testDocument(tt.body), data)
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+
+			if got := numPrs(output); !reflect.DeepEqual(got, tt.numPrs) {
+				t.Errorf("got list levels/numIds %q, want %q\n%s", got, tt.numPrs, output)
+			}
+
+			part := generatedPart(t, d, "word/numbering.xml")
+			if part.Changed != (tt.added != nil) {
+				t.Fatalf("got numbering.xml changed %v, want %v\n%s", part.Changed, tt.added != nil, part.Content)
+			}
+			for _, added := range tt.added {
+				if !strings.Contains(string(part.Content), added) {
+					t.Errorf("numbering.xml doesn't contain %s\n%s", added, part.Content)
+				}
+			}
+		})
+	}
+}
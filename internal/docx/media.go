@@ -11,6 +11,7 @@ import (
 
 const (
 	ImageMediaType = iota + 1
+	HyperlinkMediaType
 )
 
 const emusPerInch = 914400.0
@@ -26,9 +27,11 @@ type MediaRel struct {
 	Type   uint
 	RefID  string
 	Source string
+	// TargetMode is "External" for the targets outside of the package (e.g. the hyperlinks urls)
+	TargetMode string
 }
 
-func (d *documentMeta) computeDocxImageSize(imageData []byte) (int, int, error) {
+func (d *DocumentMeta) computeDocxImageSize(imageData []byte) (int, int, error) {
 	cfg, _, err := stdimage.DecodeConfig(bytes.NewReader(imageData))
 	if err != nil {
 		return 0, 0, err
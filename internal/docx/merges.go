@@ -0,0 +1,279 @@
+package docx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var tableCellMergeRe = regexp.MustCompile(`\[\[TABLE_CELL_(GRID_SPAN|VMERGE|VMERGE_BY):([^\]]*)\]\]`)
+
+type mergeCell struct {
+	start, end int
+	// tcPr and widthToken are the <w:tcPr> and <w:tcW/> tokens, -1 if missing
+	tcPr       int
+	widthToken int
+	gridStart  int
+	span       int
+	// gridSpan is the span set with mergeCols, 0 if not set
+	gridSpan int
+	// vMerge is the vertical merge set with mergeRows: "restart", "continue" or ""
+	vMerge string
+	// mergeBy is the value given to mergeRowsBy, nil if not set
+	mergeBy *string
+	removed bool
+}
+
+type mergeTable struct {
+	// gridCols are the widths of the grid columns
+	gridCols []int
+	rows     [][]*mergeCell
+	// gridBefore is the number of grid columns skipped before the first cell of each row
+	gridBefore []int
+}
+
+// replaceTableCellMerges is used to apply the [[TABLE_CELL_GRID_SPAN:n]], [[TABLE_CELL_VMERGE:restart|continue]]
+// and [[TABLE_CELL_VMERGE_BY:value]] placeholders to the table cell containing them: the cells merged
+// with mergeCols get a gridSpan and the width of the grid columns they now cover, whose following cells in
+// the row are removed, the cells merged with mergeRows or mergeRowsBy get a vMerge and the runs of the continued cells are removed.
+// A mergeRowsBy cell continues the merge of the cell above it when both were given the same value.
+func replaceTableCellMerges(srcXML string) string {
+	if !tableCellMergeRe.MatchString(srcXML) {
+		return srcXML
+	}
+
+	tokens := tokenizeXml(srcXML)
+	tables := []*mergeTable{}
+	opened := []*mergeTable{}
+	for i, token := range tokens {
+		var table *mergeTable
+		if len(opened) > 0 {
+			table = opened[len(opened)-1]
+		}
+
+		var cell *mergeCell
+		if table != nil && len(table.rows) > 0 {
+			row := table.rows[len(table.rows)-1]
+			if len(row) > 0 && row[len(row)-1].end < 0 {
+				cell = row[len(row)-1]
+			}
+		}
+
+		name := token.qualifiedName()
+		switch {
+		case token.isOpening() && name == "w:tbl":
+			table = &mergeTable{}
+			tables = append(tables, table)
+			opened = append(opened, table)
+		case token.isClosing() && name == "w:tbl" && table != nil:
+			opened = opened[:len(opened)-1]
+		case table == nil:
+			continue
+		case name == "w:gridCol" && len(table.rows) == 0:
+			table.gridCols = append(table.gridCols, xmlIntAttr(token.value, xmlWidthAttrRe, 0))
+		case token.isOpening() && name == "w:tr":
+			table.rows = append(table.rows, []*mergeCell{})
+			table.gridBefore = append(table.gridBefore, 0)
+		case name == "w:gridBefore" && len(table.rows) > 0 && cell == nil:
+			table.gridBefore[len(table.rows)-1] = xmlIntAttr(token.value, xmlValAttrRe, 0)
+		case token.isOpening() && name == "w:tc" && len(table.rows) > 0:
+			row := table.rows[len(table.rows)-1]
+			table.rows[len(table.rows)-1] = append(row, &mergeCell{start: i, end: -1, tcPr: -1, widthToken: -1, span: 1})
+		case cell == nil:
+			continue
+		case token.isClosing() && name == "w:tc":
+			cell.end = i
+		case name == "w:tcPr" && !token.isClosing():
+			cell.tcPr = i
+		case name == "w:tcW":
+			cell.widthToken = i
+		case name == "w:gridSpan":
+			cell.span = xmlIntAttr(token.value, xmlValAttrRe, 1)
+		case !token.isTag && tableCellMergeRe.MatchString(token.value):
+			for _, m := range tableCellMergeRe.FindAllStringSubmatch(token.value, -1) {
+				switch m[1] {
+				case "GRID_SPAN":
+					cell.gridSpan, _ = strconv.Atoi(m[2])
+				case "VMERGE":
+					cell.vMerge = m[2]
+				case "VMERGE_BY":
+					value := m[2]
+					cell.mergeBy = &value
+				}
+			}
+			tokens[i].value = tableCellMergeRe.ReplaceAllString(token.value, "")
+			removeEmptyRun(tokens, i)
+		}
+	}
+
+	// the nested tables first, so that the removed cells are removed along with their tables
+	for i := len(tables) - 1; i >= 0; i-- {
+		tables[i].merge(tokens)
+	}
+
+	return joinTokens(tokens)
+}
+
+// merge applies the merges of the table cells to their tokens.
+func (t *mergeTable) merge(tokens []xmlToken) {
+	for r, row := range t.rows {
+		col := t.gridBefore[r]
+		for k := 0; k < len(row); k++ {
+			cell := row[k]
+			cell.gridStart = col
+			spanned := cell.gridSpan > cell.span
+			for covered := cell.span; covered < cell.gridSpan && k+1 < len(row); k++ {
+				row[k+1].removed = true
+				covered += row[k+1].span
+				cell.span = covered
+			}
+			if spanned && cell.span < cell.gridSpan {
+				cell.span = cell.gridSpan
+			}
+			col += cell.span
+
+			if cell.mergeBy != nil {
+				cell.vMerge = "restart"
+				if above := t.cellAbove(r, cell); above != nil && above.mergeBy != nil && *above.mergeBy == *cell.mergeBy {
+					cell.vMerge = "continue"
+				}
+			}
+
+			var props strings.Builder
+			if spanned {
+				t.setSpannedWidth(tokens, cell, &props)
+				fmt.Fprintf(&props, `<w:gridSpan w:val="%d"/>`, cell.span)
+			}
+			switch cell.vMerge {
+			case "restart":
+				props.WriteString(`<w:vMerge w:val="restart"/>`)
+			case "continue":
+				props.WriteString(`<w:vMerge/>`)
+				removeCellRuns(tokens, cell)
+			}
+			if props.Len() > 0 {
+				setCellProps(tokens, cell, props.String(), spanned)
+			}
+		}
+
+		for _, cell := range row {
+			if cell.removed {
+				for i := cell.start; i <= cell.end; i++ {
+					tokens[i] = xmlToken{}
+				}
+			}
+		}
+	}
+}
+
+// setSpannedWidth sets the dxa width of the cell merged with mergeCols to the sum of the grid
+// columns it spans, the width is written to props when the cell has none.
+func (t *mergeTable) setSpannedWidth(tokens []xmlToken, cell *mergeCell, props *strings.Builder) {
+	if cell.gridStart+cell.span > len(t.gridCols) {
+		return
+	}
+
+	width := 0
+	for _, gridCol := range t.gridCols[cell.gridStart : cell.gridStart+cell.span] {
+		width += gridCol
+	}
+
+	if cell.widthToken < 0 {
+		fmt.Fprintf(props, `<w:tcW w:w="%d" w:type="dxa"/>`, width)
+		return
+	}
+
+	tcW := tokens[cell.widthToken].value
+	if m := xmlTypeAttrRe.FindStringSubmatch(tcW); m != nil && m[1] != "dxa" {
+		return
+	}
+	tokens[cell.widthToken].value = xmlWidthAttrRe.ReplaceAllLiteralString(tcW, fmt.Sprintf(`w:w="%d"`, width))
+}
+
+// cellAbove returns the cell of the previous row covering the same grid columns as the cell, if any.
+func (t *mergeTable) cellAbove(r int, cell *mergeCell) *mergeCell {
+	if r == 0 {
+		return nil
+	}
+
+	for _, above := range t.rows[r-1] {
+		if !above.removed && above.gridStart == cell.gridStart && above.span == cell.span {
+			return above
+		}
+	}
+
+	return nil
+}
+
+// setCellProps replaces the gridSpan (if spanned) and the vMerge of the cell properties
+// with the given ones, which are inserted after the cell width as required by the schema.
+func setCellProps(tokens []xmlToken, cell *mergeCell, props string, spanned bool) {
+	if cell.tcPr < 0 {
+		tokens[cell.start].value += "<w:tcPr>" + props + "</w:tcPr>"
+		return
+	}
+
+	if !tokens[cell.tcPr].isOpening() {
+		tokens[cell.tcPr].value = "<w:tcPr>" + props + "</w:tcPr>"
+		return
+	}
+
+	end := elementEnd(tokens, cell.tcPr)
+	if end < 0 {
+		return
+	}
+
+	insertAfter := cell.tcPr
+	for i := cell.tcPr + 1; i < end; i++ {
+		switch tokens[i].qualifiedName() {
+		case "w:cnfStyle", "w:tcW":
+			insertAfter = elementEnd(tokens, i)
+		case "w:gridSpan":
+			if spanned {
+				tokens[i] = xmlToken{}
+			} else {
+				insertAfter = elementEnd(tokens, i)
+			}
+		case "w:vMerge":
+			if strings.Contains(props, "<w:vMerge") {
+				tokens[i] = xmlToken{}
+			}
+		}
+	}
+
+	tokens[insertAfter].value += props
+}
+
+// removeCellRuns removes the runs of the cell, keeping its paragraphs.
+func removeCellRuns(tokens []xmlToken, cell *mergeCell) {
+	for i := cell.start; i < cell.end; i++ {
+		if !tokens[i].isOpening() || tokens[i].qualifiedName() != "w:r" {
+			continue
+		}
+
+		end := elementEnd(tokens, i)
+		if end < 0 {
+			return
+		}
+		for ; i <= end; i++ {
+			tokens[i] = xmlToken{}
+		}
+	}
+}
+
+// removeEmptyRun removes the run of the i-th token if it has no content left.
+func removeEmptyRun(tokens []xmlToken, i int) {
+	start, end := enclosingElement(tokens, i, "w:r")
+	if start < 0 {
+		return
+	}
+
+	if text, ok := paragraphText(tokens[start : end+1]); !ok || text != "" {
+		return
+	}
+
+	for j := start; j <= end; j++ {
+		tokens[j] = xmlToken{}
+	}
+}
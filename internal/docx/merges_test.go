@@ -0,0 +1,86 @@
+package docx
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var testCellPropsRe = regexp.MustCompile(`<w:tcPr>.*?</w:tcPr>`)
+
+// cellsProps returns the properties of each cell of the given XML, which must not contain nested tables.
+func cellsProps(content string) []string {
+	props := []string{}
+	for _, cell := range testCellRe.FindAllString(content, -1) {
+		props = append(props, testCellPropsRe.FindString(cell))
+	}
+
+	return props
+}
+
+func TestTableCellMerges(t *testing.T) {
+	data := map[string]any{
+		"Items": []map[string]any{
+			{"Group": "A", "Name": "a1"},
+			{"Group": "A", "Name": "a2"},
+			{"Group": "B", "Name": "b1"},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		body  string
+		want  [][]string
+		props []string
+	}{
+		{
+			name:  "merged columns",
+			body:  tbl([]int{1000, 2000, 3000}, tr(tc(p("{{mergeCols 2}}A")), tc(p("B")), tc(p("C")))),
+			want:  [][]string{{"A", "C"}},
+			props: []string{`<w:tcPr><w:tcW w:w="3000" w:type="dxa"/><w:gridSpan w:val="2"/></w:tcPr>`, `<w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>`},
+		},
+		{
+			name:  "merged columns without properties",
+			body:  tbl([]int{1000, 2000}, tr(`<w:tc>`+p("{{mergeCols 2}}A")+`</w:tc>`, `<w:tc>`+p("B")+`</w:tc>`)),
+			want:  [][]string{{"A"}},
+			props: []string{`<w:tcPr><w:tcW w:w="3000" w:type="dxa"/><w:gridSpan w:val="2"/></w:tcPr>`},
+		},
+		{
+			name: "merged rows",
+			body: tbl([]int{1000, 1000},
+				tr(tc(p(`{{mergeRows "restart"}}A`)), tc(p("x"))),
+				tr(tc(p(`{{mergeRows "continue"}}hidden`)), tc(p("y"))),
+			),
+			want: [][]string{{"A", "x"}, {"", "y"}},
+			props: []string{
+				`<w:tcPr><w:tcW w:w="2000" w:type="dxa"/><w:vMerge w:val="restart"/></w:tcPr>`, `<w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>`,
+				`<w:tcPr><w:tcW w:w="2000" w:type="dxa"/><w:vMerge/></w:tcPr>`, `<w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>`,
+			},
+		},
+		{
+			name: "rows merged by value",
+			body: tbl([]int{1000, 1000}, tr(tc(p("{{rowRange .Items}}{{mergeRowsBy .Group}}{{.Group}}")), tc(p("{{.Name}}")))),
+			want: [][]string{{"A", "a1"}, {"", "a2"}, {"B", "b1"}},
+			props: []string{
+				`<w:tcPr><w:tcW w:w="2000" w:type="dxa"/><w:vMerge w:val="restart"/></w:tcPr>`, `<w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>`,
+				`<w:tcPr><w:tcW w:w="2000" w:type="dxa"/><w:vMerge/></w:tcPr>`, `<w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>`,
+				`<w:tcPr><w:tcW w:w="2000" w:type="dxa"/><w:vMerge w:val="restart"/></w:tcPr>`, `<w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := renderTestDocument(t, tt.body, data)
+
+			if got := rowsTexts(output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got rows %q, want %q\n%s", got, tt.want, output)
+			}
+
+			if got := cellsProps(output); !reflect.DeepEqual(got, tt.props) {
+				t.Errorf("got cells properties\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.props, "\n"))
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package docx
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+)
+
+// MissingKeyMode tells how the template actions referencing a missing map key are executed.
+type MissingKeyMode int
+
+const (
+	// MissingKeyError makes the execution fail (default).
+	MissingKeyError MissingKeyMode = iota
+	// MissingKeyZero prints nothing, the missing value is false in if, with and range.
+	MissingKeyZero
+	// MissingKeyPlaceholder prints the policy placeholder, the missing value is false in if, with and range.
+	MissingKeyPlaceholder
+)
+
+// MissingKeyPolicy is the way the missing map keys (or nil values along a fields chain,
+// e.g. .Customer.Fax with no Customer) are handled. Fields missing in structs are always errors.
+type MissingKeyPolicy struct {
+	Mode        MissingKeyMode
+	Placeholder string
+}
+
+// MissingKeyLookupFunc is the template function the fields referenced by the template
+// actions are rewritten into, to apply the missing key policy and the default function.
+const MissingKeyLookupFunc = "__missingKeyLookup"
+
+// MissingKeyPolicy returns the missing key policy of the part with the given name.
+func (c TemplateConfig) MissingKeyPolicy(name string) MissingKeyPolicy {
+	if policy, ok := c.PartsMissingKey[name]; ok {
+		return policy
+	}
+
+	return c.MissingKey
+}
+
+// defaultValue returns value, or fallback if value is missing or empty
+// (nil, false, 0, an empty string, slice or map).
+func defaultValue(fallback, value any) any {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() {
+		return fallback
+	}
+
+	switch v.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String:
+		if v.Len() == 0 {
+			return fallback
+		}
+	default:
+		if v.IsZero() {
+			return fallback
+		}
+	}
+
+	return value
+}
+
+// lookupMissingKey evaluates the chain of fields on base like the template execution does,
+// but returns fallback if a map key is missing or a nil value is found along the chain.
+func lookupMissingKey(fallback, base any, fields ...string) (any, error) {
+	v := reflect.ValueOf(base)
+
+	for _, field := range fields {
+		var err error
+		v, err = lookupField(v, field)
+		if err != nil {
+			return nil, err
+		}
+
+		if !v.IsValid() {
+			return fallback, nil
+		}
+	}
+
+	if v.Kind() == reflect.Interface && v.IsNil() {
+		return fallback, nil
+	}
+
+	return v.Interface(), nil
+}
+
+// lookupField returns the value of the field, niladic method or map key of v,
+// or the invalid Value if v is nil or the map key is missing.
+func lookupField(v reflect.Value, field string) (reflect.Value, error) {
+	for v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return reflect.Value{}, nil
+	}
+
+	receiver := v
+	if receiver.Kind() != reflect.Pointer && receiver.CanAddr() {
+		receiver = receiver.Addr()
+	}
+	if method := receiver.MethodByName(field); method.IsValid() {
+		return callNiladicMethod(method, field)
+	}
+
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return reflect.Value{}, nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		sf, ok := v.Type().FieldByName(field)
+		if ok && sf.IsExported() {
+			return v.FieldByIndex(sf.Index), nil
+		}
+	case reflect.Map:
+		key := reflect.ValueOf(field)
+		if key.Type().ConvertibleTo(v.Type().Key()) {
+			value := v.MapIndex(key.Convert(v.Type().Key()))
+			if value.IsValid() && value.Kind() == reflect.Interface && value.IsNil() {
+				return reflect.Value{}, nil
+			}
+
+			return value, nil
+		}
+	}
+
+	return reflect.Value{}, fmt.Errorf("can't evaluate field %s in type %s", field, v.Type())
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callNiladicMethod calls a method referenced as a field, like {{.User.FullName}}.
+func callNiladicMethod(method reflect.Value, name string) (reflect.Value, error) {
+	typ := method.Type()
+	switch {
+	case typ.NumIn() != 0:
+		return reflect.Value{}, fmt.Errorf("wrong number of args for %s: want %d got 0", name, typ.NumIn())
+	case typ.NumOut() == 1:
+	case typ.NumOut() == 2 && typ.Out(1) == errorType:
+	default:
+		return reflect.Value{}, fmt.Errorf("can't call method %s with %d results", name, typ.NumOut())
+	}
+
+	results := method.Call(nil)
+	if len(results) == 2 && !results[1].IsNil() {
+		return reflect.Value{}, results[1].Interface().(error)
+	}
+
+	return results[0], nil
+}
+
+// missingKeyRewriter rewrites the fields referenced by the template actions into calls to
+// MissingKeyLookupFunc: all of them if the policy is not MissingKeyError, and in any case
+// the ones whose value is passed to the default function, which may be missing.
+type missingKeyRewriter struct {
+	tree        *parse.Tree
+	policy      MissingKeyPolicy
+	withDefault bool
+}
+
+// rewriteMissingKeys applies the missing key policy of the config to the parsed template.
+func (c TemplateConfig) rewriteMissingKeys(tmpl *template.Template) {
+	defaultFunc, ok := c.Funcs["default"]
+	withDefault := ok && reflect.ValueOf(defaultFunc).Pointer() == reflect.ValueOf(defaultValue).Pointer()
+
+	policy := c.MissingKeyPolicy(tmpl.Name())
+	if policy.Mode == MissingKeyError && !withDefault {
+		return
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+
+		r := missingKeyRewriter{
+			tree:        t.Tree,
+			policy:      policy,
+			withDefault: withDefault,
+		}
+		r.list(t.Tree.Root)
+	}
+}
+
+func (r *missingKeyRewriter) list(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			// only the printed values get the placeholder, not the declared variables
+			r.pipe(n.Pipe, len(n.Pipe.Decl) == 0)
+		case *parse.IfNode:
+			r.branch(&n.BranchNode)
+		case *parse.RangeNode:
+			r.branch(&n.BranchNode)
+		case *parse.WithNode:
+			r.branch(&n.BranchNode)
+		}
+	}
+}
+
+func (r *missingKeyRewriter) branch(n *parse.BranchNode) {
+	r.pipe(n.Pipe, false)
+	r.list(n.List)
+	r.list(n.ElseList)
+}
+
+// pipe rewrites the fields of a pipeline, printed tells whether its value is printed.
+func (r *missingKeyRewriter) pipe(p *parse.PipeNode, printed bool) {
+	if p == nil {
+		return
+	}
+
+	for i, cmd := range p.Cmds {
+		isDefault := r.isDefaultCall(cmd)
+		// Example: {{.Customer.Fax | default "N/A"}}
+		pipedToDefault := i+1 < len(p.Cmds) && r.isDefaultCall(p.Cmds[i+1])
+
+		for j, arg := range cmd.Args {
+			if pipe, ok := arg.(*parse.PipeNode); ok {
+				r.pipe(pipe, printed)
+				continue
+			}
+
+			if chain, ok := arg.(*parse.ChainNode); ok {
+				if pipe, ok := chain.Node.(*parse.PipeNode); ok {
+					r.pipe(pipe, printed)
+				}
+			}
+
+			// a field that is called with arguments or receives the piped value is a method
+			if j == 0 && (len(cmd.Args) > 1 || i > 0) {
+				continue
+			}
+
+			var fallback parse.Node
+			switch {
+			case isDefault && j > 0, j == 0 && pipedToDefault:
+				fallback = &parse.NilNode{NodeType: parse.NodeNil, Pos: arg.Position()}
+			case r.policy.Mode == MissingKeyError:
+				continue
+			case !printed:
+				fallback = &parse.NilNode{NodeType: parse.NodeNil, Pos: arg.Position()}
+			case r.policy.Mode == MissingKeyPlaceholder:
+				fallback = r.stringNode(r.policy.Placeholder, arg.Position())
+			default:
+				fallback = r.stringNode("", arg.Position())
+			}
+
+			cmd.Args[j] = r.lookup(arg, fallback)
+		}
+	}
+}
+
+func (r *missingKeyRewriter) isDefaultCall(cmd *parse.CommandNode) bool {
+	if !r.withDefault || len(cmd.Args) == 0 {
+		return false
+	}
+
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+
+	return ok && ident.Ident == "default"
+}
+
+func (r *missingKeyRewriter) stringNode(s string, pos parse.Pos) *parse.StringNode {
+	return &parse.StringNode{
+		NodeType: parse.NodeString,
+		Pos:      pos,
+		Quoted:   strconv.Quote(s),
+		Text:     s,
+	}
+}
+
+// lookup returns the call to MissingKeyLookupFunc evaluating the fields chain node,
+// or node itself if it is not a fields chain.
+func (r *missingKeyRewriter) lookup(node, fallback parse.Node) parse.Node {
+	pos := node.Position()
+
+	var base parse.Node
+	var fields []string
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		base = &parse.DotNode{NodeType: parse.NodeDot, Pos: pos}
+		fields = n.Ident
+	case *parse.VariableNode:
+		if len(n.Ident) < 2 {
+			return node
+		}
+		base = &parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: n.Ident[:1]}
+		fields = n.Ident[1:]
+	case *parse.ChainNode:
+		base = n.Node
+		fields = n.Field
+	default:
+		return node
+	}
+
+	args := []parse.Node{
+		parse.NewIdentifier(MissingKeyLookupFunc).SetTree(r.tree).SetPos(pos),
+		fallback,
+		base,
+	}
+	for _, field := range fields {
+		args = append(args, r.stringNode(field, pos))
+	}
+
+	return &parse.PipeNode{
+		NodeType: parse.NodePipe,
+		Pos:      pos,
+		Cmds: []*parse.CommandNode{{
+			NodeType: parse.NodeCommand,
+			Pos:      pos,
+			Args:     args,
+		}},
+	}
+}
+
+// MissingKeyLookupNode returns the fields chain node (e.g. .Customer.Fax) rewritten
+// into the given call to MissingKeyLookupFunc, so that the parse tree walkers can see it.
+func MissingKeyLookupNode(cmd *parse.CommandNode) (parse.Node, bool) {
+	if len(cmd.Args) < 3 {
+		return nil, false
+	}
+
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || ident.Ident != MissingKeyLookupFunc {
+		return nil, false
+	}
+
+	fields := []string{}
+	for _, arg := range cmd.Args[3:] {
+		s, ok := arg.(*parse.StringNode)
+		if !ok {
+			return nil, false
+		}
+		fields = append(fields, s.Text)
+	}
+
+	pos := cmd.Args[2].Position()
+	switch base := cmd.Args[2].(type) {
+	case *parse.DotNode:
+		return &parse.FieldNode{NodeType: parse.NodeField, Pos: pos, Ident: fields}, true
+	case *parse.VariableNode:
+		return &parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: append(append([]string{}, base.Ident...), fields...)}, true
+	default:
+		return &parse.ChainNode{NodeType: parse.NodeChain, Pos: pos, Node: base, Field: fields}, true
+	}
+}
@@ -0,0 +1,185 @@
+package docx
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// NamespacedFuncName returns the name under which a namespaced template function is registered,
+// e.g. "acme__formatIBAN" for {{acme.formatIBAN .IBAN}}, since text/template does not allow dots in
+// function names. The namespaced calls are resolved to it in the parse tree, it can't be called directly.
+func NamespacedFuncName(namespace, funcName string) string {
+	return namespace + "__" + funcName
+}
+
+// namespaceFuncs returns a function for each namespace, so that text/template parses the namespaced
+// calls as fields of the result of a function (e.g. {{acme.formatIBAN}}) before resolveNamespacedFuncs
+// replaces them. A function with the same name as a namespace is still called when used on its own.
+func (c TemplateConfig) namespaceFuncs() template.FuncMap {
+	funcs := make(template.FuncMap, len(c.Namespaces))
+	for _, namespace := range c.Namespaces {
+		namespace := namespace
+		funcs[namespace] = func() (string, error) {
+			return "", fmt.Errorf("namespace %s must be followed by a function name", namespace)
+		}
+	}
+
+	return funcs
+}
+
+// parseFuncs returns the functions that text/template must know to parse the templates.
+func (c TemplateConfig) parseFuncs() template.FuncMap {
+	funcs := c.namespaceFuncs()
+	for funcName, fn := range c.Funcs {
+		funcs[funcName] = fn
+	}
+
+	return funcs
+}
+
+// namespaceResolver replaces the namespaced calls of a parse tree with calls to their registered name.
+type namespaceResolver struct {
+	config TemplateConfig
+	tree   *parse.Tree
+	// err is the first namespaced call that can't be resolved, or direct call to a registered name
+	err *namespaceError
+}
+
+// namespaceError is an invalid call to a namespaced function, at the given offset of the template source.
+type namespaceError struct {
+	offset  int
+	message string
+}
+
+func (e *namespaceError) Error() string {
+	return e.message
+}
+
+// resolveNamespacedFuncs replaces the namespaced calls of the parsed template (e.g. {{acme.formatIBAN .IBAN}})
+// with calls to their registered name, or returns the parsing error of the first invalid one.
+func (c TemplateConfig) resolveNamespacedFuncs(tmpl *template.Template, source string, locations sourceMap) *TemplateError {
+	if len(c.Namespaces) == 0 {
+		return nil
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+
+		r := namespaceResolver{config: c, tree: t.Tree}
+		r.list(t.Tree.Root)
+		if r.err != nil {
+			return newTemplateError(TemplateErrorParse, tmpl.Name(), source, locations, c.delims(), r.err.offset, r.err.message, r.err)
+		}
+	}
+
+	return nil
+}
+
+func (r *namespaceResolver) list(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			r.pipe(n.Pipe)
+		case *parse.IfNode:
+			r.branch(&n.BranchNode)
+		case *parse.RangeNode:
+			r.branch(&n.BranchNode)
+		case *parse.WithNode:
+			r.branch(&n.BranchNode)
+		case *parse.TemplateNode:
+			r.pipe(n.Pipe)
+		}
+	}
+}
+
+func (r *namespaceResolver) branch(n *parse.BranchNode) {
+	r.pipe(n.Pipe)
+	r.list(n.List)
+	r.list(n.ElseList)
+}
+
+func (r *namespaceResolver) pipe(p *parse.PipeNode) {
+	if p == nil {
+		return
+	}
+
+	for _, cmd := range p.Cmds {
+		for i, arg := range cmd.Args {
+			cmd.Args[i] = r.node(arg)
+		}
+	}
+}
+
+// node returns the node with its namespaced calls resolved.
+func (r *namespaceResolver) node(node parse.Node) parse.Node {
+	switch n := node.(type) {
+	case *parse.PipeNode:
+		r.pipe(n)
+	case *parse.IdentifierNode:
+		r.identifier(n)
+	case *parse.ChainNode:
+		ident, ok := n.Node.(*parse.IdentifierNode)
+		if !ok || !r.isNamespace(ident.Ident) {
+			n.Node = r.node(n.Node)
+			return n
+		}
+
+		funcName := NamespacedFuncName(ident.Ident, n.Field[0])
+		if _, ok := r.config.Funcs[funcName]; !ok {
+			r.fail(n.Pos, fmt.Sprintf("function %q not defined", ident.Ident+"."+n.Field[0]))
+			return n
+		}
+
+		resolved := parse.NewIdentifier(funcName).SetTree(r.tree).SetPos(n.Pos)
+		if len(n.Field) == 1 {
+			return resolved
+		}
+
+		return &parse.ChainNode{NodeType: parse.NodeChain, Pos: n.Pos, Node: resolved, Field: n.Field[1:]}
+	}
+
+	return node
+}
+
+// identifier fails on the direct calls to the registered names of the namespaced functions,
+// and on the namespaces used without a function name.
+func (r *namespaceResolver) identifier(n *parse.IdentifierNode) {
+	if r.isNamespace(n.Ident) {
+		if _, ok := r.config.Funcs[n.Ident]; !ok {
+			r.fail(n.Pos, fmt.Sprintf("namespace %s must be followed by a function name", n.Ident))
+		}
+		return
+	}
+
+	for _, namespace := range r.config.Namespaces {
+		funcName := strings.TrimPrefix(n.Ident, NamespacedFuncName(namespace, ""))
+		if _, ok := r.config.Funcs[n.Ident]; ok && funcName != n.Ident {
+			r.fail(n.Pos, fmt.Sprintf("function %q not defined, call it as %s.%s", n.Ident, namespace, funcName))
+			return
+		}
+	}
+}
+
+func (r *namespaceResolver) isNamespace(name string) bool {
+	for _, namespace := range r.config.Namespaces {
+		if name == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *namespaceResolver) fail(pos parse.Pos, message string) {
+	if r.err == nil {
+		r.err = &namespaceError{offset: int(pos), message: message}
+	}
+}
@@ -0,0 +1,94 @@
+package docx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestNamespacedFuncs(t *testing.T) {
+	config := TemplateConfig{
+		Funcs: template.FuncMap{
+			NamespacedFuncName("acme", "upper"): strings.ToUpper,
+			"upper":                             func(s string) string { return "plain " + s },
+		},
+		Namespaces: []string{"acme"},
+	}
+	data := map[string]any{"acme": map[string]any{"upper": "field"}, "S": "iban"}
+
+	tests := []struct {
+		source, want string
+	}{
+		{source: `{{acme.upper .S}}`, want: "IBAN"},
+		{source: `{{.S | acme.upper}}`, want: "IBAN"},
+		{source: `{{upper (acme.upper .S)}}`, want: "plain IBAN"},
+		{source: `{{if true}}{{range $i := .}}{{end}}{{acme.upper "a"}}{{end}}`, want: "A"},
+		{source: `{{.acme.upper}} {{$.acme.upper}} {{"acme.upper"}}`, want: "field field acme.upper"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			tmpl, err := config.Parse("word/document.xml", tt.source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := ExecuteXmlTemplate(context.Background(), tmpl, data, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(output) != tt.want {
+				t.Errorf("got %q, want %q", output, tt.want)
+			}
+		})
+	}
+}
+
+func TestNamespacedFuncsErrors(t *testing.T) {
+	config := TemplateConfig{
+		Funcs:      template.FuncMap{NamespacedFuncName("acme", "upper"): strings.ToUpper},
+		Namespaces: []string{"acme"},
+	}
+
+	tests := []struct {
+		source, expression, message string
+	}{
+		{
+			source:     `<w:p><w:r><w:t>{{.S}} {{acme.lower .S}}</w:t></w:r></w:p>`,
+			expression: `{{acme.lower .S}}`,
+			message:    `function "acme.lower" not defined`,
+		},
+		{
+			source:     `<w:p><w:r><w:t>{{acme__upper .S}}</w:t></w:r></w:p>`,
+			expression: `{{acme__upper .S}}`,
+			message:    `function "acme__upper" not defined, call it as acme.upper`,
+		},
+		{
+			source:     `<w:p><w:r><w:t>{{acme}}</w:t></w:r></w:p>`,
+			expression: `{{acme}}`,
+			message:    `namespace acme must be followed by a function name`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := config.Parse("word/document.xml", tt.source)
+
+			var templateErr *TemplateError
+			if !errors.As(err, &templateErr) {
+				t.Fatalf("got error %v, want a *TemplateError", err)
+			}
+			if templateErr.Kind != TemplateErrorParse || templateErr.Expression != tt.expression || templateErr.Message != tt.message {
+				t.Errorf("got %s error at %q: %q, want parse error at %q: %q", templateErr.Kind, templateErr.Expression, templateErr.Message, tt.expression, tt.message)
+			}
+
+			_, errs, _ := config.ParseAll("word/document.xml", tt.source)
+			if len(errs) != 1 {
+				t.Errorf("got validation errors %v, want 1", errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package docx
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	goziputils "github.com/JJJJJJack/go-zip-utils"
+)
+
+const (
+	// FootnotesContentType and EndnotesContentType are the content types of word/footnotes.xml and word/endnotes.xml.
+	FootnotesContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.footnotes+xml"
+	EndnotesContentType  = "application/vnd.openxmlformats-officedocument.wordprocessingml.endnotes+xml"
+
+	DOCX_NOTE_REFERENCE_INJECT_F = `</w:t></w:r><w:r><w:rPr><w:rStyle w:val="%[1]sReference"/></w:rPr>` +
+		`<w:%[2]sReference w:id="[[%[3]s:%[4]s]]"/></w:r><w:r><w:t>`
+)
+
+var (
+	// notePattern matches the note references injected by footnote and endnote, before their placeholders are resolved
+	notePattern = `<w:r><w:rPr><w:rStyle w:val="(?:Footnote|Endnote)Reference"/></w:rPr>` +
+		`<w:(?:footnote|endnote)Reference w:id="\[\[(?:FOOTNOTE|ENDNOTE):[\w+/=]*\]\]"/></w:r>`
+	noteReferenceRe = regexp.MustCompile(`<w:r><w:rPr><w:rStyle w:val="(?:Footnote|Endnote)Reference"/></w:rPr>` +
+		`<w:(?:footnote|endnote)Reference w:id="\[\[(FOOTNOTE|ENDNOTE):([\w+/=]*)\]\]"/></w:r>`)
+	noteIdRe = regexp.MustCompile(`<w:(?:footnote|endnote)\b[^>]*\bw:id="(-?\d+)"`)
+)
+
+// footnote inserts a reference to a new footnote with the given text, the optional styles
+// are the same of styledText, either as a list or as the following arguments.
+func footnote(text string, styles ...interface{}) (string, error) {
+	return noteReference("footnote", text, styles)
+}
+
+// endnote inserts a reference to a new endnote with the given text, see footnote.
+func endnote(text string, styles ...interface{}) (string, error) {
+	return noteReference("endnote", text, styles)
+}
+
+// noteReference returns the reference run to a note of the given kind ("footnote" or "endnote"),
+// whose placeholder holds the run of the note text.
+func noteReference(kind, text string, styles []interface{}) (string, error) {
+	stylesList := []interface{}{}
+	for _, style := range styles {
+		if list, ok := style.([]interface{}); ok {
+			stylesList = append(stylesList, list...)
+			continue
+		}
+		stylesList = append(stylesList, style)
+	}
+
+	stylesTags, err := formatStylesTags(stylesList, kind)
+	if err != nil {
+		return "", err
+	}
+
+	run := `<w:r><w:t xml:space="preserve">` + text + `</w:t></w:r>`
+	if stylesTags != "" {
+		run = `<w:r><w:rPr>` + stylesTags + `</w:rPr><w:t xml:space="preserve">` + text + `</w:t></w:r>`
+	}
+
+	style := strings.ToUpper(kind[:1]) + kind[1:]
+
+	return fmt.Sprintf(DOCX_NOTE_REFERENCE_INJECT_F, style, kind, strings.ToUpper(kind), base64.StdEncoding.EncodeToString([]byte(run))), nil
+}
+
+// notes holds the notes of word/footnotes.xml or word/endnotes.xml and the ones added while rendering.
+type notes struct {
+	// kind is "footnote" or "endnote"
+	kind string
+	// source is the content of the notes part, empty if the document has none
+	source string
+	maxId  int
+	added  []string
+}
+
+// parseNotes returns the notes of the given kind of the notes part source.
+func parseNotes(kind, source string) notes {
+	n := notes{
+		kind:   kind,
+		source: source,
+	}
+
+	for _, m := range noteIdRe.FindAllStringSubmatch(source, -1) {
+		if id, err := strconv.Atoi(m[1]); err == nil && id > n.maxId {
+			n.maxId = id
+		}
+	}
+
+	return n
+}
+
+// parseNotesFile returns the notes of the given kind of word/footnotes.xml or word/endnotes.xml, if present.
+func parseNotesFile(zm goziputils.ZipMap, kind string) (notes, error) {
+	notesFile := zm["word/"+kind+"s.xml"]
+	if notesFile == nil {
+		return parseNotes(kind, ""), nil
+	}
+
+	notesContent, err := goziputils.ReadZipFileContent(notesFile)
+	if err != nil {
+		return notes{}, fmt.Errorf("could not read zip file content: %w", err)
+	}
+
+	return parseNotes(kind, string(notesContent)), nil
+}
+
+// clone returns a copy of the notes that can be extended without affecting the original.
+func (n notes) clone() notes {
+	n.added = append([]string(nil), n.added...)
+
+	return n
+}
+
+// add adds a note with the given paragraph content and returns its id.
+func (n *notes) add(content string) int {
+	n.maxId++
+	n.added = append(n.added, fmt.Sprintf(`<w:%s w:id="%d"><w:p>%s</w:p></w:%s>`, n.kind, n.maxId, content, n.kind))
+
+	return n.maxId
+}
+
+// xml returns the content of the notes part along with the notes added while rendering,
+// and whether any was added. When the document has no notes part, a new one is created
+// with the separator notes Word requires.
+func (n notes) xml() ([]byte, bool) {
+	if len(n.added) == 0 {
+		return []byte(n.source), false
+	}
+
+	source := n.source
+	if source == "" {
+		separators := ""
+		for i, separator := range []string{"separator", "continuationSeparator"} {
+			separators += fmt.Sprintf(`<w:%[1]s w:type="%[2]s" w:id="%[3]d"><w:p><w:pPr><w:spacing w:after="0" w:line="240" w:lineRule="auto"/></w:pPr>`+
+				`<w:r><w:%[2]s/></w:r></w:p></w:%[1]s>`, n.kind, separator, i-1)
+		}
+
+		source = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+			`<w:` + n.kind + `s xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` + separators + `</w:` + n.kind + `s>`
+	}
+
+	end := strings.LastIndex(source, "</w:"+n.kind+"s>")
+	if end < 0 {
+		return []byte(n.source), false
+	}
+
+	return []byte(source[:end] + strings.Join(n.added, "") + source[end:]), true
+}
+
+// applyNotes replaces the [[FOOTNOTE:...]] and [[ENDNOTE:...]] placeholders with the ids of new notes
+// holding their text, the run split by a note reference keeps its properties after it. The notes
+// and their references use the footnote and endnote styles of styles.xml, if defined.
+func (d *DocumentMeta) applyNotes(name, srcXML string) (string, error) {
+	if !noteReferenceRe.MatchString(srcXML) {
+		return srcXML, nil
+	}
+
+	if name != "word/document.xml" {
+		return srcXML, fmt.Errorf("footnotes and endnotes can only be referenced from the document")
+	}
+
+	srcXML = keepSplitRunProps(srcXML, notePattern)
+	srcXML = removeEmptyRunsAround(srcXML, notePattern)
+
+	var err error
+	srcXML = noteReferenceRe.ReplaceAllStringFunc(srcXML, func(match string) string {
+		m := noteReferenceRe.FindStringSubmatch(match)
+		run, decodeErr := base64.StdEncoding.DecodeString(m[2])
+		if decodeErr != nil {
+			err = fmt.Errorf("unable to decode note text: %w", decodeErr)
+			return match
+		}
+
+		n := &d.footnotes
+		if m[1] == "ENDNOTE" {
+			n = &d.endnotes
+		}
+		style := strings.ToUpper(n.kind[:1]) + n.kind[1:]
+
+		referenceProps := `<w:vertAlign w:val="superscript"/>`
+		if d.HasCharacterStyle(style + "Reference") {
+			referenceProps = `<w:rStyle w:val="` + style + `Reference"/>`
+		}
+
+		paragraphProps := `<w:spacing w:after="0" w:line="240" w:lineRule="auto"/>`
+		if d.HasParagraphStyle(style + "Text") {
+			paragraphProps = `<w:pStyle w:val="` + style + `Text"/>`
+		}
+
+		id := n.add(`<w:pPr>` + paragraphProps + `</w:pPr>` +
+			`<w:r><w:rPr>` + referenceProps + `</w:rPr><w:` + n.kind + `Ref/></w:r>` +
+			`<w:r><w:t xml:space="preserve"> </w:t></w:r>` + string(run))
+
+		return fmt.Sprintf(`<w:r><w:rPr>%s</w:rPr><w:%sReference w:id="%d"/></w:r>`, referenceProps, n.kind, id)
+	})
+	if err != nil {
+		return srcXML, err
+	}
+
+	return srcXML, nil
+}
@@ -0,0 +1,106 @@
+package docx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testEndnotes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<w:endnotes xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:endnote w:type="separator" w:id="-1"><w:p><w:r><w:separator/></w:r></w:p></w:endnote>` +
+	`<w:endnote w:type="continuationSeparator" w:id="0"><w:p><w:r><w:continuationSeparator/></w:r></w:p></w:endnote>` +
+	`<w:endnote w:id="3"><w:p><w:r><w:t>existing</w:t></w:r></w:p></w:endnote>` +
+	`</w:endnotes>`
+
+func TestNotes(t *testing.T) {
+	data := map[string]any{"Source": "Some source"}
+
+	tests := []struct {
+		name       string
+		body       string
+		parts      map[string]string
+		part       string
+		paragraphs []string
+		contains   []string
+		// notes are the texts of the paragraphs of the generated part
+		notes        []string
+		partContains []string
+	}{
+		{
+			name:       "footnote creating the footnotes part",
+			body:       p(`Claim{{footnote .Source}}.`),
+			part:       "word/footnotes.xml",
+			paragraphs: []string{"Claim."},
+			contains:   []string{`<w:r><w:rPr><w:vertAlign w:val="superscript"/></w:rPr><w:footnoteReference w:id="1"/></w:r>`},
+			notes:      []string{"", "", " Some source"},
+		},
+		{
+			name:       "styled footnotes",
+			body:       p(`A{{footnote "one" "b"}} B{{footnote "two" (list "i")}}`),
+			part:       "word/footnotes.xml",
+			paragraphs: []string{"A B"},
+			contains:   []string{`<w:footnoteReference w:id="1"/>`, `<w:footnoteReference w:id="2"/>`},
+			notes:      []string{"", "", " one", " two"},
+			partContains: []string{
+				`<w:rPr><w:b /><w:bCs /></w:rPr><w:t xml:space="preserve">one</w:t>`,
+				`<w:rPr><w:i /><w:iCs /></w:rPr><w:t xml:space="preserve">two</w:t>`,
+			},
+		},
+		{
+			name:       "endnote added to the endnotes part",
+			body:       p(`Claim{{endnote "more"}}`),
+			parts:      map[string]string{"word/endnotes.xml": testEndnotes},
+			part:       "word/endnotes.xml",
+			paragraphs: []string{"Claim"},
+			contains:   []string{`<w:endnoteReference w:id="4"/>`},
+			notes:      []string{"", "", "existing", " more"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDocumentMeta(t, tt.body, tt.parts)
+			output := renderTestPart(t, d, TemplateConfig{}, "word/document.xml", testDocument(tt.body), data)
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+
+			for _, s := range tt.contains {
+				if !strings.Contains(output, s) {
+					t.Errorf("output doesn't contain %s\n%s", s, output)
+				}
+			}
+
+			part := generatedPart(t, d, tt.part)
+			if !part.Changed {
+				t.Fatalf("%s not changed", tt.part)
+			}
+
+			if got := paragraphsTexts(string(part.Content)); !reflect.DeepEqual(got, tt.notes) {
+				t.Errorf("got notes %q, want %q\n%s", got, tt.notes, part.Content)
+			}
+
+			for _, s := range tt.partContains {
+				if !strings.Contains(string(part.Content), s) {
+					t.Errorf("%s doesn't contain %s\n%s", tt.part, s, part.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestNotesOutsideOfTheDocument(t *testing.T) {
+	d := newTestDocumentMeta(t, "", nil)
+
+	reference, err := footnote("note")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = d.applyNotes("word/header1.xml", p("text"+reference))
+	if err == nil || !strings.Contains(err.Error(), "can only be referenced from the document") {
+		t.Errorf("got error %v, want a footnote outside of the document error", err)
+	}
+}
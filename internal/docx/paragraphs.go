@@ -0,0 +1,303 @@
+package docx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// controlParagraphTags are the tags allowed in a control paragraph, outside of the
+// paragraph and run properties: the ones without any visible content.
+var controlParagraphTags = map[string]bool{
+	"w:p":                     true,
+	"w:r":                     true,
+	"w:t":                     true,
+	"w:proofErr":              true,
+	"w:lastRenderedPageBreak": true,
+}
+
+var sectPrRe = regexp.MustCompile(`(?s)<w:sectPr\b.*?</w:sectPr>|<w:sectPr\b[^>]*/>`)
+
+// removeControlParagraphs replaces the paragraphs containing only actions without output
+// (e.g. "{{range .Items}}", "{{end}}" or "{{/* comment */}}") with the actions themselves,
+// so that the blocks laid out on their own lines don't leave blank lines in the output.
+// The paragraphs with any other content (a bookmark, a field, a drawing, a section break...) are kept,
+// and so are the ones whose block actions are paired with an action of a kept paragraph
+// (e.g. "{{range .Items}}" alone in a paragraph but "{{end}}" at the end of a text), since
+// the block would otherwise repeat or skip the opening tags of the kept paragraph without their closing ones.
+func removeControlParagraphs(tokens []xmlToken, d Delims) []xmlToken {
+	// the paragraphs to replace, from their first to their last token, and their actions
+	removed := map[int]int{}
+	actions := map[int]string{}
+	// the paragraph containing each run text, -1 outside of the paragraphs
+	owners := map[int]int{}
+	opened := []int{}
+	for i, token := range tokens {
+		switch {
+		case token.isOpening() && token.qualifiedName() == "w:p":
+			opened = append(opened, i)
+		case token.isClosing() && token.qualifiedName() == "w:p" && len(opened) > 0:
+			start := opened[len(opened)-1]
+			opened = opened[:len(opened)-1]
+
+			paragraphActions, ok := controlParagraphActions(tokens[start:i+1], d)
+			// a cell must end with a paragraph, which is kept for the row and column loops
+			// expanded around the cell after the paragraphs removal
+			if ok && isTableLoopAction(paragraphActions, d) && i+1 < len(tokens) && tokens[i+1].isClosing() && tokens[i+1].qualifiedName() == "w:tc" {
+				continue
+			}
+			if ok {
+				removed[start] = i
+				actions[start] = paragraphActions
+			}
+		case isRunText(tokens, i):
+			owners[i] = -1
+			if len(opened) > 0 {
+				owners[i] = opened[len(opened)-1]
+			}
+		}
+	}
+
+	if len(removed) == 0 {
+		return tokens
+	}
+
+	keepPairedParagraphs(tokens, owners, removed, d)
+
+	if len(removed) == 0 {
+		return tokens
+	}
+
+	kept := make([]xmlToken, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if end, ok := removed[i]; ok {
+			kept = append(kept, tokens[i].patchedWith(actions[i]))
+			i = end
+			continue
+		}
+		kept = append(kept, tokens[i])
+	}
+
+	return kept
+}
+
+// keepPairedParagraphs deletes from removed the paragraphs containing an action of a block
+// (its opening action, "else", "break", "continue" or "end") having any action outside of the removed paragraphs.
+// owners maps the runs texts tokens to the paragraph containing them, -1 outside of the paragraphs.
+func keepPairedParagraphs(tokens []xmlToken, owners map[int]int, removed map[int]int, d Delims) {
+	type block struct {
+		loop       bool
+		paragraphs []int
+	}
+
+	keywordRe := d.actionKeywordRe()
+	blocks := []*block{}
+	opened := []*block{}
+	// unpaired are the paragraphs containing an action without its block (e.g. an "end" too many)
+	unpaired := []int{}
+	for i, token := range tokens {
+		owner, ok := owners[i]
+		if !ok {
+			continue
+		}
+
+		for _, action := range d.actionRe().FindAllString(token.value, -1) {
+			m := keywordRe.FindStringSubmatch(action)
+			if m == nil {
+				continue
+			}
+
+			switch m[1] {
+			case "if", "range", "with", "define", "block":
+				b := &block{loop: m[1] == "range", paragraphs: []int{owner}}
+				blocks = append(blocks, b)
+				opened = append(opened, b)
+			case "else", "end":
+				if len(opened) == 0 {
+					unpaired = append(unpaired, owner)
+					continue
+				}
+
+				b := opened[len(opened)-1]
+				b.paragraphs = append(b.paragraphs, owner)
+				if m[1] == "end" {
+					opened = opened[:len(opened)-1]
+				}
+			case "break", "continue":
+				loop := -1
+				for j := len(opened) - 1; j >= 0 && loop < 0; j-- {
+					if opened[j].loop {
+						loop = j
+					}
+				}
+				if loop < 0 {
+					unpaired = append(unpaired, owner)
+					continue
+				}
+
+				opened[loop].paragraphs = append(opened[loop].paragraphs, owner)
+			}
+		}
+	}
+
+	for _, owner := range unpaired {
+		delete(removed, owner)
+	}
+
+	// the blocks left open are paired with nothing
+	for _, b := range opened {
+		for _, owner := range b.paragraphs {
+			delete(removed, owner)
+		}
+	}
+
+	// keeping a paragraph may require to keep the ones of the other blocks it contains actions of
+	for changed := true; changed; {
+		changed = false
+		for _, b := range blocks {
+			keep := false
+			for _, owner := range b.paragraphs {
+				if _, ok := removed[owner]; !ok {
+					keep = true
+				}
+			}
+			if !keep {
+				continue
+			}
+
+			for _, owner := range b.paragraphs {
+				if _, ok := removed[owner]; ok {
+					delete(removed, owner)
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// controlParagraphActions returns the actions of the paragraph tokens, and whether
+// the paragraph contains only actions without output.
+func controlParagraphActions(paragraph []xmlToken, d Delims) (string, bool) {
+	text, ok := paragraphText(paragraph)
+	if !ok {
+		return "", false
+	}
+
+	actions := d.actionRe().FindAllString(text, -1)
+	if len(actions) == 0 || strings.TrimSpace(d.actionRe().ReplaceAllString(text, "")) != "" {
+		return "", false
+	}
+
+	for _, action := range actions {
+		if !isSilentAction(action, d) {
+			return "", false
+		}
+	}
+
+	return strings.Join(actions, ""), true
+}
+
+// paragraphText returns the runs text of the paragraph tokens, and whether the paragraph
+// has no other content than its text (e.g. a bookmark, a field, a drawing, a section break...).
+func paragraphText(paragraph []xmlToken) (string, bool) {
+	var text strings.Builder
+	propsDepth := 0
+	for i, token := range paragraph {
+		if !token.isTag {
+			if isRunText(paragraph, i) {
+				text.WriteString(token.value)
+			} else if strings.TrimSpace(token.value) != "" {
+				return "", false
+			}
+			continue
+		}
+
+		name := token.qualifiedName()
+		if name == "w:pPr" || name == "w:rPr" {
+			if token.isOpening() {
+				propsDepth++
+			} else if token.isClosing() {
+				propsDepth--
+			}
+			continue
+		}
+
+		if propsDepth > 0 && name != "w:sectPr" {
+			continue
+		}
+
+		if !controlParagraphTags[name] {
+			return "", false
+		}
+	}
+
+	return text.String(), true
+}
+
+// isSilentAction reports whether the action never produces any output: the control actions,
+// the row and column loops, the comments and the variables declarations or assignments (e.g. "{{$total := 0}}").
+func isSilentAction(action string, d Delims) bool {
+	if isControlAction(action, d) {
+		return true
+	}
+
+	m := d.actionKeywordRe().FindStringSubmatch(action)
+	if m != nil && (m[1] == "break" || m[1] == "continue" || m[1] == RowRangeKeyword || m[1] == ColRangeKeyword) {
+		return true
+	}
+
+	return d.silentActionRe().MatchString(action)
+}
+
+// isTableLoopAction reports whether the actions contain a row or column loop.
+func isTableLoopAction(actions string, d Delims) bool {
+	for _, action := range d.actionRe().FindAllString(actions, -1) {
+		if m := d.actionKeywordRe().FindStringSubmatch(action); m != nil && (m[1] == RowRangeKeyword || m[1] == ColRangeKeyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitParagraph returns the XML of the parts of the paragraph, from its pStart-th to its pEnd-th token,
+// before and after the text [from, to) of its k-th token. The elements open at the text (e.g. the run)
+// are closed at the end of the part before and opened again, along with their properties, in the part after.
+// The section break ending the paragraph, if any, is only kept in the part after.
+func splitParagraph(tokens []xmlToken, pStart, pEnd, k, from, to int) (string, string) {
+	opened := []int{}
+	for j := pStart; j < k; j++ {
+		switch {
+		case tokens[j].isOpening():
+			opened = append(opened, j)
+		case tokens[j].isClosing() && len(opened) > 0:
+			opened = opened[:len(opened)-1]
+		}
+	}
+
+	var closing, reopening strings.Builder
+	for j := len(opened) - 1; j >= 0; j-- {
+		closing.WriteString("</" + tokens[opened[j]].qualifiedName() + ">")
+	}
+	for _, j := range opened {
+		reopening.WriteString(tokens[j].value)
+		// copy the paragraph and run properties
+		if props := j + 1; props < len(tokens) && (tokens[props].qualifiedName() == "w:pPr" || tokens[props].qualifiedName() == "w:rPr") {
+			if propsEnd := elementEnd(tokens, props); propsEnd >= 0 {
+				reopening.WriteString(joinTokens(tokens[props : propsEnd+1]))
+			}
+		}
+	}
+
+	text := tokens[k].value
+	before := sectPrRe.ReplaceAllLiteralString(joinTokens(tokens[pStart:k]), "") + text[:from] + closing.String()
+	after := reopening.String() + text[to:] + joinTokens(tokens[k+1:pEnd+1])
+
+	return before, after
+}
+
+// isBlankParagraph reports whether the paragraph XML has no content other than blank runs texts.
+func isBlankParagraph(paragraphXml string) bool {
+	text, ok := paragraphText(tokenizeXml(paragraphXml))
+
+	return ok && strings.TrimSpace(text) == ""
+}
@@ -0,0 +1,57 @@
+package docx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRemoveControlParagraphs(t *testing.T) {
+	data := map[string]any{"L": []string{"a", "b"}, "X": true}
+
+	tests := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{
+			name: "balanced block",
+			body: p("{{range .L}}") + p("- {{.}}") + p("{{end}}"),
+			want: []string{"- a", "- b"},
+		},
+		{
+			name: "end in a text paragraph",
+			body: p("{{range .L}}") + p("- {{.}}{{end}}"),
+			want: []string{"", "- a", "- b"},
+		},
+		{
+			name: "range in a text paragraph",
+			body: p("Items: {{range .L}}") + p("{{.}}") + p("{{end}}"),
+			want: []string{"Items: ", "a", "", "b", ""},
+		},
+		{
+			name: "nested blocks",
+			body: p("{{range .L}}") + p("{{if $.X}}") + p("{{.}}") + p("{{else}}") + p("none") + p("{{end}}") + p("{{end}}"),
+			want: []string{"a", "b"},
+		},
+		{
+			name: "nested block paired with a text paragraph",
+			body: p("{{range .L}}") + p("{{if $.X}}") + p("{{.}}{{end}}") + p("{{end}}"),
+			want: []string{"", "a", "", "b"},
+		},
+		{
+			name: "comments and variables",
+			body: p("{{/* comment */}}") + p("{{$n := 1}}") + p("n = {{$n}}"),
+			want: []string{"n = 1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := renderTestDocument(t, tt.body, data)
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.want, output)
+			}
+		})
+	}
+}
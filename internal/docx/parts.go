@@ -0,0 +1,86 @@
+package docx
+
+import "path"
+
+// GeneratedPart is a part of the docx file extended while rendering (e.g. word/numbering.xml
+// with the definitions of the generated lists), which is created when the template has none.
+type GeneratedPart struct {
+	// Name is the name of the part file, e.g. "word/numbering.xml"
+	Name        string
+	ContentType string
+	// Content is the content of the part, Changed reports whether it differs from the template one
+	Content []byte
+	Changed bool
+	// relationshipType is the type of the relationship of the document to the part
+	relationshipType string
+}
+
+// GeneratedPartNames are the names of the parts that can be extended while rendering.
+var GeneratedPartNames = []string{
+	"word/numbering.xml",
+	"word/footnotes.xml",
+	"word/endnotes.xml",
+	"word/comments.xml",
+	"word/commentsExtended.xml",
+	"word/settings.xml",
+}
+
+// GeneratedParts returns the parts extended while rendering, in the order of GeneratedPartNames.
+func (d *DocumentMeta) GeneratedParts() []GeneratedPart {
+	numberingContent, numberingChanged := d.numberingXml()
+	footnotesContent, footnotesChanged := d.footnotes.xml()
+	endnotesContent, endnotesChanged := d.endnotes.xml()
+	commentsContent, commentsChanged := d.comments.xml()
+	commentsExtendedContent, commentsExtendedChanged := d.comments.extendedXml()
+	settingsContent, settingsChanged := d.settingsXml()
+
+	return []GeneratedPart{
+		{
+			Name:             "word/numbering.xml",
+			ContentType:      NumberingContentType,
+			Content:          numberingContent,
+			Changed:          numberingChanged,
+			relationshipType: numberingRelationship,
+		},
+		{
+			Name:             "word/footnotes.xml",
+			ContentType:      FootnotesContentType,
+			Content:          footnotesContent,
+			Changed:          footnotesChanged,
+			relationshipType: footnotesRelationship,
+		},
+		{
+			Name:             "word/endnotes.xml",
+			ContentType:      EndnotesContentType,
+			Content:          endnotesContent,
+			Changed:          endnotesChanged,
+			relationshipType: endnotesRelationship,
+		},
+		{
+			Name:             "word/comments.xml",
+			ContentType:      CommentsContentType,
+			Content:          commentsContent,
+			Changed:          commentsChanged,
+			relationshipType: commentsRelationship,
+		},
+		{
+			Name:             "word/commentsExtended.xml",
+			ContentType:      CommentsExtendedContentType,
+			Content:          commentsExtendedContent,
+			Changed:          commentsExtendedChanged,
+			relationshipType: commentsExtendedRelationship,
+		},
+		{
+			Name:             "word/settings.xml",
+			ContentType:      SettingsContentType,
+			Content:          settingsContent,
+			Changed:          settingsChanged,
+			relationshipType: settingsRelationship,
+		},
+	}
+}
+
+// AddGeneratedPart adds the relationship to a generated part, when it is created while rendering.
+func (r *Relationship) AddGeneratedPart(part GeneratedPart, id string) {
+	r.addRelationship(part.relationshipType, path.Base(part.Name), id)
+}
@@ -8,8 +8,8 @@ import (
 
 // removeEmptyTableRows removes empty table rows from the provided XML string.
 func removeEmptyTableRows(srcXML string) string {
-	trRe := regexp.MustCompile(`(?s)<w:tr\b[^>]*>.*?</w:tr>`) // match a table row
-	tRe := regexp.MustCompile(`(?is)<w:t\b[^>]*>(.*?)</w:t>`) // capture text content
+	trRe := regexp.MustCompile(`(?s)<w:tr\b[^>]*>.*?</w:tr>`)                         // match a table row
+	tRe := regexp.MustCompile(`(?is)<w:(?:t|delText)\b[^>]*>(.*?)</w:(?:t|delText)>`) // capture text content, deleted text included
 	visRe := regexp.MustCompile(`(?is)<w:drawing\b|<w:pict\b|<mc:AlternateContent\b|<v:shape\b|<wps:spPr\b`)
 
 	isRowEmpty := func(row string) bool {
@@ -38,6 +38,29 @@ func removeEmptyTableRows(srcXML string) string {
 	})
 }
 
+// removeEmptyTables removes the tables left without rows (e.g. by a row loop over an empty slice),
+// which Word can't open.
+func removeEmptyTables(srcXML string) string {
+	emptyTableRe := regexp.MustCompile(`(?s)<w:tbl>(?:<w:tblPr>.*?</w:tblPr>|<w:tblPr/>)?(?:<w:tblGrid>.*?</w:tblGrid>|<w:tblGrid/>)?</w:tbl>`)
+
+	return emptyTableRe.ReplaceAllString(srcXML, "")
+}
+
+// ensureContainersParagraph adds an empty paragraph to the table cells not ending with one and to the
+// other containers left without content (e.g. by a false condition whose control paragraphs were removed),
+// since Word requires them to contain at least one paragraph.
+func ensureContainersParagraph(srcXML string) string {
+	cellRe := regexp.MustCompile(`(<w:tc>|<w:tc\s[^>]*>|</w:tcPr>|</w:tbl>)(</w:tc>)`)
+	srcXML = cellRe.ReplaceAllString(srcXML, `${1}<w:p/>${2}`)
+
+	for _, container := range []string{"hdr", "ftr", "txbxContent", "footnote", "endnote", "comment"} {
+		emptyRe := regexp.MustCompile(`(<w:` + container + `(?:\s[^>]*)?>)(</w:` + container + `>)`)
+		srcXML = emptyRe.ReplaceAllString(srcXML, `${1}<w:p/>${2}`)
+	}
+
+	return srcXML
+}
+
 // ensureXmlSpacePreserve ensures all <w:t> elements with leading/trailing
 // whitespace have the xml:space="preserve" attribute.
 // This is required by Word to preserve spaces; without it, Word collapses whitespace.
@@ -73,9 +96,9 @@ func ensureXmlSpacePreserve(srcXML string) string {
 func propagateRunPropsAfterBreak(srcXML string) string {
 	// <w:rPr> children in OOXML are always self-closing tags (e.g. <w:sz/>), so we
 	// match only those to avoid accidentally spanning into a sibling <w:rPr> block.
-	re := regexp.MustCompile(`(<w:rPr>[^<]*(?:<[^>]+/>[^<]*)*</w:rPr>)(<w:t[^>]*>[^<]*</w:t></w:r></w:p><w:p><w:r>)<w:t`)
+	re := regexp.MustCompile(`(<w:rPr>[^<]*(?:<[^>]+/>[^<]*)*</w:rPr>)(<w:t[^>]*>[^<]*</w:t></w:r></w:p><w:p><w:r>)<w:t>`)
 	for {
-		next := re.ReplaceAllString(srcXML, `${1}${2}${1}<w:t`)
+		next := re.ReplaceAllString(srcXML, `${1}${2}${1}<w:t>`)
 		if next == srcXML {
 			break
 		}
@@ -85,38 +108,167 @@ func propagateRunPropsAfterBreak(srcXML string) string {
 }
 
 // flattenNestedTextRuns fixes cases where a template function that returns
-// `<w:rPr>..</w:rPr><w:t>..</w:t>` got injected inside an existing `<w:t>`.
+// `<w:rPr>..</w:rPr><w:t>..</w:t>` (e.g. styledText) got injected inside an existing `<w:t>`.
 // That produces invalid nesting like:
 //
-//	<w:t> <w:rPr>..</w:rPr><w:t>text</w:t> </w:t>
-//
-// Replace it with:
+//	<w:r><w:rPr><w:i/></w:rPr><w:t>Hello <w:rPr><w:b/></w:rPr><w:t>Bob</w:t> world</w:t></w:r>
 //
-//	<w:rPr>..</w:rPr><w:t>text</w:t>
+// The run is split so that the injected text gets its own run, with the properties of the
+// split run overridden by the injected ones:
 //
-// Preserves the xml:space="preserve" attribute if it exists in either tag.
+//	<w:r><w:rPr><w:i/></w:rPr><w:t>Hello </w:t></w:r><w:r><w:rPr><w:i/><w:b/></w:rPr><w:t>Bob</w:t></w:r>
+//	<w:r><w:rPr><w:i/></w:rPr><w:t> world</w:t></w:r>
 func flattenNestedTextRuns(srcXML string) string {
-	nestedRe := regexp.MustCompile(`(?is)<w:t\b([^>]*)>\s*(<w:rPr>[\s\S]*?</w:rPr>)\s*<w:t\b([^>]*)>([\s\S]*?)</w:t>\s*</w:t>`)
-	xmlSpaceRe := regexp.MustCompile(`xml:space="preserve"`)
+	if !strings.Contains(srcXML, "<w:rPr>") {
+		return srcXML
+	}
+
+	tokens := tokenizeXml(srcXML)
+	out := make([]byte, 0, len(srcXML))
 
-	for nestedRe.MatchString(srcXML) {
-		srcXML = nestedRe.ReplaceAllStringFunc(srcXML, func(match string) string {
-			submatches := nestedRe.FindStringSubmatch(match)
-			if len(submatches) < 5 {
-				return match
+	// runs are the runs containing the current token, with their offset in the output and, when they
+	// were split, the offset of their last part, dropped if nothing follows the split
+	type run struct {
+		open, props   string
+		start, reopen int
+	}
+	runs := []run{}
+
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		switch name := token.qualifiedName(); {
+		case name == "w:r" && token.isOpening():
+			r := run{open: token.value, start: len(out), reopen: -1}
+			if i+1 < len(tokens) && tokens[i+1].qualifiedName() == "w:rPr" {
+				if end := closingTokenIndex(tokens, i+1); end > i {
+					for _, t := range tokens[i+1 : end+1] {
+						r.props += t.value
+					}
+				}
+			}
+			runs = append(runs, r)
+		case name == "w:r" && token.isClosing() && len(runs) > 0:
+			r := runs[len(runs)-1]
+			runs = runs[:len(runs)-1]
+			if r.reopen == len(out) {
+				out = out[:r.reopen-len(r.open+r.props)]
+				continue
 			}
-			outerAttrs := submatches[1]
-			rPr := submatches[2]
-			innerAttrs := submatches[3]
-			text := submatches[4]
-
-			// If either tag had xml:space="preserve", preserve it in the flattened output
-			if xmlSpaceRe.MatchString(outerAttrs) || xmlSpaceRe.MatchString(innerAttrs) {
-				return fmt.Sprintf(`%s<w:t xml:space="preserve">%s</w:t>`, rPr, text)
+		case name == "w:t" && token.isOpening() && len(runs) > 0:
+			segments, end := nestedTextSegments(tokens, i)
+			if segments == nil {
+				break
 			}
-			return fmt.Sprintf(`%s<w:t>%s</w:t>`, rPr, text)
-		})
+
+			r := &runs[len(runs)-1]
+			for _, segment := range segments {
+				switch {
+				case segment.props != "":
+					if string(out[r.start:]) == r.open+r.props {
+						out = out[:r.start]
+					} else {
+						out = append(out, "</w:r>"...)
+					}
+					out = append(out, r.open+mergeRunProps(r.props, segment.props)+segment.textTag+segment.text+"</w:t></w:r>"...)
+					r.start = len(out)
+					out = append(out, r.open+r.props...)
+					r.reopen = len(out)
+				case segment.text != "":
+					out = append(out, token.value+segment.text+"</w:t>"...)
+				}
+			}
+
+			i = end
+			continue
+		}
+
+		out = append(out, token.value...)
 	}
 
-	return srcXML
+	return string(out)
+}
+
+// nestedTextSegment is a text of a run, with the properties and the text tag injected with it if any.
+type nestedTextSegment struct {
+	props, textTag, text string
+}
+
+// nestedTextSegments returns the texts of the <w:t> opened by the i-th token, when it contains
+// texts with their own properties, and the index of the token closing it.
+func nestedTextSegments(tokens []xmlToken, i int) ([]nestedTextSegment, int) {
+	segments := []nestedTextSegment{{}}
+	nested := false
+
+	for j := i + 1; j < len(tokens); j++ {
+		token := tokens[j]
+		switch {
+		case !token.isTag:
+			segments[len(segments)-1].text += token.value
+		case token.qualifiedName() == "w:t" && token.isClosing():
+			if !nested {
+				return nil, i
+			}
+			return segments, j
+		case token.qualifiedName() == "w:rPr" && token.isOpening():
+			// <w:rPr>...</w:rPr><w:t>text</w:t>
+			end := closingTokenIndex(tokens, j)
+			if end < 0 || end+1 >= len(tokens) || tokens[end+1].qualifiedName() != "w:t" || !tokens[end+1].isOpening() {
+				return nil, i
+			}
+
+			segment := nestedTextSegment{textTag: tokens[end+1].value}
+			for _, t := range tokens[j+1 : end] {
+				segment.props += t.value
+			}
+
+			j = end + 2
+			if j < len(tokens) && !tokens[j].isTag {
+				segment.text = tokens[j].value
+				j++
+			}
+			if j >= len(tokens) || tokens[j].qualifiedName() != "w:t" || !tokens[j].isClosing() {
+				return nil, i
+			}
+
+			segments = append(segments, segment, nestedTextSegment{})
+			nested = true
+		default:
+			return nil, i
+		}
+	}
+
+	return nil, i
+}
+
+// closingTokenIndex returns the index of the token closing the element opened by the i-th token, -1 if none.
+func closingTokenIndex(tokens []xmlToken, i int) int {
+	depth := 0
+	for j := i; j < len(tokens); j++ {
+		if tokens[j].isOpening() {
+			depth++
+		} else if tokens[j].isClosing() {
+			depth--
+		}
+		if depth == 0 {
+			return j
+		}
+	}
+
+	return -1
+}
+
+// runPropChildRe matches the self-closing children of the run properties.
+var runPropChildRe = regexp.MustCompile(`<([\w:]+)\b[^>]*/>`)
+
+// mergeRunProps returns the run properties overridden by the given properties children.
+func mergeRunProps(props, overrides string) string {
+	children := ""
+	if props != "" {
+		children = props[strings.IndexByte(props, '>')+1 : strings.LastIndex(props, "</w:rPr>")]
+		for _, m := range runPropChildRe.FindAllStringSubmatch(overrides, -1) {
+			children = regexp.MustCompile(`<`+regexp.QuoteMeta(m[1])+`\b[^>]*/>`).ReplaceAllString(children, "")
+		}
+	}
+
+	return "<w:rPr>" + children + overrides + "</w:rPr>"
 }
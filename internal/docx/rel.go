@@ -2,16 +2,25 @@ package docx
 
 import (
 	"encoding/xml"
+	"path"
 )
 
 const (
-	imageRelationship = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image"
+	imageRelationship            = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/image"
+	numberingRelationship        = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering"
+	hyperlinkRelationship        = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/hyperlink"
+	footnotesRelationship        = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/footnotes"
+	endnotesRelationship         = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/endnotes"
+	commentsRelationship         = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/comments"
+	commentsExtendedRelationship = "http://schemas.microsoft.com/office/2011/relationships/commentsExtended"
+	settingsRelationship         = "http://schemas.openxmlformats.org/officeDocument/2006/relationships/settings"
 )
 
 type relationshipDetail struct {
-	Type   string `xml:"Type,attr"`
-	Target string `xml:"Target,attr"`
-	Id     string `xml:"Id,attr"`
+	Type       string `xml:"Type,attr"`
+	Target     string `xml:"Target,attr"`
+	TargetMode string `xml:"TargetMode,attr,omitempty"`
+	Id         string `xml:"Id,attr"`
 }
 
 type Relationship struct {
@@ -28,10 +37,26 @@ func (r *Relationship) AddMediaToRels(media []MediaRel) {
 				m.Source,
 				m.RefID,
 			)
+		case HyperlinkMediaType:
+			r.Relationships = append(r.Relationships, relationshipDetail{
+				Type:       hyperlinkRelationship,
+				Target:     m.Source,
+				TargetMode: m.TargetMode,
+				Id:         m.RefID,
+			})
 		}
 	}
 }
 
+// Clone returns a copy of the relationships that can be extended without
+// affecting the original.
+func (r *Relationship) Clone() *Relationship {
+	return &Relationship{
+		XMLName:       r.XMLName,
+		Relationships: append([]relationshipDetail(nil), r.Relationships...),
+	}
+}
+
 func (r *Relationship) addRelationship(relType, target, id string) {
 	newRel := relationshipDetail{
 		Type:   relType,
@@ -57,6 +82,11 @@ func (r *Relationship) ToXml() ([]byte, error) {
 	return xmlBytes, nil
 }
 
+// RelsFilename returns the name of the rels file of the part, e.g. "word/_rels/header1.xml.rels".
+func RelsFilename(part string) string {
+	return path.Join(path.Dir(part), "_rels", path.Base(part)+".rels")
+}
+
 func ParseRelationship(data []byte) (*Relationship, error) {
 	var relationships Relationship
 	err := xml.Unmarshal(data, &relationships)
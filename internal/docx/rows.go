@@ -0,0 +1,53 @@
+package docx
+
+import "strings"
+
+// RowRangeKeyword is the keyword of the row loops, e.g. "{{rowRange .Items}}"
+// placed in any cell of the table row to repeat once per item.
+const RowRangeKeyword = "rowRange"
+
+// expandRowRanges replaces each row loop found in a table row with a range action
+// wrapping the innermost enclosing <w:tr>, so the whole row is repeated with the item as dot.
+// The row loops outside of a table row are left untouched.
+func expandRowRanges(tokens []xmlToken, d Delims) {
+	type row struct {
+		start    int
+		ranges   []string
+		location sourceLocation
+	}
+
+	keywordRe := d.actionKeywordRe()
+	actionRe := d.actionRe()
+	rows := []*row{}
+	for i, token := range tokens {
+		switch {
+		case token.isOpening() && token.qualifiedName() == "w:tr":
+			rows = append(rows, &row{start: i})
+		case token.isClosing() && token.qualifiedName() == "w:tr" && len(rows) > 0:
+			r := rows[len(rows)-1]
+			rows = rows[:len(rows)-1]
+			if len(r.ranges) == 0 {
+				continue
+			}
+
+			// the range actions come from the cell of the first row loop
+			tokens[r.start] = tokens[r.start].patchedWith(strings.Join(r.ranges, "") + tokens[r.start].value)
+			tokens[r.start].location = r.location
+			tokens[i] = token.patchedWith(token.value + strings.Repeat(d.action("end"), len(r.ranges)))
+		case token.patched && !token.isTag && len(rows) > 0:
+			r := rows[len(rows)-1]
+			tokens[i].value = actionRe.ReplaceAllStringFunc(token.value, func(action string) string {
+				m := keywordRe.FindStringSubmatchIndex(action)
+				if m == nil || action[m[2]:m[3]] != RowRangeKeyword {
+					return action
+				}
+
+				if len(r.ranges) == 0 {
+					r.location = token.location
+				}
+				r.ranges = append(r.ranges, action[:m[2]]+"range"+action[m[3]:])
+				return ""
+			})
+		}
+	}
+}
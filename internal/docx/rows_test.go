@@ -0,0 +1,53 @@
+package docx
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRowRange(t *testing.T) {
+	data := map[string]any{
+		"Title": "Order",
+		"Items": []map[string]any{{"Name": "a", "Qty": 1}, {"Name": "b", "Qty": 2}},
+		"None":  []map[string]any{},
+	}
+
+	header := tr(tc(p("Name")), tc(p("Qty")))
+
+	tests := []struct {
+		name string
+		body string
+		want [][]string
+	}{
+		{
+			name: "loop paragraph",
+			body: tbl([]int{2000, 2000}, header, tr(tc(p("{{rowRange .Items}}"), p("{{.Name}}")), tc(p("{{.Qty}}")))),
+			want: [][]string{{"Name", "Qty"}, {"a", "1"}, {"b", "2"}},
+		},
+		{
+			name: "inline loop",
+			body: tbl([]int{2000, 2000}, header, tr(tc(p("{{.Name}}")), tc(p("{{rowRange .Items}}{{.Qty}}")))),
+			want: [][]string{{"Name", "Qty"}, {"a", "1"}, {"b", "2"}},
+		},
+		{
+			name: "root values in the loop",
+			body: tbl([]int{2000, 2000}, tr(tc(p("{{rowRange .Items}}{{$.Title}}")), tc(p("{{.Name}}")))),
+			want: [][]string{{"Order", "a"}, {"Order", "b"}},
+		},
+		{
+			name: "empty loop",
+			body: tbl([]int{2000, 2000}, header, tr(tc(p("{{rowRange .None}}"), p("{{.Name}}")), tc(p("{{.Qty}}")))),
+			want: [][]string{{"Name", "Qty"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := renderTestDocument(t, tt.body, data)
+
+			if got := rowsTexts(output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got rows %q, want %q\n%s", got, tt.want, output)
+			}
+		})
+	}
+}
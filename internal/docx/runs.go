@@ -0,0 +1,274 @@
+package docx
+
+import (
+	"regexp"
+	"strings"
+)
+
+// xmlToken is a tag (including comments and processing instructions) or the text
+// between two tags of an XML source.
+type xmlToken struct {
+	value string
+	isTag bool
+	// patched reports whether the template actions of the token were already patched
+	patched bool
+	// location is where the token comes from in the original XML source, see locateTokens
+	location sourceLocation
+}
+
+// patchedWith returns the text token replacing the token with the given patched value,
+// from the same location of the original XML source.
+func (t xmlToken) patchedWith(value string) xmlToken {
+	t.value = value
+	t.isTag = false
+	t.patched = true
+
+	return t
+}
+
+var xmlTagNameRe = regexp.MustCompile(`^</?([\w\-.:]+)`)
+
+// qualifiedName returns the name of the tag (e.g. "w:t" for <w:t xml:space="preserve">).
+func (t xmlToken) qualifiedName() string {
+	if !t.isTag {
+		return ""
+	}
+
+	m := xmlTagNameRe.FindStringSubmatch(t.value)
+	if m == nil {
+		return ""
+	}
+
+	return m[1]
+}
+
+// name returns the local name of the tag (e.g. "t" for <w:t xml:space="preserve">).
+func (t xmlToken) name() string {
+	name := t.qualifiedName()
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+
+	return name
+}
+
+// isOpening reports whether the token is an opening tag, e.g. <w:t> but not <w:t/>.
+func (t xmlToken) isOpening() bool {
+	return t.isTag && !strings.HasPrefix(t.value, "</") && !strings.HasSuffix(t.value, "/>") &&
+		!strings.HasPrefix(t.value, "<?") && !strings.HasPrefix(t.value, "<!")
+}
+
+// isClosing reports whether the token is a closing tag, e.g. </w:t>.
+func (t xmlToken) isClosing() bool {
+	return t.isTag && strings.HasPrefix(t.value, "</")
+}
+
+// tokenizeXml splits the XML source into tags and texts, the concatenation of
+// the tokens values is the source itself.
+func tokenizeXml(srcXml string) []xmlToken {
+	tokens := []xmlToken{}
+	for len(srcXml) > 0 {
+		start := strings.IndexByte(srcXml, '<')
+		if start != 0 {
+			if start < 0 {
+				start = len(srcXml)
+			}
+			tokens = append(tokens, xmlToken{value: srcXml[:start]})
+			srcXml = srcXml[start:]
+			continue
+		}
+
+		end := -1
+		switch {
+		case strings.HasPrefix(srcXml, "<!--"):
+			if i := strings.Index(srcXml, "-->"); i >= 0 {
+				end = i + len("-->")
+			}
+		case strings.HasPrefix(srcXml, "<![CDATA["):
+			if i := strings.Index(srcXml, "]]>"); i >= 0 {
+				end = i + len("]]>")
+			}
+		default:
+			if i := strings.IndexByte(srcXml, '>'); i >= 0 {
+				end = i + 1
+			}
+		}
+
+		if end < 0 {
+			// unterminated tag, kept as text
+			tokens = append(tokens, xmlToken{value: srcXml})
+			break
+		}
+
+		tokens = append(tokens, xmlToken{value: srcXml[:end], isTag: true})
+		srcXml = srcXml[end:]
+	}
+
+	return tokens
+}
+
+// textTokenAt returns the index of the text token containing the byte offset of the XML source
+// of the tokens, along with the offset at which the token starts, or -1 if it is in a tag.
+func textTokenAt(tokens []xmlToken, offset int) (int, int) {
+	start := 0
+	for k, token := range tokens {
+		if start+len(token.value) > offset {
+			if token.isTag {
+				return -1, 0
+			}
+			return k, start
+		}
+		start += len(token.value)
+	}
+
+	return -1, 0
+}
+
+// isRunText reports whether the i-th token is the text of a run, i.e. the content
+// of a <w:t>, <a:t> or <t> element.
+func isRunText(tokens []xmlToken, i int) bool {
+	if tokens[i].isTag || i == 0 || i == len(tokens)-1 {
+		return false
+	}
+
+	opening, closing := tokens[i-1], tokens[i+1]
+
+	return opening.isOpening() && opening.name() == "t" && closing.isClosing() && closing.name() == "t"
+}
+
+// isParagraphEnd reports whether the token closes a paragraph (e.g. </w:p>, </a:p>)
+// or a shared string (</si>), which template actions never span.
+func isParagraphEnd(token xmlToken) bool {
+	if !token.isClosing() {
+		return false
+	}
+
+	switch token.name() {
+	case "p", "si", "is":
+		return true
+	}
+
+	return false
+}
+
+// mergeRunActions finds the template actions in the runs texts of each paragraph, even when Word
+// split them across several runs (e.g. because of a spell check <w:proofErr/>, a <w:bookmarkStart/>,
+// different rsid attributes, a hyperlink or a field result), and gathers each of them into the run
+// in which it starts, keeping its properties. The following runs texts are trimmed of the action's
+// remainder, everything outside of the actions is left untouched.
+// patchAction returns the template text replacing the XML text of an action.
+func mergeRunActions(tokens []xmlToken, actionRe *regexp.Regexp, patchAction func(string) string) {
+	paragraph := []int{}
+	for i := range tokens {
+		if isRunText(tokens, i) {
+			tokens[i].patched = true
+			paragraph = append(paragraph, i)
+		} else if isParagraphEnd(tokens[i]) {
+			mergeParagraphActions(tokens, paragraph, actionRe, patchAction)
+			paragraph = paragraph[:0]
+		}
+	}
+
+	mergeParagraphActions(tokens, paragraph, actionRe, patchAction)
+}
+
+// mergeParagraphActions gathers the actions found in the given runs texts tokens.
+func mergeParagraphActions(tokens []xmlToken, runTexts []int, actionRe *regexp.Regexp, patchAction func(string) string) {
+	if len(runTexts) == 0 {
+		return
+	}
+
+	var text strings.Builder
+	offsets := make([]int, len(runTexts)+1)
+	for k, i := range runTexts {
+		offsets[k] = text.Len()
+		text.WriteString(tokens[i].value)
+	}
+	offsets[len(runTexts)] = text.Len()
+
+	paragraphText := text.String()
+	matches := actionRe.FindAllStringIndex(paragraphText, -1)
+	if len(matches) == 0 {
+		return
+	}
+
+	outputs := make([]strings.Builder, len(runTexts))
+
+	// copyText copies the paragraph text in [from, to) to the runs texts owning it
+	copyText := func(from, to int) {
+		for k := range runTexts {
+			start, end := offsets[k], offsets[k+1]
+			if start < from {
+				start = from
+			}
+			if end > to {
+				end = to
+			}
+			if start < end {
+				outputs[k].WriteString(paragraphText[start:end])
+			}
+		}
+	}
+
+	// owner returns the index of the run text containing the paragraph text offset
+	owner := func(offset int) int {
+		for k := range runTexts {
+			if offset < offsets[k+1] {
+				return k
+			}
+		}
+		return len(runTexts) - 1
+	}
+
+	prev := 0
+	for _, m := range matches {
+		copyText(prev, m[0])
+		outputs[owner(m[0])].WriteString(patchAction(paragraphText[m[0]:m[1]]))
+		prev = m[1]
+	}
+	copyText(prev, len(paragraphText))
+
+	for k, i := range runTexts {
+		tokens[i].value = outputs[k].String()
+	}
+}
+
+// runPropsPattern matches the <w:rPr> of a run, whose children are always self-closing tags.
+const runPropsPattern = `<w:rPr>[^<]*(?:<[^>]+/>[^<]*)*</w:rPr>`
+
+// emptyTextRunPattern matches a run left without text, e.g. by a template function injecting
+// elements at the beginning or at the end of the run text.
+const emptyTextRunPattern = `<w:r>(?:` + runPropsPattern + `)?<w:t(?:\s[^>]*)?></w:t></w:r>`
+
+// keepSplitRunProps copies the properties of the runs split by the elements matching elementPattern
+// (e.g. the hyperlinks injected by the template functions, which close the run text and open a bare
+// <w:r><w:t> after them) to the bare runs inside and after the elements.
+func keepSplitRunProps(srcXML, elementPattern string) string {
+	splitRunRe := regexp.MustCompile(`(?s)(` + runPropsPattern + `)(<w:t[^>]*>[^<]*</w:t></w:r>)(` + elementPattern + `)<w:r><w:t`)
+
+	for {
+		next := splitRunRe.ReplaceAllStringFunc(srcXML, func(match string) string {
+			m := splitRunRe.FindStringSubmatch(match)
+			element := strings.ReplaceAll(m[3], "<w:r><w:t", "<w:r>"+m[1]+"<w:t")
+
+			return m[1] + m[2] + element + "<w:r>" + m[1] + "<w:t"
+		})
+		if next == srcXML {
+			return srcXML
+		}
+		srcXML = next
+	}
+}
+
+// removeEmptyRunsAround removes the runs left empty right before and after the elements matching elementPattern.
+func removeEmptyRunsAround(srcXML, elementPattern string) string {
+	emptyRunRe := regexp.MustCompile(emptyTextRunPattern + `(` + elementPattern + `)|(` + elementPattern + `)` + emptyTextRunPattern)
+
+	for {
+		next := emptyRunRe.ReplaceAllString(srcXML, "${1}${2}")
+		if next == srcXML {
+			return srcXML
+		}
+		srcXML = next
+	}
+}
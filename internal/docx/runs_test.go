@@ -0,0 +1,155 @@
+package docx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// run returns a run holding the given text.
+func run(text string) string {
+	return `<w:r><w:t>` + text + `</w:t></w:r>`
+}
+
+func TestTokenizeXml(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		values []string
+		tags   []bool
+	}{
+		{
+			name:   "tags and texts",
+			src:    `<w:t xml:space="preserve">a {{.B}}</w:t>`,
+			values: []string{`<w:t xml:space="preserve">`, `a {{.B}}`, `</w:t>`},
+			tags:   []bool{true, false, true},
+		},
+		{
+			name:   "comment and CDATA holding brackets",
+			src:    `<!-- <a> -->x<![CDATA[<b>]]>`,
+			values: []string{`<!-- <a> -->`, `x`, `<![CDATA[<b>]]>`},
+			tags:   []bool{true, false, true},
+		},
+		{
+			name:   "unterminated tag",
+			src:    `a<w:t`,
+			values: []string{`a`, `<w:t`},
+			tags:   []bool{false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := tokenizeXml(tt.src)
+
+			values, tags := []string{}, []bool{}
+			for _, token := range tokens {
+				values = append(values, token.value)
+				tags = append(tags, token.isTag)
+			}
+
+			if !reflect.DeepEqual(values, tt.values) || !reflect.DeepEqual(tags, tt.tags) {
+				t.Errorf("got tokens %q %v, want %q %v", values, tags, tt.values, tt.tags)
+			}
+		})
+	}
+}
+
+func TestMergeRunActions(t *testing.T) {
+	tests := []struct {
+		name   string
+		delims Delims
+		src    string
+		want   string
+	}{
+		{
+			name: "action split across runs",
+			src:  `<w:p>` + run(`Hello {{.Na`) + run(`me}}!`) + `</w:p>`,
+			want: `<w:p>` + run(`Hello [{{.Name}}]`) + run(`!`) + `</w:p>`,
+		},
+		{
+			name: "action split across three runs with properties",
+			src:  `<w:p><w:r><w:rPr><w:b/></w:rPr><w:t>{{</w:t></w:r>` + run(`.Na`) + run(`me}} x`) + `</w:p>`,
+			want: `<w:p><w:r><w:rPr><w:b/></w:rPr><w:t>[{{.Name}}]</w:t></w:r>` + run(``) + run(` x`) + `</w:p>`,
+		},
+		{
+			name: "spell check",
+			src:  `<w:p>` + run(`{{.Na`) + `<w:proofErr w:type="spellStart"/>` + run(`me}}`) + `<w:proofErr w:type="spellEnd"/></w:p>`,
+			want: `<w:p>` + run(`[{{.Name}}]`) + `<w:proofErr w:type="spellStart"/>` + run(``) + `<w:proofErr w:type="spellEnd"/></w:p>`,
+		},
+		{
+			name: "bookmark",
+			src:  `<w:p>` + run(`{{.`) + `<w:bookmarkStart w:id="0" w:name="b"/>` + run(`Name}}`) + `<w:bookmarkEnd w:id="0"/></w:p>`,
+			want: `<w:p>` + run(`[{{.Name}}]`) + `<w:bookmarkStart w:id="0" w:name="b"/>` + run(``) + `<w:bookmarkEnd w:id="0"/></w:p>`,
+		},
+		{
+			name: "hyperlink",
+			src:  `<w:p>` + run(`see {{.`) + `<w:hyperlink r:id="rId5">` + run(`Url}} here`) + `</w:hyperlink></w:p>`,
+			want: `<w:p>` + run(`see [{{.Url}}]`) + `<w:hyperlink r:id="rId5">` + run(` here`) + `</w:hyperlink></w:p>`,
+		},
+		{
+			name: "field result",
+			src: `<w:p><w:r><w:fldChar w:fldCharType="begin"/></w:r><w:r><w:instrText>MERGEFIELD {{x}}</w:instrText></w:r>` +
+				`<w:r><w:fldChar w:fldCharType="separate"/></w:r>` + run(`{{.Fi`) + run(`eld}}`) +
+				`<w:r><w:fldChar w:fldCharType="end"/></w:r></w:p>`,
+			want: `<w:p><w:r><w:fldChar w:fldCharType="begin"/></w:r><w:r><w:instrText>MERGEFIELD {{x}}</w:instrText></w:r>` +
+				`<w:r><w:fldChar w:fldCharType="separate"/></w:r>` + run(`[{{.Field}}]`) + run(``) +
+				`<w:r><w:fldChar w:fldCharType="end"/></w:r></w:p>`,
+		},
+		{
+			name: "several actions in a run",
+			src:  `<w:p>` + run(`{{.A}}, {{.B}} and {{.`) + run(`C}}.`) + `</w:p>`,
+			want: `<w:p>` + run(`[{{.A}}], [{{.B}}] and [{{.C}}]`) + run(`.`) + `</w:p>`,
+		},
+		{
+			name: "text without actions",
+			src:  `<w:p>` + run(`a { b }`) + run(`} c {{`) + `</w:p>`,
+			want: `<w:p>` + run(`a { b }`) + run(`} c {{`) + `</w:p>`,
+		},
+		{
+			name: "actions don't span paragraphs",
+			src:  `<w:p>` + run(`{{.A`) + `</w:p><w:p>` + run(`}}`) + `</w:p>`,
+			want: `<w:p>` + run(`{{.A`) + `</w:p><w:p>` + run(`}}`) + `</w:p>`,
+		},
+		{
+			name: "actions in attributes",
+			src:  `<w:p><wp:docPr id="1" descr="{{.Alt}}"/>` + run(`x`) + `</w:p>`,
+			want: `<w:p><wp:docPr id="1" descr="{{.Alt}}"/>` + run(`x`) + `</w:p>`,
+		},
+		{
+			name: "shared strings",
+			src:  `<si><r><t>{{.</t></r><r><t>A}}</t></r></si>`,
+			want: `<si><r><t>[{{.A}}]</t></r><r><t></t></r></si>`,
+		},
+		{
+			name:   "custom delimiters",
+			delims: Delims{Left: "[[", Right: "]]"},
+			src:    `<w:p>` + run(`{{.A}} [[.Na`) + run(`me]]`) + `</w:p>`,
+			want:   `<w:p>` + run(`{{.A}} [[[.Name]]]`) + run(``) + `</w:p>`,
+		},
+		{
+			name:   "XML escaped custom delimiters",
+			delims: Delims{Left: "<<", Right: ">>"},
+			src:    `<w:p>` + run(`&lt;&lt;.Na`) + run(`me&gt;&gt; &lt;b&gt;`) + `</w:p>`,
+			want:   `<w:p>` + run(`[&lt;&lt;.Name&gt;&gt;]`) + run(` &lt;b&gt;`) + `</w:p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := tokenizeXml(tt.src)
+			mergeRunActions(tokens, tt.delims.orDefault().xmlActionRe(), func(action string) string {
+				return "[" + action + "]"
+			})
+
+			var got strings.Builder
+			for _, token := range tokens {
+				got.WriteString(token.value)
+			}
+
+			if got.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got.String(), tt.want)
+			}
+		})
+	}
+}
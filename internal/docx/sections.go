@@ -0,0 +1,234 @@
+package docx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	DOCX_PAGEBREAK_INJECT      = `</w:t><w:br w:type="page"/><w:t>`
+	DOCX_COLUMNBREAK_INJECT    = `</w:t><w:br w:type="column"/><w:t>`
+	DOCX_SECTIONBREAK_INJECT_F = `</w:t></w:r>[[SECTION_BREAK:%s]]</w:p><w:p><w:r><w:t>`
+
+	landscapeOrientation = "landscape"
+	portraitOrientation  = "portrait"
+)
+
+var (
+	sectionBreakRe = regexp.MustCompile(`\[\[SECTION_BREAK:(\w*)\]\]`)
+	// sectionBreakPattern matches the paragraph ends injected by sectionBreak, before their placeholders are resolved
+	sectionBreakPattern = `\[\[SECTION_BREAK:\w*\]\]</w:p><w:p>`
+	// emptyParagraphAfterBreakRe matches the paragraph left empty after a section break placed at the end of its paragraph,
+	// along with the end of the tracked insertion of the break
+	emptyParagraphAfterBreakRe = regexp.MustCompile(`(\[\[SECTION_BREAK:\w*\]\]</w:p>)<w:p>(?:<w:r><w:t>(\[\[TRACK_INS_END\]\])</w:t></w:r>)?</w:p>`)
+	pgSzRe                     = regexp.MustCompile(`<w:pgSz\b[^>]*>`)
+	pgMarRe                    = regexp.MustCompile(`<w:pgMar\b[^>]*>`)
+	pgSzHeightAttrRe           = regexp.MustCompile(`\bw:h="(\d+)"`)
+	pgSzSidesAttrRe            = regexp.MustCompile(`\bw:(w|h)=`)
+	pgMarSidesAttrRe           = regexp.MustCompile(`\bw:(top|left|bottom|right)=`)
+	orientAttrRe               = regexp.MustCompile(`\s*\bw:orient="([^"]*)"`)
+)
+
+// pageBreak starts a new page.
+func pageBreak() string {
+	return DOCX_PAGEBREAK_INJECT
+}
+
+// columnBreak starts a new column, or a new page if the section has a single column.
+func columnBreak() string {
+	return DOCX_COLUMNBREAK_INJECT
+}
+
+// sectionBreak closes the current paragraph and starts a new section on a new page,
+// with the given orientation ("landscape" or "portrait").
+func sectionBreak(orientation string) (string, error) {
+	orientation = strings.ToLower(strings.TrimSpace(orientation))
+	if orientation != landscapeOrientation && orientation != portraitOrientation {
+		return "", fmt.Errorf("func 'sectionBreak': unknown orientation '%s', expected \"%s\" or \"%s\"",
+			orientation, landscapeOrientation, portraitOrientation)
+	}
+
+	return fmt.Sprintf(DOCX_SECTIONBREAK_INJECT_F, orientation), nil
+}
+
+// isLandscape reports whether the pages of the section are in landscape orientation.
+func isLandscape(sectPr string) bool {
+	pgSz := pgSzRe.FindString(sectPr)
+	if m := orientAttrRe.FindStringSubmatch(pgSz); m != nil {
+		return m[1] == landscapeOrientation
+	}
+
+	return xmlIntAttr(pgSz, xmlWidthAttrRe, 0) > xmlIntAttr(pgSz, pgSzHeightAttrRe, 0)
+}
+
+// orientSectPr returns the section properties with the given orientation,
+// swapping the page width and height and the margins if needed.
+func orientSectPr(sectPr string, landscape bool) string {
+	if isLandscape(sectPr) == landscape {
+		return sectPr
+	}
+
+	sectPr = pgSzRe.ReplaceAllStringFunc(sectPr, func(pgSz string) string {
+		pgSz = pgSzSidesAttrRe.ReplaceAllStringFunc(pgSz, func(attr string) string {
+			if attr == "w:w=" {
+				return "w:h="
+			}
+			return "w:w="
+		})
+		pgSz = orientAttrRe.ReplaceAllString(pgSz, "")
+		if landscape {
+			end := strings.LastIndex(pgSz, "/>")
+			if end < 0 {
+				end = len(pgSz) - 1
+			}
+			pgSz = pgSz[:end] + ` w:orient="landscape"` + pgSz[end:]
+		}
+
+		return pgSz
+	})
+
+	return pgMarRe.ReplaceAllStringFunc(sectPr, func(pgMar string) string {
+		return pgMarSidesAttrRe.ReplaceAllStringFunc(pgMar, func(attr string) string {
+			switch attr {
+			case "w:top=":
+				return "w:left="
+			case "w:left=":
+				return "w:top="
+			case "w:bottom=":
+				return "w:right="
+			default:
+				return "w:bottom="
+			}
+		})
+	})
+}
+
+// bodySectPr returns the section properties of the last section of the document.
+func bodySectPr(srcXML string) (string, bool) {
+	end := strings.LastIndex(srcXML, "</w:body>")
+	if end < 0 {
+		return "", false
+	}
+
+	start := strings.LastIndex(srcXML[:end], "<w:sectPr")
+	if start < 0 {
+		return "", false
+	}
+
+	// the properties of a previous section are inside a paragraph
+	sectPr := strings.TrimSpace(srcXML[start:end])
+	if strings.Contains(sectPr, "</w:p>") || !strings.HasSuffix(sectPr, "</w:sectPr>") && !strings.HasSuffix(sectPr, "/>") {
+		return "", false
+	}
+
+	return sectPr, true
+}
+
+// applySectionBreaks ends the sections at the paragraphs closed by sectionBreak, with the properties
+// of the last section of the document in the orientation given by the previous break, or in its own
+// orientation for the first section. The last section gets the orientation of the last break.
+func (d *DocumentMeta) applySectionBreaks(name, srcXML string) (string, error) {
+	if !sectionBreakRe.MatchString(srcXML) {
+		return srcXML, nil
+	}
+
+	if name != "word/document.xml" {
+		return srcXML, fmt.Errorf("section breaks can only be inserted in the document")
+	}
+
+	sectPr, ok := bodySectPr(srcXML)
+	if !ok {
+		return srcXML, fmt.Errorf("the document has no section properties")
+	}
+
+	srcXML = keepSplitRunProps(srcXML, sectionBreakPattern)
+	srcXML = removeEmptyRunsAround(srcXML, sectionBreakPattern)
+	srcXML = emptyParagraphAfterBreakRe.ReplaceAllString(srcXML, "${1}${2}")
+
+	landscape := isLandscape(sectPr)
+	out := make([]byte, 0, len(srcXML)+len(sectPr))
+	paragraphs := []int{}
+	tables := 0
+	breaks := []bool{}
+
+	for _, token := range tokenizeXml(srcXML) {
+		switch {
+		case !token.isTag:
+			for _, m := range sectionBreakRe.FindAllStringSubmatch(token.value, -1) {
+				if len(paragraphs) != 1 || tables != 0 {
+					return srcXML, fmt.Errorf("func 'sectionBreak': the sections can't be broken inside tables and text boxes")
+				}
+				breaks = append(breaks, m[1] == landscapeOrientation)
+			}
+			out = append(out, sectionBreakRe.ReplaceAllString(token.value, "")...)
+			continue
+		case token.qualifiedName() == "w:tbl":
+			if token.isOpening() {
+				tables++
+			} else if token.isClosing() {
+				tables--
+			}
+		case token.qualifiedName() == "w:p" && token.isOpening():
+			paragraphs = append(paragraphs, len(out))
+		case token.qualifiedName() == "w:p" && token.isClosing() && len(paragraphs) > 0:
+			start := paragraphs[len(paragraphs)-1]
+			paragraphs = paragraphs[:len(paragraphs)-1]
+
+			for _, breakLandscape := range breaks {
+				paragraph := setParagraphSectPr(string(out[start:]), orientSectPr(sectPr, landscape))
+				out = append(out[:start], paragraph...)
+				landscape = breakLandscape
+			}
+			breaks = breaks[:0]
+		}
+
+		out = append(out, token.value...)
+	}
+
+	srcXML = string(out)
+	if i := strings.LastIndex(srcXML, sectPr); i >= 0 {
+		srcXML = srcXML[:i] + orientSectPr(sectPr, landscape) + srcXML[i+len(sectPr):]
+	}
+
+	return srcXML, nil
+}
+
+// setParagraphSectPr sets the section properties of the section ended by the paragraph,
+// unless it already ends one.
+func setParagraphSectPr(paragraph, sectPr string) string {
+	tokens := tokenizeXml(paragraph)
+	if len(tokens) == 0 {
+		return paragraph
+	}
+
+	head := len(tokens[0].value)
+	switch {
+	case len(tokens) == 1 || tokens[1].qualifiedName() != "w:pPr":
+		return paragraph[:head] + "<w:pPr>" + sectPr + "</w:pPr>" + paragraph[head:]
+	case !tokens[1].isOpening():
+		return paragraph[:head] + "<w:pPr>" + sectPr + "</w:pPr>" + paragraph[head+len(tokens[1].value):]
+	}
+
+	offset := head
+	depth := 0
+	for _, token := range tokens[1:] {
+		if depth == 1 {
+			switch name := token.qualifiedName(); {
+			case name == "w:sectPr":
+				return paragraph
+			case name == "w:pPrChange", name == "w:pPr" && token.isClosing():
+				return paragraph[:offset] + sectPr + paragraph[offset:]
+			}
+		}
+
+		if token.isOpening() {
+			depth++
+		} else if token.isClosing() {
+			depth--
+		}
+		offset += len(token.value)
+	}
+
+	return paragraph
+}
@@ -0,0 +1,121 @@
+package docx
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const (
+	testPortraitSectPr = `<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440"/></w:sectPr>`
+	// testLandscapeSectPr is testPortraitSectPr with swapped sides
+	testLandscapeSectPr = `<w:sectPr><w:pgSz w:h="11906" w:w="16838" w:orient="landscape"/><w:pgMar w:left="1440" w:bottom="1440" w:right="1440" w:top="1440"/></w:sectPr>`
+)
+
+var testSectPrRe = regexp.MustCompile(`<w:sectPr>.*?</w:sectPr>`)
+
+func TestBreaks(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		paragraphs []string
+		contains   string
+		sectPrs    []string
+	}{
+		{
+			name:       "page break",
+			body:       p("A{{pageBreak}}B"),
+			paragraphs: []string{"AB"},
+			contains:   `<w:br w:type="page"/>`,
+			sectPrs:    []string{testPortraitSectPr},
+		},
+		{
+			name:       "column break",
+			body:       p("A{{columnBreak}}B"),
+			paragraphs: []string{"AB"},
+			contains:   `<w:br w:type="column"/>`,
+			sectPrs:    []string{testPortraitSectPr},
+		},
+		{
+			name:       "section break in a paragraph",
+			body:       p(`Portrait{{sectionBreak "landscape"}}Landscape`),
+			paragraphs: []string{"Portrait", "Landscape"},
+			contains:   `<w:p><w:pPr>` + testPortraitSectPr + `</w:pPr>`,
+			sectPrs:    []string{testPortraitSectPr, testLandscapeSectPr},
+		},
+		{
+			name:       "section breaks at the end of paragraphs",
+			body:       p(`Portrait{{sectionBreak "landscape"}}`) + p(`Landscape{{sectionBreak "portrait"}}`) + p("Portrait"),
+			paragraphs: []string{"Portrait", "Landscape", "Portrait"},
+			sectPrs:    []string{testPortraitSectPr, testLandscapeSectPr, testPortraitSectPr},
+		},
+		{
+			name:       "section break keeping the paragraph properties",
+			body:       `<w:p><w:pPr><w:jc w:val="center"/></w:pPr><w:r><w:t>A{{sectionBreak "landscape"}}</w:t></w:r></w:p>` + p("B"),
+			paragraphs: []string{"A", "B"},
+			contains:   `<w:pPr><w:jc w:val="center"/>` + testPortraitSectPr + `</w:pPr>`,
+			sectPrs:    []string{testPortraitSectPr, testLandscapeSectPr},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := renderTestDocument(t, tt.body, nil)
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+
+			if !strings.Contains(output, tt.contains) {
+				t.Errorf("output doesn't contain %s\n%s", tt.contains, output)
+			}
+
+			if got := testSectPrRe.FindAllString(output, -1); !reflect.DeepEqual(got, tt.sectPrs) {
+				t.Errorf("got sections properties\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.sectPrs, "\n"))
+			}
+		})
+	}
+}
+
+func TestSectionBreakErrors(t *testing.T) {
+	if _, err := sectionBreak("sideways"); err == nil || !strings.HasPrefix(err.Error(), "func 'sectionBreak': unknown orientation 'sideways'") {
+		t.Errorf("got error %v, want an unknown orientation error", err)
+	}
+
+	sectionBreakXml, err := sectionBreak("landscape")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		part string
+		body string
+		err  string
+	}{
+		{
+			name: "in a table",
+			part: "word/document.xml",
+			body: tbl([]int{2000}, tr(tc(p("A"+sectionBreakXml)))),
+			err:  "func 'sectionBreak': the sections can't be broken inside tables and text boxes",
+		},
+		{
+			name: "in a header",
+			part: "word/header1.xml",
+			body: p("A" + sectionBreakXml),
+			err:  "section breaks can only be inserted in the document",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDocumentMeta(t, "", nil)
+
+			_, err := d.applySectionBreaks(tt.part, testDocument(tt.body))
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("got error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
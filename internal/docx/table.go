@@ -0,0 +1,602 @@
+package docx
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Table is a table generated by the table template function, e.g. {{table .Report}}.
+type Table struct {
+	// Columns are the columns definitions, when empty they are the exported fields
+	// of the struct rows, the sorted keys of the map rows or the cells of the slice rows.
+	Columns []TableColumn
+	// Rows is a slice of structs, of maps or of slices (e.g. [][]string).
+	Rows any
+	// Style is the id of a table style defined in styles.xml (e.g. "TableGrid").
+	Style string
+}
+
+// TableColumn is the definition of a column of a generated table.
+type TableColumn struct {
+	// Field is the struct field or map key of the column values, the columns
+	// of the slice rows are their cells in order.
+	Field string
+	// Header is the label of the column in the header row, which is
+	// omitted when all the columns headers are empty.
+	Header string
+	// Width is the width of the column in twips, the columns without width
+	// share the remaining usable width of the page.
+	Width int
+	// Align is the alignment of the column cells: "left", "center" or "right".
+	Align string
+	// Format is the fmt format of the column values, e.g. "%.2f".
+	Format string
+}
+
+// tableModel is the table passed from the table template function
+// to the output processing, encoded in its placeholder.
+type tableModel struct {
+	Style   string     `json:"s,omitempty"`
+	Widths  []int      `json:"w"`
+	Aligns  []string   `json:"a"`
+	Headers []string   `json:"h,omitempty"`
+	Rows    [][]string `json:"r"`
+}
+
+var tablePlaceholderRe = regexp.MustCompile(`\[\[TABLE:([A-Za-z0-9+/=]*)\]\]`)
+
+// table generates a table from a Table or from its rows, the options are the
+// table style (e.g. "style:TableGrid") and the columns definitions, either as
+// TableColumn values or as "field|header|width|align|format" strings
+// (e.g. "Price|Unit price|1200|right|%.2f", the trailing parts are optional).
+func table(data any, options ...any) (string, error) {
+	t, err := toTable(data)
+	if err != nil {
+		return "", fmt.Errorf("func 'table': %w", err)
+	}
+
+	for _, option := range options {
+		switch o := option.(type) {
+		case string:
+			if style, ok := cutPrefix(o, "style:"); ok {
+				t.Style = style
+				continue
+			}
+
+			column, err := parseTableColumn(o)
+			if err != nil {
+				return "", fmt.Errorf("func 'table': %w", err)
+			}
+			t.Columns = append(t.Columns, column)
+		case TableColumn:
+			t.Columns = append(t.Columns, o)
+		case []TableColumn:
+			t.Columns = append(t.Columns, o...)
+		default:
+			return "", fmt.Errorf("func 'table': invalid option %v (must be a style, a column definition or a TableColumn)", option)
+		}
+	}
+
+	model, err := t.model()
+	if err != nil {
+		return "", fmt.Errorf("func 'table': %w", err)
+	}
+
+	b, err := json.Marshal(model)
+	if err != nil {
+		return "", fmt.Errorf("func 'table': unable to encode table: %w", err)
+	}
+
+	return fmt.Sprintf("[[TABLE:%s]]", base64.StdEncoding.EncodeToString(b)), nil
+}
+
+// cutPrefix is strings.CutPrefix, missing in go1.18.
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+
+	return s[len(prefix):], true
+}
+
+// toTable returns the Table of the table function data: a Table, a map with
+// the Table fields (e.g. from JSON template values) or the table rows.
+func toTable(data any) (Table, error) {
+	switch t := data.(type) {
+	case Table:
+		return t, nil
+	case *Table:
+		if t == nil {
+			return Table{}, nil
+		}
+		return *t, nil
+	case map[string]any:
+		if _, ok := t["Rows"]; !ok {
+			break
+		}
+
+		b, err := json.Marshal(t)
+		if err != nil {
+			return Table{}, fmt.Errorf("unable to read table: %w", err)
+		}
+
+		var table Table
+		if err := json.Unmarshal(b, &table); err != nil {
+			return Table{}, fmt.Errorf("unable to read table: %w", err)
+		}
+		return table, nil
+	}
+
+	return Table{Rows: data}, nil
+}
+
+// parseTableColumn parses a "field|header|width|align|format" column definition.
+func parseTableColumn(definition string) (TableColumn, error) {
+	parts := strings.Split(definition, "|")
+	column := TableColumn{Field: parts[0], Header: parts[0]}
+	if len(parts) > 1 {
+		column.Header = parts[1]
+	}
+
+	if len(parts) > 2 && parts[2] != "" {
+		width, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return column, fmt.Errorf("invalid width in column definition '%s': %w", definition, err)
+		}
+		column.Width = width
+	}
+
+	if len(parts) > 3 {
+		column.Align = parts[3]
+	}
+
+	if len(parts) > 4 {
+		column.Format = strings.Join(parts[4:], "|")
+	}
+
+	return column, nil
+}
+
+// model returns the cells texts of the table.
+func (t Table) model() (tableModel, error) {
+	rows := indirect(reflect.ValueOf(t.Rows))
+
+	if rows.IsValid() && rows.Kind() != reflect.Slice && rows.Kind() != reflect.Array {
+		return tableModel{}, fmt.Errorf("rows must be a slice, got %s", rows.Type())
+	}
+
+	columns := t.Columns
+	if len(columns) == 0 && rows.IsValid() && rows.Len() > 0 {
+		columns = inferTableColumns(indirect(rows.Index(0)))
+	}
+
+	model := tableModel{Style: t.Style}
+	for _, column := range columns {
+		switch column.Align {
+		case "", "left", "center", "right":
+		default:
+			return tableModel{}, fmt.Errorf("invalid alignment '%s' of column '%s' (must be left, center or right)", column.Align, column.Field)
+		}
+
+		model.Widths = append(model.Widths, column.Width)
+		model.Aligns = append(model.Aligns, column.Align)
+		if column.Header != "" {
+			model.Headers = make([]string, len(columns))
+		}
+	}
+
+	if model.Headers != nil {
+		for i, column := range columns {
+			model.Headers[i] = column.Header
+		}
+	}
+
+	for i := 0; rows.IsValid() && i < rows.Len(); i++ {
+		row := indirect(rows.Index(i))
+		cells := make([]string, len(columns))
+		for j, column := range columns {
+			value, err := tableCellValue(row, j, column)
+			if err != nil {
+				return tableModel{}, fmt.Errorf("row %d: %w", i, err)
+			}
+
+			if value != nil && column.Format != "" {
+				cells[j] = fmt.Sprintf(column.Format, value)
+			} else if value != nil {
+				cells[j] = fmt.Sprint(value)
+			}
+		}
+		model.Rows = append(model.Rows, cells)
+	}
+
+	return model, nil
+}
+
+// indirect returns the value pointed to by v, through pointers and interfaces.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	return v
+}
+
+// inferTableColumns returns the columns of a table from its first row.
+func inferTableColumns(row reflect.Value) []TableColumn {
+	columns := []TableColumn{}
+	switch row.Kind() {
+	case reflect.Struct:
+		for i := 0; i < row.NumField(); i++ {
+			if field := row.Type().Field(i); field.IsExported() {
+				columns = append(columns, TableColumn{Field: field.Name, Header: field.Name})
+			}
+		}
+	case reflect.Map:
+		keys := []string{}
+		for _, key := range row.MapKeys() {
+			keys = append(keys, fmt.Sprint(key.Interface()))
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			columns = append(columns, TableColumn{Field: key, Header: key})
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < row.Len(); i++ {
+			columns = append(columns, TableColumn{})
+		}
+	}
+
+	return columns
+}
+
+// tableCellValue returns the value of the i-th column of the row, nil if missing.
+func tableCellValue(row reflect.Value, i int, column TableColumn) (any, error) {
+	var value reflect.Value
+	switch row.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+	case reflect.Struct:
+		value = row.FieldByName(column.Field)
+		if !value.IsValid() {
+			return nil, fmt.Errorf("no field '%s' in type %s", column.Field, row.Type())
+		}
+	case reflect.Map:
+		if row.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("rows maps keys must be strings, got %s", row.Type().Key())
+		}
+		value = row.MapIndex(reflect.ValueOf(column.Field).Convert(row.Type().Key()))
+	case reflect.Slice, reflect.Array:
+		if i < row.Len() {
+			value = row.Index(i)
+		}
+	default:
+		return nil, fmt.Errorf("rows must be structs, maps or slices, got %s", row.Type())
+	}
+
+	value = indirect(value)
+	if !value.IsValid() || !value.CanInterface() {
+		return nil, nil
+	}
+
+	return value.Interface(), nil
+}
+
+// tableSample is the styling of a generated table, copied from the sample table in which
+// the table function is called (the first row for the header and the second one for the others).
+type tableSample struct {
+	tblPr string
+	// the row properties, the cell properties (without width and merge), the paragraph
+	// properties and the run properties of the first cell of the header and body rows
+	header, body tableSampleRow
+}
+
+type tableSampleRow struct {
+	trPr, tcPr, pPr, rPr string
+}
+
+var (
+	tblPrRe        = regexp.MustCompile(`(?s)^<w:tbl\b[^>]*>\s*(<w:tblPr>.*?</w:tblPr>)`)
+	trPrRe         = regexp.MustCompile(`(?s)<w:trPr>.*?</w:trPr>`)
+	tcPrRe         = regexp.MustCompile(`(?s)<w:tcPr>(.*?)</w:tcPr>`)
+	pPrRe          = regexp.MustCompile(`(?s)<w:pPr>.*?</w:pPr>`)
+	runPrRe        = regexp.MustCompile(`(?s)<w:r(?:\s[^>]*)?>\s*(<w:rPr>.*?</w:rPr>)`)
+	tcPrLayoutRe   = regexp.MustCompile(`(?s)<w:(?:tcW|gridSpan|vMerge|hMerge)\b[^>]*/>|<w:(tcW|gridSpan|vMerge|hMerge)\b[^>]*>.*?</w:(?:tcW|gridSpan|vMerge|hMerge)>`)
+	tblWRe         = regexp.MustCompile(`<w:tblW\b[^>]*/>`)
+	tblStyleRe     = regexp.MustCompile(`<w:tblStyle\b[^>]*/>`)
+	jcRe           = regexp.MustCompile(`<w:jc\b[^>]*/>`)
+	pPrAfterJcRe   = regexp.MustCompile(`<w:textDirection\b|<w:textAlignment\b|<w:textboxTightWrap\b|<w:outlineLvl\b|<w:divId\b|<w:cnfStyle\b|<w:rPr>|<w:sectPr\b|<w:pPrChange\b|</w:pPr>`)
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+)
+
+// newTableSample returns the styling of the sample table XML.
+func newTableSample(sampleXml string) tableSample {
+	sample := tableSample{}
+	if m := tblPrRe.FindStringSubmatch(sampleXml); m != nil {
+		sample.tblPr = m[1]
+	}
+
+	rows := []tableSampleRow{}
+	tokens := tokenizeXml(sampleXml)
+	depth, rowStart := 0, -1
+	for i, token := range tokens {
+		switch {
+		case token.isOpening() && token.qualifiedName() == "w:tbl":
+			depth++
+		case token.isClosing() && token.qualifiedName() == "w:tbl":
+			depth--
+		case depth == 1 && token.isOpening() && token.qualifiedName() == "w:tr":
+			rowStart = i
+		case depth == 1 && token.isClosing() && token.qualifiedName() == "w:tr" && rowStart >= 0:
+			rows = append(rows, newTableSampleRow(joinTokens(tokens[rowStart:i+1])))
+		}
+	}
+
+	switch len(rows) {
+	case 0:
+	case 1:
+		sample.header, sample.body = rows[0], rows[0]
+	default:
+		sample.header, sample.body = rows[0], rows[1]
+	}
+
+	return sample
+}
+
+// newTableSampleRow returns the styling of the first cell of the sample row XML.
+func newTableSampleRow(rowXml string) tableSampleRow {
+	row := tableSampleRow{
+		trPr: trPrRe.FindString(rowXml),
+		pPr:  pPrRe.FindString(rowXml),
+	}
+
+	if m := tcPrRe.FindStringSubmatch(rowXml); m != nil {
+		row.tcPr = tcPrLayoutRe.ReplaceAllString(m[1], "")
+	}
+
+	if m := runPrRe.FindStringSubmatch(rowXml); m != nil {
+		row.rPr = m[1]
+	}
+
+	return row
+}
+
+// joinTokens returns the XML source of the tokens.
+func joinTokens(tokens []xmlToken) string {
+	var sb strings.Builder
+	for _, token := range tokens {
+		sb.WriteString(token.value)
+	}
+
+	return sb.String()
+}
+
+// tableXml returns the XML of the table, styled as the sample if any. The columns without
+// width share the remaining maxWidth twips.
+func (m tableModel) tableXml(sample *tableSample, maxWidth int) string {
+	widths := append([]int(nil), m.Widths...)
+	fixedWidth, autoColumns := 0, 0
+	for _, width := range widths {
+		if width > 0 {
+			fixedWidth += width
+		} else {
+			autoColumns++
+		}
+	}
+
+	if autoColumns > 0 {
+		autoWidth := (maxWidth - fixedWidth) / autoColumns
+		if autoWidth < 500 {
+			autoWidth = 500
+		}
+		for i := range widths {
+			if widths[i] <= 0 {
+				widths[i] = autoWidth
+			}
+		}
+	}
+
+	totalWidth := 0
+	for _, width := range widths {
+		totalWidth += width
+	}
+
+	var sb strings.Builder
+	sb.WriteString("<w:tbl>")
+
+	tblW := fmt.Sprintf(`<w:tblW w:w="%d" w:type="dxa"/>`, totalWidth)
+	tblStyle := ""
+	if m.Style != "" {
+		tblStyle = fmt.Sprintf(`<w:tblStyle w:val="%s"/>`, xmlTextEscaper.Replace(m.Style))
+	}
+
+	header, body := tableSampleRow{rPr: "<w:rPr><w:b/></w:rPr>"}, tableSampleRow{}
+	switch {
+	case sample != nil && sample.tblPr != "":
+		tblPr := sample.tblPr
+		if tblWRe.MatchString(tblPr) {
+			tblPr = tblWRe.ReplaceAllLiteralString(tblPr, tblW)
+		} else {
+			tblPr = strings.Replace(tblPr, "</w:tblPr>", tblW+"</w:tblPr>", 1)
+		}
+		if tblStyle != "" {
+			tblPr = tblStyleRe.ReplaceAllLiteralString(tblPr, "")
+			tblPr = strings.Replace(tblPr, "<w:tblPr>", "<w:tblPr>"+tblStyle, 1)
+		}
+		sb.WriteString(tblPr)
+		header, body = sample.header, sample.body
+	case tblStyle != "":
+		sb.WriteString("<w:tblPr>" + tblStyle + tblW +
+			`<w:tblLook w:val="04A0" w:firstRow="1" w:lastRow="0" w:firstColumn="1" w:lastColumn="0" w:noHBand="0" w:noVBand="1"/></w:tblPr>`)
+		header.rPr = ""
+	default:
+		sb.WriteString("<w:tblPr>" + tblW + "<w:tblBorders>" +
+			`<w:top w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+			`<w:left w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+			`<w:bottom w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+			`<w:right w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+			`<w:insideH w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+			`<w:insideV w:val="single" w:sz="4" w:space="0" w:color="auto"/>` +
+			"</w:tblBorders></w:tblPr>")
+	}
+
+	sb.WriteString("<w:tblGrid>")
+	for _, width := range widths {
+		fmt.Fprintf(&sb, `<w:gridCol w:w="%d"/>`, width)
+	}
+	sb.WriteString("</w:tblGrid>")
+
+	if m.Headers != nil {
+		if header.trPr == "" {
+			// repeated at the top of each page
+			header.trPr = "<w:trPr><w:tblHeader/></w:trPr>"
+		}
+		m.writeRow(&sb, header, m.Headers, widths)
+	}
+
+	for _, cells := range m.Rows {
+		m.writeRow(&sb, body, cells, widths)
+	}
+
+	sb.WriteString("</w:tbl>")
+
+	return sb.String()
+}
+
+// writeRow writes the XML of a table row with the given styling.
+func (m tableModel) writeRow(sb *strings.Builder, style tableSampleRow, cells []string, widths []int) {
+	sb.WriteString("<w:tr>" + style.trPr)
+	for i, width := range widths {
+		fmt.Fprintf(sb, `<w:tc><w:tcPr><w:tcW w:w="%d" w:type="dxa"/>%s</w:tcPr>`, width, style.tcPr)
+
+		pPr := style.pPr
+		if align := m.Aligns[i]; align != "" {
+			jc := fmt.Sprintf(`<w:jc w:val="%s"/>`, align)
+			if pPr == "" {
+				pPr = "<w:pPr></w:pPr>"
+			}
+			pPr = jcRe.ReplaceAllLiteralString(pPr, "")
+			loc := pPrAfterJcRe.FindStringIndex(pPr)
+			pPr = pPr[:loc[0]] + jc + pPr[loc[0]:]
+		}
+
+		text := ""
+		if i < len(cells) {
+			text = cells[i]
+		}
+		fmt.Fprintf(sb, `<w:p>%s<w:r>%s<w:t xml:space="preserve">%s</w:t></w:r></w:p></w:tc>`, pPr, style.rPr, xmlTextEscaper.Replace(text))
+	}
+	sb.WriteString("</w:tr>")
+}
+
+// enclosingElement returns the first and last tokens of the innermost element with
+// the given qualified name enclosing the i-th token, or -1, -1 if not found.
+func enclosingElement(tokens []xmlToken, i int, name string) (int, int) {
+	start, depth := -1, 0
+	for j := i - 1; j >= 0 && start < 0; j-- {
+		switch {
+		case tokens[j].isClosing() && tokens[j].qualifiedName() == name:
+			depth++
+		case tokens[j].isOpening() && tokens[j].qualifiedName() == name:
+			if depth == 0 {
+				start = j
+			}
+			depth--
+		}
+	}
+
+	if start < 0 {
+		return -1, -1
+	}
+
+	end := elementEnd(tokens, start)
+	if end < i {
+		return -1, -1
+	}
+
+	return start, end
+}
+
+// elementEnd returns the closing token of the element starting at the start-th token,
+// start itself if it is self-closing, -1 if not found.
+func elementEnd(tokens []xmlToken, start int) int {
+	if !tokens[start].isOpening() {
+		return start
+	}
+
+	name, depth := tokens[start].qualifiedName(), 0
+	for j := start + 1; j < len(tokens); j++ {
+		switch {
+		case tokens[j].isOpening() && tokens[j].qualifiedName() == name:
+			depth++
+		case tokens[j].isClosing() && tokens[j].qualifiedName() == name:
+			if depth == 0 {
+				return j
+			}
+			depth--
+		}
+	}
+
+	return -1
+}
+
+// applyTables replaces the [[TABLE:...]] placeholders with the generated tables. A placeholder in
+// a table cell replaces the whole table, whose styling is copied; otherwise the paragraph is split
+// around the table and the parts left without content are removed.
+func (d *DocumentMeta) applyTables(srcXML string) (string, error) {
+	for {
+		loc := tablePlaceholderRe.FindStringSubmatchIndex(srcXML)
+		if loc == nil {
+			return srcXML, nil
+		}
+
+		b, err := base64.StdEncoding.DecodeString(srcXML[loc[2]:loc[3]])
+		if err != nil {
+			return srcXML, fmt.Errorf("unable to decode table: %w", err)
+		}
+
+		var model tableModel
+		if err := json.Unmarshal(b, &model); err != nil {
+			return srcXML, fmt.Errorf("unable to decode table: %w", err)
+		}
+
+		if model.Style != "" && !d.HasTableStyle(model.Style) {
+			return srcXML, fmt.Errorf("table style '%s' not found in styles.xml", model.Style)
+		}
+
+		tokens := tokenizeXml(srcXML)
+		k, offset := textTokenAt(tokens, loc[0])
+		if k < 0 {
+			return srcXML, fmt.Errorf("unable to locate table placeholder")
+		}
+
+		pStart, pEnd := enclosingElement(tokens, k, "w:p")
+		if pStart < 0 {
+			return srcXML, fmt.Errorf("table placeholder outside of a paragraph")
+		}
+
+		if tblStart, tblEnd := enclosingElement(tokens, pStart, "w:tbl"); tblStart >= 0 {
+			sample := newTableSample(joinTokens(tokens[tblStart : tblEnd+1]))
+			srcXML = joinTokens(tokens[:tblStart]) + model.tableXml(&sample, d.MaxWidthTwips()) + joinTokens(tokens[tblEnd+1:])
+			continue
+		}
+
+		beforeXml, afterXml := splitParagraph(tokens, pStart, pEnd, k, loc[0]-offset, loc[1]-offset)
+
+		// keep a paragraph after the table only at the end of a table cell, which must end with one
+		cellEnd := pEnd+1 < len(tokens) && tokens[pEnd+1].isClosing() && tokens[pEnd+1].qualifiedName() == "w:tc"
+		if isBlankParagraph(beforeXml) {
+			beforeXml = ""
+		}
+		if isBlankParagraph(afterXml) && !cellEnd {
+			afterXml = ""
+		}
+
+		srcXML = joinTokens(tokens[:pStart]) + beforeXml + model.tableXml(nil, d.MaxWidthTwips()) + afterXml + joinTokens(tokens[pEnd+1:])
+	}
+}
@@ -0,0 +1,116 @@
+package docx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:style w:type="table" w:styleId="TableGrid"><w:name w:val="Table Grid"/></w:style>` +
+	`</w:styles>`
+
+type testTableRow struct {
+	Name  string
+	Price float64
+}
+
+func TestTable(t *testing.T) {
+	data := map[string]any{
+		"Rows":  []testTableRow{{"a", 1.5}, {"b", 20}},
+		"Maps":  []map[string]any{{"y": 2, "x": 1}},
+		"Cells": [][]string{{"1", "2"}, {"3", "4"}},
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		want       [][]string
+		paragraphs []string
+		contains   string
+	}{
+		{
+			name:       "struct rows",
+			body:       p("before") + p("{{table .Rows}}") + p("after"),
+			want:       [][]string{{"Name", "Price"}, {"a", "1.5"}, {"b", "20"}},
+			paragraphs: []string{"before", "Name", "Price", "a", "1.5", "b", "20", "after"},
+			contains:   `<w:tblHeader/>`,
+		},
+		{
+			name: "columns and style",
+			body: p(`{{table .Rows "style:TableGrid" "Name|Item" "Price|Unit price|1200|right|%.2f"}}`),
+			want: [][]string{{"Item", "Unit price"}, {"a", "1.50"}, {"b", "20.00"}},
+			// the column without width shares the usable width of the page
+			contains: `<w:tblStyle w:val="TableGrid"/><w:tblW w:w="9026" w:type="dxa"/>`,
+		},
+		{
+			name:     "map rows",
+			body:     p("{{table .Maps}}"),
+			want:     [][]string{{"x", "y"}, {"1", "2"}},
+			contains: `<w:gridCol w:w="4513"/><w:gridCol w:w="4513"/>`,
+		},
+		{
+			name: "slice rows without header",
+			body: p("Cells: {{table .Cells}}"),
+			want: [][]string{{"1", "2"}, {"3", "4"}},
+			// the paragraph is split around the table
+			paragraphs: []string{"Cells: ", "1", "2", "3", "4"},
+		},
+		{
+			name:     "sample table",
+			body:     tbl([]int{5000}, tr(tc(p("{{table .Cells}}"))), tr(`<w:tc><w:tcPr><w:shd w:val="clear" w:fill="EEEEEE"/></w:tcPr>`+p("sample")+`</w:tc>`)),
+			want:     [][]string{{"1", "2"}, {"3", "4"}},
+			contains: `<w:shd w:val="clear" w:fill="EEEEEE"/>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDocumentMeta(t, tt.body, map[string]string{"word/styles.xml": testStyles})
+			output := renderTestPart(t, d, TemplateConfig{}, "word/document.xml", testDocument(tt.body), data)
+
+			if got := rowsTexts(output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got rows %q, want %q\n%s", got, tt.want, output)
+			}
+
+			if got := paragraphsTexts(output); tt.paragraphs != nil && !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+
+			if !strings.Contains(output, tt.contains) {
+				t.Errorf("output doesn't contain %s\n%s", tt.contains, output)
+			}
+		})
+	}
+}
+
+func TestTableErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    any
+		options []any
+		err     string
+	}{
+		{
+			name: "invalid rows",
+			data: 42,
+			err:  "func 'table':",
+		},
+		{
+			name:    "invalid option",
+			data:    [][]string{{"a"}},
+			options: []any{1},
+			err:     "func 'table': invalid option 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := table(tt.data, tt.options...)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("got error %v, want %s...", err, tt.err)
+			}
+		})
+	}
+}
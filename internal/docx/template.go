@@ -0,0 +1,125 @@
+package docx
+
+import (
+	"context"
+	"fmt"
+	"text/template"
+)
+
+// TemplateConfig holds the settings used to parse every templated XML part
+// of a docx file (document, headers, footers, charts and embedded sheets).
+type TemplateConfig struct {
+	Funcs template.FuncMap
+	// Namespaces lists the namespaces of the functions registered
+	// with NamespacedFuncName, e.g. "acme" for {{acme.formatIBAN .IBAN}}.
+	Namespaces []string
+	// MissingKey is the missing key policy of every part, unless overridden in PartsMissingKey.
+	MissingKey MissingKeyPolicy
+	// PartsMissingKey maps the parts names (e.g. "word/header1.xml") to their missing key policy.
+	PartsMissingKey map[string]MissingKeyPolicy
+	// Delims are the delimiters of the template actions, "{{" and "}}" if empty.
+	Delims Delims
+	// MaxTableWidth is the width in twips that the tables with a column loop are shrunk to fit,
+	// usually the usable width of the page, 0 means no limit.
+	MaxTableWidth int
+	// TrackChanges marks the values printed in the document, headers and footers
+	// and the text of the false {{if}} branches, to render them as tracked changes.
+	TrackChanges bool
+}
+
+// Template is a parsed XML part template along with its patched source,
+// used to locate the expressions of the template errors.
+type Template struct {
+	*template.Template
+	source    string
+	locations sourceMap
+	delims    Delims
+}
+
+// Parse patches the given XML part content and parses it as a template
+// named after the part. Parsing errors are returned as *TemplateError.
+func (c TemplateConfig) Parse(name, content string) (*Template, error) {
+	source, locations, _ := c.patch(name, content)
+
+	tmpl, err := c.parse(name, source, locations)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template in file '%s': %w", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// parse parses the patched source and applies the config to its parse tree.
+// Parsing errors are returned as *TemplateError.
+func (c TemplateConfig) parse(name, source string, locations sourceMap) (*Template, *TemplateError) {
+	tmpl, err := c.newTemplate(name).Parse(source)
+	if err != nil {
+		return nil, newParseTemplateError(name, source, locations, c.parseFuncs(), c.delims(), err)
+	}
+
+	if err := c.resolveNamespacedFuncs(tmpl, source, locations); err != nil {
+		return nil, err
+	}
+	c.rewriteMissingKeys(tmpl)
+	c.rewriteTrackChanges(tmpl)
+	rewriteContextChecks(tmpl)
+
+	return &Template{
+		Template:  tmpl,
+		source:    source,
+		locations: locations,
+		delims:    c.delims(),
+	}, nil
+}
+
+// delims returns the configured delimiters, with the default ones in place of the empty ones.
+func (c TemplateConfig) delims() Delims {
+	return c.Delims.orDefault()
+}
+
+// patch returns the template source of the given XML part content and its locations in the content,
+// along with the expressions whose typographic characters were normalized.
+func (c TemplateConfig) patch(name, content string) (string, sourceMap, []NormalizedExpression) {
+	source, normalized, locations := patchXml(content, c.delims(), c.MaxTableWidth)
+	for i := range normalized {
+		normalized[i].Part = name
+	}
+
+	return source, locations, normalized
+}
+
+// newTemplate returns a new template with the given name and the config functions,
+// failing on the missing keys that are not handled by the missing key policy.
+func (c TemplateConfig) newTemplate(name string) *template.Template {
+	return template.New(name).
+		Delims(c.delims().Left, c.delims().Right).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{
+			MissingKeyLookupFunc: lookupMissingKey,
+			TrackInsertionFunc:   trackInsertion,
+		}).
+		Funcs(colRangeFuncs).
+		Funcs(c.parseFuncs())
+}
+
+// ExecuteXmlTemplate executes a parsed XML part template that needs
+// no further processing (e.g. charts). The execution stops when ctx is done, at the next printed
+// value or loop iteration, or when the output grows over maxSize bytes (0 means no limit).
+// Execution errors are returned as *TemplateError.
+func ExecuteXmlTemplate(ctx context.Context, tmpl *Template, data any, maxSize int64) ([]byte, error) {
+	w := executionWriter{
+		ctx:     ctx,
+		maxSize: maxSize,
+		name:    tmpl.Name(),
+	}
+
+	if err := tmpl.Execute(&w, data); err != nil {
+		if templateErr := newExecTemplateError(tmpl.Name(), tmpl.source, tmpl.locations, tmpl.delims, err); templateErr != nil {
+			err = templateErr
+		}
+
+		return nil, fmt.Errorf("unable to execute template in file '%s': %w", tmpl.Name(), err)
+	}
+
+	return w.buf.Bytes(), nil
+}
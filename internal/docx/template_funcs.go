@@ -1,6 +1,7 @@
 package docx
 
 import (
+	"encoding/base64"
 	"fmt"
 	"strconv"
 	"strings"
@@ -51,6 +52,8 @@ const imageTemplateXml = `<w:drawing>
   </wp:inline>
 </w:drawing>`
 
+var imageTemplate = template.Must(template.New("image-template").Parse(imageTemplateXml))
+
 const (
 	DOCX_NEWLINE_INJECT        = `</w:t><w:br/><w:t>`
 	DOCX_BREAKPARAGRAPH_INJECT = `</w:t></w:r></w:p><w:p><w:r><w:t>`
@@ -243,11 +246,26 @@ func fontSize(s string, size int) string {
 	return fmt.Sprintf(STYLE_WRAPPER_F, fontSizeWrapperf(size), s)
 }
 
+// isHexColor reports whether hex is a RRGGBB color.
+func isHexColor(hex string) bool {
+	if len(hex) != 6 {
+		return false
+	}
+
+	for _, c := range hex {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // color sets the font color of the text
 func color(s, hex string) (string, error) {
 	hex = strings.TrimPrefix(hex, "#")
-	if len(hex) != 6 {
-		return "", fmt.Errorf("func 'color': invalid hex color value: %s (must be 6 characters like '0077FF')", hex)
+	if !isHexColor(hex) {
+		return "", fmt.Errorf("func 'color': invalid hex color value: %s (must be 6 hex characters like '0077FF')", hex)
 	}
 
 	return fmt.Sprintf(COLOR_WRAPPER_F, strings.ToUpper(hex), s), nil
@@ -283,8 +301,8 @@ func highlight(s, color string) (string, error) {
 // shadeTextBg applies a background color to the given text
 func shadeTextBg(s, hex string) (string, error) {
 	hex = strings.TrimPrefix(hex, "#")
-	if len(hex) != 6 {
-		return "", fmt.Errorf("func 'shadeTextBg': invalid hex color value: %s (must be 6 characters like '0077FF')", hex)
+	if !isHexColor(hex) {
+		return "", fmt.Errorf("func 'shadeTextBg': invalid hex color value: %s (must be 6 hex characters like '0077FF')", hex)
 	}
 
 	return fmt.Sprintf(SHADING_WRAPPER_F, strings.ToUpper(hex), s), nil
@@ -315,8 +333,8 @@ func breakParagraph(text string) string {
 // shapeBgFillColor replace fillcolor to shapes
 func shapeBgFillColor(hex string) (string, error) {
 	hex = strings.TrimPrefix(hex, "#")
-	if len(hex) != 6 {
-		return "", fmt.Errorf("func 'shapeBgFillColor': invalid hex color value: %s  (must be 6 characters like '0077FF')", hex)
+	if !isHexColor(hex) {
+		return "", fmt.Errorf("func 'shapeBgFillColor': invalid hex color value: %s  (must be 6 hex characters like '0077FF')", hex)
 	}
 
 	return fmt.Sprintf("[[SHAPE_BG_FILL_COLOR:%s]]", strings.ToUpper(hex)), nil
@@ -325,13 +343,49 @@ func shapeBgFillColor(hex string) (string, error) {
 // tableCellBgColor replace background color of table cells
 func tableCellBgColor(hex string) (string, error) {
 	hex = strings.TrimPrefix(hex, "#")
-	if len(hex) != 6 {
-		return "", fmt.Errorf("func 'tableCellBgColor': invalid hex color value: %s  (must be 6 characters like '0077FF')", hex)
+	if !isHexColor(hex) {
+		return "", fmt.Errorf("func 'tableCellBgColor': invalid hex color value: %s  (must be 6 hex characters like '0077FF')", hex)
 	}
 
 	return fmt.Sprintf("[[TABLE_CELL_BG_COLOR:%s]]", strings.ToUpper(hex)), nil
 }
 
+// mergeCols merges the table cell with the following cells of its row, so that
+// it spans n grid columns.
+func mergeCols(n int) (string, error) {
+	if n < 1 {
+		return "", fmt.Errorf("func 'mergeCols': invalid span: %d (must be at least 1)", n)
+	}
+
+	return fmt.Sprintf("[[TABLE_CELL_GRID_SPAN:%d]]", n), nil
+}
+
+// mergeRows starts ("restart") or continues ("continue") a vertical merge of table cells.
+func mergeRows(state string) (string, error) {
+	if state != "restart" && state != "continue" {
+		return "", fmt.Errorf("func 'mergeRows': invalid state: %s (must be 'restart' or 'continue')", state)
+	}
+
+	return fmt.Sprintf("[[TABLE_CELL_VMERGE:%s]]", state), nil
+}
+
+// mergeRowsBy merges the table cell with the cell above it when both were given the same value.
+func mergeRowsBy(value any) string {
+	return fmt.Sprintf("[[TABLE_CELL_VMERGE_BY:%s]]", base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value))))
+}
+
+// rowRange is only called when the row loop is not placed in a table row,
+// otherwise it is replaced with a range action wrapping the row before parsing.
+func rowRange(v any) (string, error) {
+	return "", fmt.Errorf("func '%s': must be placed in a table cell", RowRangeKeyword)
+}
+
+// colRange is only called when the column loop is not placed in a table cell,
+// otherwise it is replaced with range actions wrapping the column before parsing.
+func colRange(v any) (string, error) {
+	return "", fmt.Errorf("func '%s': must be placed in a table cell, once per table", ColRangeKeyword)
+}
+
 var TemplateFuncs = template.FuncMap{
 	"list":             list,
 	"bold":             bold,
@@ -345,9 +399,28 @@ var TemplateFuncs = template.FuncMap{
 	"highlight":        highlight,
 	"preserveNewline":  preserveNewline,
 	"breakParagraph":   breakParagraph,
+	"pageBreak":        pageBreak,
+	"columnBreak":      columnBreak,
+	"sectionBreak":     sectionBreak,
 	"shadeTextBg":      shadeTextBg,
 	"image":            image,
 	"replaceImage":     replaceImage,
 	"shapeBgFillColor": shapeBgFillColor,
 	"tableCellBgColor": tableCellBgColor,
+	"mergeCols":        mergeCols,
+	"mergeRows":        mergeRows,
+	"mergeRowsBy":      mergeRowsBy,
+	"default":          defaultValue,
+	RowRangeKeyword:    rowRange,
+	ColRangeKeyword:    colRange,
+	"table":            table,
+	"bulletList":       bulletList,
+	"numberedList":     numberedList,
+	"hyperlink":        hyperlink,
+	"bookmark":         bookmark,
+	"linkTo":           linkTo,
+	"footnote":         footnote,
+	"endnote":          endnote,
+	"comment":          comment,
+	"commentOn":        commentOn,
 }
@@ -7,10 +7,9 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
 )
 
-func (d *documentMeta) applyImages(srcXML string) (string, []MediaRel, error) {
+func (d *DocumentMeta) applyImages(name, srcXML string) (string, []MediaRel, error) {
 	mediaRels := []MediaRel{}
 
 	imagePlaceholderRE := regexp.MustCompile(`\[\[IMAGE:.*?\]\]`)
@@ -25,14 +24,9 @@ func (d *documentMeta) applyImages(srcXML string) (string, []MediaRel, error) {
 			return srcXML, mediaRels, fmt.Errorf("unable to get unique docPrId: %w", err)
 		}
 
-		rid := d.NextRId()
+		rid := d.NextPartRId(name)
 		rId := fmt.Sprintf("rId%d", rid)
 
-		imageTemplate, err := template.New("image-template").Parse(imageTemplateXml)
-		if err != nil {
-			return srcXML, mediaRels, err
-		}
-
 		v, ok := d.mediaMap[filename]
 		if !ok {
 			return srcXML, mediaRels, fmt.Errorf("filename '%s' not found in loaded medias", filename)
@@ -61,20 +55,23 @@ func (d *documentMeta) applyImages(srcXML string) (string, []MediaRel, error) {
 			Source: path.Join("media", v.WordFilename),
 		})
 
-		srcXML = strings.ReplaceAll(srcXML, xmlBlock, buffer.String())
+		// the same placeholder may be repeated (e.g. in a loop), each one needs its own ids
+		srcXML = strings.Replace(srcXML, xmlBlock, buffer.String(), 1)
 	}
 
 	return srcXML, mediaRels, nil
 }
 
 // replaceImages looks for [[REPLACE_IMAGE:filename.ext]] placeholders inside <w:drawing>...</w:drawing> blocks
-// remove the placeholder and replaces the image reference inside the block with the given image's rId.
-func (d *documentMeta) replaceImages(srcXML string) (string, []MediaRel) {
+// remove the placeholder and replaces the image reference inside the block with the given image's rId,
+// to add to the rels file of the given part.
+func (d *DocumentMeta) replaceImages(name, srcXML string) (string, []MediaRel, error) {
 	anchorRe := regexp.MustCompile(`(?s)<w:drawing>.*?</w:drawing>`)
 	placeholderRe := regexp.MustCompile(`\[\[REPLACE_IMAGE:([^\]]+)\]\]`)
 	blipRe := regexp.MustCompile(`(<a:blip\s+r:embed=")[^"]*(")`)
 
 	mediaRels := []MediaRel{}
+	var err error
 
 	result := anchorRe.ReplaceAllStringFunc(srcXML, func(block string) string {
 		pm := placeholderRe.FindStringSubmatch(block)
@@ -83,23 +80,32 @@ func (d *documentMeta) replaceImages(srcXML string) (string, []MediaRel) {
 		}
 		filename := pm[1]
 
+		v, ok := d.mediaMap[filename]
+		if !ok {
+			err = fmt.Errorf("filename '%s' not found in loaded medias", filename)
+			return block
+		}
+
 		block = placeholderRe.ReplaceAllString(block, "")
 
-		rid := d.NextRId()
+		rid := d.NextPartRId(name)
 		rId := fmt.Sprintf("rId%d", rid)
 
 		mediaRels = append(mediaRels, MediaRel{
 			Type:   ImageMediaType,
 			RefID:  rId,
-			Source: path.Join("media", d.mediaMap[filename].WordFilename),
+			Source: path.Join("media", v.WordFilename),
 		})
 
 		block = blipRe.ReplaceAllString(block, "${1}"+rId+"${2}")
 
 		return block
 	})
+	if err != nil {
+		return srcXML, mediaRels, err
+	}
 
-	return result, mediaRels
+	return result, mediaRels, nil
 }
 
 // adjustBrightnessHex lightens or darkens a hex color by factor (0..1).
@@ -155,7 +161,7 @@ var (
 // ReplaceAllShapeBgColors finds shapes that contain the [[SHAPE_BG_COLOR:RRGGBB]]/[[SHAPE_BG_COLOR:#RRGGBB]]
 // placeholder and uses its value to replace the fillcolor attribute of the shape
 // TODO: replace with proper XML parsing
-func (d *documentMeta) applyShapesBgFillColor(srcXML string) string {
+func (d *DocumentMeta) applyShapesBgFillColor(srcXML string) string {
 	return mcAlternateContentRe.ReplaceAllStringFunc(srcXML, func(block string) string {
 		placeholders := placeholderRe.FindAllStringSubmatch(block, -1)
 		if len(placeholders) == 0 {
@@ -263,7 +269,7 @@ const withShading = `><w:shd w:val="clear" w:color="auto" w:fill="FFFFFF" /></w:
 
 // replaceTableCellBgColors is used to apply the hex color found in the
 // [[TABLE_CELL_BG_COLOR:RRGGBB]]/[[TABLE_CELL_BG_COLOR:#RRGGBB]] as the background color of the table cell
-func (d *documentMeta) replaceTableCellBgColors(srcXML string) string {
+func (d *DocumentMeta) replaceTableCellBgColors(srcXML string) string {
 	tcRe := regexp.MustCompile(`(?s)<w:tc>.*?</w:tc>`)
 
 	output := tcRe.ReplaceAllStringFunc(srcXML, func(block string) string {
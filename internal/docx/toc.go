@@ -0,0 +1,540 @@
+package docx
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SettingsContentType is the content type of word/settings.xml.
+const SettingsContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.settings+xml"
+
+const (
+	// tocLevels is the number of levels of a table of contents.
+	tocLevels = 9
+	// tocIndentTwips is the indentation of each level of the entries without a TOC style.
+	tocIndentTwips = 220
+	// tableOfFiguresStyle is the style of the entries of the lists of figures and tables.
+	tableOfFiguresStyle = "TableofFigures"
+
+	DOCX_TOC_ENTRY_PPR_F  = `<w:pPr>%s<w:tabs><w:tab w:val="right" w:leader="dot" w:pos="%d"/></w:tabs>%s</w:pPr>`
+	DOCX_TOC_ENTRY_TEXT_F = `<w:r><w:t xml:space="preserve">%s</w:t></w:r>`
+	DOCX_TOC_ENTRY_PAGE_F = `<w:r><w:tab/></w:r><w:r><w:fldChar w:fldCharType="begin"/></w:r><w:r><w:instrText xml:space="preserve"> PAGEREF %s \h </w:instrText></w:r>` +
+		`<w:r><w:fldChar w:fldCharType="separate"/></w:r><w:r><w:fldChar w:fldCharType="end"/></w:r>`
+	DOCX_TOC_HYPERLINK_F    = `<w:hyperlink w:anchor="%s" w:history="1">%s</w:hyperlink>`
+	DOCX_UPDATE_FIELDS      = `<w:updateFields w:val="true"/>`
+	DOCX_SETTINGS_EMPTY_XML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<w:settings xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"></w:settings>`
+)
+
+var (
+	tocStyleRe        = regexp.MustCompile(`(?s)<w:style\b[^>]*/>|<w:style\b[^>]*>.*?</w:style>`)
+	styleIdAttrRe     = regexp.MustCompile(`\bw:styleId="([^"]*)"`)
+	styleTypeAttrRe   = regexp.MustCompile(`\bw:type="(\w+)"`)
+	tocStyleNameRe    = regexp.MustCompile(`<w:name w:val="([^"]*)"`)
+	tocBasedOnRe      = regexp.MustCompile(`<w:basedOn w:val="([^"]*)"`)
+	outlineLvlRe      = regexp.MustCompile(`<w:outlineLvl w:val="(\d+)"`)
+	styleValAttrRe    = regexp.MustCompile(`\bw:val="([^"]*)"`)
+	fldCharTypeAttrRe = regexp.MustCompile(`\bw:fldCharType="(\w+)"`)
+	fldSimpleInstrRe  = regexp.MustCompile(`\bw:instr="([^"]*)"`)
+	tocFieldRe        = regexp.MustCompile(`(?i)^\s*TOC\b`)
+	seqFieldRe        = regexp.MustCompile(`(?i)^\s*SEQ\s+([^\s\\]+)`)
+	tocSwitchRe       = regexp.MustCompile(`\\([A-Za-z])(?:\s+"([^"]*)"|\s+([^\s\\"]+))?`)
+	tocLevelsRangeRe  = regexp.MustCompile(`^\s*(\d+)\s*(?:-\s*(\d+))?\s*$`)
+	updateFieldsRe    = regexp.MustCompile(`<w:updateFields\b[^>]*/>`)
+	// settingsAfterUpdateFieldsRe matches the first element that follows <w:updateFields> in the settings
+	settingsAfterUpdateFieldsRe = regexp.MustCompile(`<w:(?:hdrShapeDefaults|footnotePr|endnotePr|compat|docVars|rsids|attachedSchema|themeFontLang|clrSchemeMapping|doNotIncludeSubdocsInStats|doNotAutoCompressPictures|forceUpgrade|captions|readModeInkLockDown|smartTagType|shapeDefaults|doNotEmbedSmartTags|decimalSymbol|listSeparator)\b|<m:mathPr\b|<sl:schemaLibrary\b|<w1\d:|</w:settings>`)
+)
+
+// paragraphStyle is the name and the outline level (from 1 to 9, 0 for the body text)
+// of a paragraph style of styles.xml, ok reports whether the level is set by the style or by its base style.
+type paragraphStyle struct {
+	name    string
+	basedOn string
+	level   int
+	ok      bool
+}
+
+// parseOutlineLevels returns the outline levels of the paragraph styles of styles.xml,
+// inherited from their base style when they have none, along with their names.
+func parseOutlineLevels(stylesXML string) (map[string]int, map[string]string) {
+	styles := map[string]paragraphStyle{}
+	for _, style := range tocStyleRe.FindAllString(stylesXML, -1) {
+		head := style[:strings.IndexByte(style, '>')+1]
+		m, t := styleIdAttrRe.FindStringSubmatch(head), styleTypeAttrRe.FindStringSubmatch(head)
+		if m == nil || t == nil || t[1] != "paragraph" {
+			continue
+		}
+
+		s := paragraphStyle{}
+		if n := tocStyleNameRe.FindStringSubmatch(style); n != nil {
+			s.name = n[1]
+		}
+		if b := tocBasedOnRe.FindStringSubmatch(style); b != nil {
+			s.basedOn = b[1]
+		}
+		if l := outlineLvlRe.FindStringSubmatch(style); l != nil {
+			level, _ := strconv.Atoi(l[1])
+			s.level, s.ok = level+1, true
+			if s.level > tocLevels {
+				s.level = 0
+			}
+		}
+		styles[m[1]] = s
+	}
+
+	levels := make(map[string]int, len(styles))
+	names := make(map[string]string, len(styles))
+	for id, s := range styles {
+		names[id] = s.name
+
+		// the base styles chain is bounded in case of loops
+		for i := 0; !s.ok && s.basedOn != "" && i < len(styles); i++ {
+			s.basedOn, s.level, s.ok = styles[s.basedOn].basedOn, styles[s.basedOn].level, styles[s.basedOn].ok
+		}
+		if s.level > 0 {
+			levels[id] = s.level
+		}
+	}
+
+	return levels, names
+}
+
+// SetUpdateTableOfContents sets whether the tables of contents of the document are rebuilt
+// from its headings after rendering, and updated by Word when the document is opened.
+func (d *DocumentMeta) SetUpdateTableOfContents(update bool) {
+	d.updateTableOfContents = update
+}
+
+// settingsXml returns the content of word/settings.xml, asking Word to update the fields
+// of the document when it is opened if the tables of contents are updated, and whether it changed.
+func (d *DocumentMeta) settingsXml() ([]byte, bool) {
+	if !d.updateTableOfContents {
+		return []byte(d.settings), false
+	}
+
+	settings := d.settings
+	if settings == "" {
+		settings = DOCX_SETTINGS_EMPTY_XML
+	}
+
+	if updateFieldsRe.MatchString(settings) {
+		return []byte(updateFieldsRe.ReplaceAllLiteralString(settings, DOCX_UPDATE_FIELDS)), true
+	}
+
+	loc := settingsAfterUpdateFieldsRe.FindStringIndex(settings)
+	if loc == nil {
+		return []byte(d.settings), false
+	}
+
+	return []byte(settings[:loc[0]] + DOCX_UPDATE_FIELDS + settings[loc[0]:]), true
+}
+
+// tocParagraph is a paragraph of the document body that may be listed in a table of contents.
+type tocParagraph struct {
+	// start, contentStart and end are the offsets of the paragraph, of its content after
+	// the properties and of its end after </w:p>
+	start, contentStart, end int
+	style                    string
+	// outlineLevel is the outline level set by the paragraph properties, 0 if none
+	outlineLevel int
+	text         strings.Builder
+	// sequences are the identifiers of the SEQ fields of the paragraph (e.g. "Figure" for the captions)
+	sequences []string
+	// bookmark is the name of the _Toc bookmark of the paragraph, if any
+	bookmark string
+	inTOC    bool
+}
+
+// tocField is a complex field of the document body, whose result is rebuilt if it is a table of contents.
+type tocField struct {
+	instr strings.Builder
+	// begin, separate and end are the offsets of the run of the field beginning, of the end of
+	// the run of its separator and of the run of its end
+	begin, separate, end int
+	// first and last are the indexes of the paragraphs of the field beginning and end
+	first, last int
+	separated   bool
+}
+
+// tocSwitches are the switches of a TOC field that select its entries and their layout.
+type tocSwitches struct {
+	// minLevel and maxLevel are the outline levels range of the headings (\o), 0 if none
+	minLevel, maxLevel int
+	// outlineLevels lists the paragraphs with an outline level in their properties (\u)
+	outlineLevels bool
+	// styles are the levels of the styles listed by name (\t)
+	styles map[string]int
+	// caption is the identifier of the SEQ fields of the captions listed instead of the headings (\c, \a)
+	caption string
+	// hyperlinks makes the entries links to the headings (\h)
+	hyperlinks bool
+	// noPageMin and noPageMax are the levels range of the entries without page number (\n), 0 if none
+	noPageMin, noPageMax int
+}
+
+// parseTocSwitches returns the switches of the given TOC field instruction.
+func parseTocSwitches(instr string) tocSwitches {
+	s := tocSwitches{styles: map[string]int{}}
+	for _, m := range tocSwitchRe.FindAllStringSubmatch(instr, -1) {
+		arg := m[2] + m[3]
+		switch m[1] {
+		case "o":
+			s.minLevel, s.maxLevel = parseTocLevelsRange(arg)
+		case "u":
+			s.outlineLevels = true
+		case "t":
+			fields := strings.Split(arg, ",")
+			for i := 0; i < len(fields); i += 2 {
+				level := 1
+				if i+1 < len(fields) {
+					level, _ = strconv.Atoi(strings.TrimSpace(fields[i+1]))
+				}
+				if name := strings.ToLower(strings.TrimSpace(fields[i])); name != "" && level >= 1 && level <= tocLevels {
+					s.styles[name] = level
+				}
+			}
+		case "c", "a":
+			s.caption = arg
+		case "h":
+			s.hyperlinks = true
+		case "n":
+			s.noPageMin, s.noPageMax = parseTocLevelsRange(arg)
+		}
+	}
+
+	if s.minLevel == 0 && !s.outlineLevels && len(s.styles) == 0 && s.caption == "" {
+		s.minLevel, s.maxLevel = 1, tocLevels
+	}
+
+	return s
+}
+
+// parseTocLevelsRange returns the levels range of a switch argument (e.g. "1-3"),
+// all the levels if it has none.
+func parseTocLevelsRange(arg string) (int, int) {
+	m := tocLevelsRangeRe.FindStringSubmatch(arg)
+	if m == nil {
+		return 1, tocLevels
+	}
+
+	from, _ := strconv.Atoi(m[1])
+	to := from
+	if m[2] != "" {
+		to, _ = strconv.Atoi(m[2])
+	}
+
+	return from, to
+}
+
+// level returns the level of the entry of the paragraph in the table of contents, 0 if it is not listed.
+func (s tocSwitches) level(d *DocumentMeta, p *tocParagraph) int {
+	if s.caption != "" {
+		for _, sequence := range p.sequences {
+			if strings.EqualFold(sequence, s.caption) {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	if level, ok := s.styles[strings.ToLower(d.paragraphStyleNames[p.style])]; ok {
+		return level
+	}
+	if level, ok := s.styles[strings.ToLower(p.style)]; ok {
+		return level
+	}
+	if s.minLevel == 0 && !s.outlineLevels {
+		return 0
+	}
+
+	level := d.outlineLevels[p.style]
+	if s.outlineLevels && p.outlineLevel > 0 {
+		level = p.outlineLevel
+	}
+
+	minLevel, maxLevel := s.minLevel, s.maxLevel
+	if minLevel == 0 {
+		minLevel, maxLevel = 1, tocLevels
+	}
+	if level < minLevel || level > maxLevel {
+		return 0
+	}
+
+	return level
+}
+
+// tocEdit replaces the source between two offsets.
+type tocEdit struct {
+	start, end int
+	value      string
+}
+
+// applyTableOfContents rebuilds the results of the TOC fields of the document with the headings,
+// or the captions, of the rendered document, each one getting a _Toc bookmark targeted by its entry.
+func (d *DocumentMeta) applyTableOfContents(name, srcXML string) string {
+	if !d.updateTableOfContents || name != "word/document.xml" || !strings.Contains(srcXML, "TOC") {
+		return srcXML
+	}
+
+	paragraphs, fields := parseTocParagraphs(srcXML)
+	if len(fields) == 0 {
+		return srcXML
+	}
+
+	for _, field := range fields {
+		for i := field.first; i <= field.last; i++ {
+			paragraphs[i].inTOC = true
+		}
+	}
+
+	edits := []tocEdit{}
+	usedBookmarks := map[string]struct{}{}
+	bookmarks := map[int]string{}
+	bookmarkOf := func(i int) string {
+		if name, ok := bookmarks[i]; ok {
+			return name
+		}
+
+		p := paragraphs[i]
+		name := p.bookmark
+		if _, used := usedBookmarks[name]; name == "" || used {
+			var id uint64
+			id, name = d.nextBookmark(fmt.Sprintf("_Toc%09d", d.greaterBookmarkId+1))
+			edits = append(edits,
+				tocEdit{p.contentStart, p.contentStart, fmt.Sprintf(`<w:bookmarkStart w:id="%d" w:name="%s"/>`, id, name)},
+				tocEdit{p.end - len("</w:p>"), p.end - len("</w:p>"), fmt.Sprintf(`<w:bookmarkEnd w:id="%d"/>`, id)},
+			)
+		}
+		usedBookmarks[name] = struct{}{}
+		bookmarks[i] = name
+
+		return name
+	}
+
+	for _, field := range fields {
+		switches := parseTocSwitches(html.UnescapeString(field.instr.String()))
+
+		entries := []tocEntry{}
+		for i, p := range paragraphs {
+			text := strings.TrimSpace(p.text.String())
+			if p.inTOC || text == "" {
+				continue
+			}
+
+			if level := switches.level(d, p); level > 0 {
+				entries = append(entries, d.newTocEntry(switches, level, text, bookmarkOf(i)))
+			}
+		}
+
+		// the first entry takes the place of the first paragraph, along with the beginning of the field,
+		// and the end of the field closes the last entry unless it has its own paragraph
+		first, last := paragraphs[field.first], paragraphs[field.last]
+		toc := srcXML[first.start:first.contentStart]
+		if len(entries) > 0 {
+			toc = "<w:p>" + entries[0].props
+		}
+		toc += srcXML[first.contentStart:field.separate]
+		for i, entry := range entries {
+			if i > 0 {
+				toc += "<w:p>" + entry.props
+			}
+			toc += entry.runs + "</w:p>"
+		}
+
+		switch {
+		case field.first == field.last && len(entries) > 0:
+			toc = strings.TrimSuffix(toc, "</w:p>")
+		case field.first != field.last && len(entries) == 0:
+			toc += "</w:p>" + srcXML[last.start:last.contentStart]
+		case field.first != field.last:
+			toc += srcXML[last.start:last.contentStart]
+		}
+		toc += srcXML[field.end:last.end]
+
+		edits = append(edits, tocEdit{first.start, last.end, toc})
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	out := strings.Builder{}
+	offset := 0
+	for _, edit := range edits {
+		out.WriteString(srcXML[offset:edit.start])
+		out.WriteString(edit.value)
+		offset = edit.end
+	}
+	out.WriteString(srcXML[offset:])
+
+	return out.String()
+}
+
+// tocEntry is the paragraph properties and the runs of an entry of a table of contents.
+type tocEntry struct {
+	props, runs string
+}
+
+// newTocEntry returns the entry of the given level of a table of contents, with the text
+// of the listed paragraph and the number of its page, targeting the paragraph bookmark.
+func (d *DocumentMeta) newTocEntry(switches tocSwitches, level int, text, bookmark string) tocEntry {
+	style, indent := fmt.Sprintf("TOC%d", level), ""
+	if switches.caption != "" {
+		style = tableOfFiguresStyle
+	}
+	if _, ok := d.paragraphStyles[style]; ok {
+		style = fmt.Sprintf(`<w:pStyle w:val="%s"/>`, style)
+	} else {
+		style = ""
+		if level > 1 {
+			indent = fmt.Sprintf(`<w:ind w:left="%d"/>`, (level-1)*tocIndentTwips)
+		}
+	}
+
+	runs := fmt.Sprintf(DOCX_TOC_ENTRY_TEXT_F, text)
+	if level < switches.noPageMin || level > switches.noPageMax {
+		runs += fmt.Sprintf(DOCX_TOC_ENTRY_PAGE_F, bookmark)
+	}
+	if switches.hyperlinks {
+		runs = fmt.Sprintf(DOCX_TOC_HYPERLINK_F, bookmark, runs)
+	}
+
+	return tocEntry{
+		props: fmt.Sprintf(DOCX_TOC_ENTRY_PPR_F, style, d.MaxWidthTwips(), indent),
+		runs:  runs,
+	}
+}
+
+// parseTocParagraphs returns the paragraphs of the document body, excluding the ones of the
+// text boxes, and the TOC fields beginning and ending in them.
+func parseTocParagraphs(srcXML string) ([]*tocParagraph, []*tocField) {
+	paragraphs := []*tocParagraph{}
+	tocFields := []*tocField{}
+	stack := []*tocField{}
+	var separated *tocField
+
+	current := -1
+	depth, pPrDepth := 0, 0
+	inText, inInstr := false, false
+	runStart, offset := 0, 0
+
+	for _, token := range tokenizeXml(srcXML) {
+		start := offset
+		offset += len(token.value)
+
+		if !token.isTag {
+			switch {
+			case current < 0 || depth != 1:
+			case inText:
+				paragraphs[current].text.WriteString(token.value)
+			case inInstr && len(stack) > 0 && !stack[len(stack)-1].separated:
+				stack[len(stack)-1].instr.WriteString(token.value)
+			}
+			continue
+		}
+
+		name := token.qualifiedName()
+		if name == "w:p" {
+			switch {
+			case token.isOpening():
+				depth++
+				if depth == 1 {
+					current = len(paragraphs)
+					paragraphs = append(paragraphs, &tocParagraph{start: start, contentStart: offset})
+				}
+			case token.isClosing():
+				if depth == 1 {
+					paragraphs[current].end = offset
+				}
+				depth--
+			}
+			continue
+		}
+
+		if current < 0 || depth != 1 {
+			continue
+		}
+		p := paragraphs[current]
+
+		switch name {
+		case "w:pPr":
+			switch {
+			case token.isOpening():
+				pPrDepth++
+			case token.isClosing():
+				pPrDepth--
+				if pPrDepth == 0 {
+					p.contentStart = offset
+				}
+			default:
+				p.contentStart = offset
+			}
+		case "w:pStyle":
+			if pPrDepth == 1 && p.style == "" {
+				if m := styleValAttrRe.FindStringSubmatch(token.value); m != nil {
+					p.style = m[1]
+				}
+			}
+		case "w:outlineLvl":
+			if pPrDepth == 1 && p.outlineLevel == 0 {
+				if level := xmlIntAttr(token.value, xmlValAttrRe, tocLevels) + 1; level <= tocLevels {
+					p.outlineLevel = level
+				}
+			}
+		case "w:bookmarkStart":
+			if m := bookmarkNameRe.FindStringSubmatch(token.value); m != nil && p.bookmark == "" && strings.HasPrefix(m[1], "_Toc") {
+				p.bookmark = m[1]
+			}
+		case "w:r":
+			if token.isOpening() {
+				runStart = start
+			} else if token.isClosing() && separated != nil {
+				separated.separate = offset
+				separated = nil
+			}
+		case "w:t":
+			inText = token.isOpening()
+		case "w:tab", "w:br", "w:cr":
+			if !token.isClosing() {
+				p.text.WriteString(" ")
+			}
+		case "w:instrText":
+			inInstr = token.isOpening()
+		case "w:fldSimple":
+			if m := fldSimpleInstrRe.FindStringSubmatch(token.value); m != nil {
+				if seq := seqFieldRe.FindStringSubmatch(html.UnescapeString(m[1])); seq != nil {
+					p.sequences = append(p.sequences, seq[1])
+				}
+			}
+		case "w:fldChar":
+			m := fldCharTypeAttrRe.FindStringSubmatch(token.value)
+			switch {
+			case m == nil:
+			case m[1] == "begin":
+				stack = append(stack, &tocField{begin: runStart, first: current})
+			case len(stack) == 0:
+			case m[1] == "separate":
+				separated = stack[len(stack)-1]
+				separated.separated = true
+			case m[1] == "end":
+				field := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				field.end, field.last = runStart, current
+
+				instr := html.UnescapeString(field.instr.String())
+				if seq := seqFieldRe.FindStringSubmatch(instr); seq != nil {
+					paragraphs[field.first].sequences = append(paragraphs[field.first].sequences, seq[1])
+				}
+				if len(stack) == 0 && field.separate > field.begin && tocFieldRe.MatchString(instr) {
+					tocFields = append(tocFields, field)
+				}
+			}
+		}
+	}
+
+	return paragraphs, tocFields
+}
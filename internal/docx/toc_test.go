@@ -0,0 +1,118 @@
+package docx
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const testHeadingStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+	`<w:styles xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">` +
+	`<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:pPr><w:outlineLvl w:val="0"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:pPr><w:outlineLvl w:val="1"/></w:pPr></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="Heading3"><w:name w:val="heading 3"/><w:basedOn w:val="Heading2"/></w:style>` +
+	`<w:style w:type="paragraph" w:styleId="TOC1"><w:name w:val="toc 1"/></w:style>` +
+	`</w:styles>`
+
+var (
+	testTocEntryRe  = regexp.MustCompile(`<w:p><w:pPr>(<w:pStyle w:val="\w+"/>)?<w:tabs>.*?</w:tabs>(<w:ind w:left="\d+"/>)?</w:pPr>.*?(<w:hyperlink w:anchor="(\w+)"[^>]*>)?<w:r><w:t xml:space="preserve">([^<]*)</w:t>`)
+	testTocAnchorRe = regexp.MustCompile(`<w:bookmarkStart w:id="\d+" w:name="(_Toc\d+)"/><w:r><w:t>([^<]*)</w:t>`)
+)
+
+// tocFieldParagraph returns a paragraph holding a TOC field with the given instruction, whose result is an outdated entry.
+func tocFieldParagraph(instr string) string {
+	return `<w:p><w:r><w:fldChar w:fldCharType="begin"/></w:r><w:r><w:instrText xml:space="preserve"> ` + instr + ` </w:instrText></w:r>` +
+		`<w:r><w:fldChar w:fldCharType="separate"/></w:r><w:r><w:t>outdated</w:t></w:r><w:r><w:fldChar w:fldCharType="end"/></w:r></w:p>`
+}
+
+// heading returns a paragraph with the given style holding the given text.
+func heading(style, text string) string {
+	return `<w:p><w:pPr><w:pStyle w:val="` + style + `"/></w:pPr><w:r><w:t>` + text + `</w:t></w:r></w:p>`
+}
+
+// tocEntries returns the "style indent #anchor text" of the TOC entries of the given XML.
+func tocEntries(content string) []string {
+	entries := []string{}
+	for _, m := range testTocEntryRe.FindAllStringSubmatch(content, -1) {
+		entries = append(entries, strings.TrimSpace(strings.Join([]string{m[1] + m[2], "#" + m[4], m[5]}, " ")))
+	}
+
+	return entries
+}
+
+func TestTableOfContents(t *testing.T) {
+	data := map[string]any{"Chapters": []string{"One", "Two"}}
+
+	tests := []struct {
+		name    string
+		body    string
+		update  bool
+		entries []string
+		// anchors are the headings texts with a _Toc bookmark
+		anchors    []string
+		paragraphs []string
+	}{
+		{
+			// Heading3 has the outline level of its base style
+			name: "headings of the rendered document",
+			body: tocFieldParagraph(`TOC \o "1-3" \h \z \u`) +
+				`{{range .Chapters}}` + heading("Heading1", "{{.}}") + heading("Heading3", "Part of {{.}}") + `{{end}}` +
+				heading("Heading2", "Annex"),
+			update: true,
+			entries: []string{
+				`<w:pStyle w:val="TOC1"/> #_Toc000000001 One`,
+				`<w:ind w:left="220"/> #_Toc000000002 Part of One`,
+				`<w:pStyle w:val="TOC1"/> #_Toc000000003 Two`,
+				`<w:ind w:left="220"/> #_Toc000000004 Part of Two`,
+				`<w:ind w:left="220"/> #_Toc000000005 Annex`,
+			},
+			anchors:    []string{"One", "Part of One", "Two", "Part of Two", "Annex"},
+			paragraphs: []string{"One", "Part of One", "Two", "Part of Two", "Annex", "One", "Part of One", "Two", "Part of Two", "Annex"},
+		},
+		{
+			name:       "levels range without hyperlinks",
+			body:       tocFieldParagraph(`TOC \o "1-1"`) + heading("Heading1", "Title") + heading("Heading2", "Hidden"),
+			update:     true,
+			entries:    []string{`<w:pStyle w:val="TOC1"/> # Title`},
+			anchors:    []string{"Title"},
+			paragraphs: []string{"Title", "Title", "Hidden"},
+		},
+		{
+			name:       "not updated",
+			body:       tocFieldParagraph(`TOC \o "1-3" \h`) + heading("Heading1", "Title"),
+			entries:    []string{},
+			anchors:    []string{},
+			paragraphs: []string{"outdated", "Title"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDocumentMeta(t, tt.body, map[string]string{"word/styles.xml": testHeadingStyles})
+			d.SetUpdateTableOfContents(tt.update)
+			output := renderTestPart(t, d, TemplateConfig{}, "word/document.xml", testDocument(tt.body), data)
+
+			if got := tocEntries(output); !reflect.DeepEqual(got, tt.entries) {
+				t.Errorf("got entries\n%s\nwant\n%s\n%s", strings.Join(got, "\n"), strings.Join(tt.entries, "\n"), output)
+			}
+
+			anchors := []string{}
+			for _, m := range testTocAnchorRe.FindAllStringSubmatch(output, -1) {
+				anchors = append(anchors, m[2])
+			}
+			if !reflect.DeepEqual(anchors, tt.anchors) {
+				t.Errorf("got bookmarked headings %q, want %q\n%s", anchors, tt.anchors, output)
+			}
+
+			if got := paragraphsTexts(output); !reflect.DeepEqual(got, tt.paragraphs) {
+				t.Errorf("got paragraphs %q, want %q\n%s", got, tt.paragraphs, output)
+			}
+
+			settings := generatedPart(t, d, "word/settings.xml")
+			if settings.Changed != tt.update || tt.update && !strings.Contains(string(settings.Content), DOCX_UPDATE_FIELDS) {
+				t.Errorf("got settings.xml changed %v, want %v\n%s", settings.Changed, tt.update, settings.Content)
+			}
+		})
+	}
+}
@@ -0,0 +1,619 @@
+package docx
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// TrackChanges is the author and the date of the tracked changes marking what the template
+// changed in the rendered documents: the printed values are insertions and the text of the
+// false {{if}} branches is a deletion. A zero Date is the rendering time.
+type TrackChanges struct {
+	Author string
+	Date   time.Time
+}
+
+// TrackInsertionFunc is the template function the printed values are piped to,
+// to mark them as insertions when the changes are tracked.
+const TrackInsertionFunc = "__trackInsertion"
+
+const (
+	// the marks of the inserted values and of the deleted branches, turned into <w:ins> and <w:del> by applyTrackChanges
+	trackInsStart = "[[TRACK_INS_START]]"
+	trackInsEnd   = "[[TRACK_INS_END]]"
+	trackDelStart = "[[TRACK_DEL_START]]"
+	trackDelEnd   = "[[TRACK_DEL_END]]"
+)
+
+var (
+	// trackedPartRe matches the parts whose changes are tracked
+	trackedPartRe = regexp.MustCompile(`^word/(?:document|header\d*|footer\d*)\.xml$`)
+	// untrackedPlaceholderRe matches the placeholders of the values that are not inline content
+	// (e.g. tables and lists replacing their paragraph, cells properties), which are not marked
+	untrackedPlaceholderRe = regexp.MustCompile(`\[\[(?:TABLE|BULLET_LIST|NUMBERED_LIST|SHAPE_BG_FILL_COLOR|REPLACE_IMAGE|TABLE_CELL_\w+):`)
+	leadingTagsRe          = regexp.MustCompile(`^(?:<[^>]*>)*`)
+	trailingTagsRe         = regexp.MustCompile(`(?:<[^>]*>)*$`)
+	textStartTagRe         = regexp.MustCompile(`<w:t(?:\s[^>]*)?>$`)
+	trackMarkRe            = regexp.MustCompile(`\[\[TRACK_(?:INS|DEL)_(?:START|END)\]\]`)
+	trackDelInTagRe        = regexp.MustCompile(`(?s)\[\[TRACK_DEL_START\]\].*?\[\[TRACK_DEL_END\]\]`)
+	emptyTrackedRangeRe    = regexp.MustCompile(`\[\[TRACK_INS_START\]\]\[\[TRACK_INS_END\]\]|\[\[TRACK_DEL_START\]\]\[\[TRACK_DEL_END\]\]`)
+	revisionStartRe        = regexp.MustCompile(`<w:(?:ins|del|moveFrom|moveTo)\b[^>]*>`)
+
+	fmtStringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// emptyRunAroundTrackMarkRe matches the runs left without text by splitRunsAtTrackMarks next to the marks.
+var emptyRunAroundTrackMarkRe = regexp.MustCompile(
+	`<w:r(?:\s[^>]*)?>(?:` + runPropsPattern + `)?(?:<w:t(?:\s[^>]*)?></w:t>)?</w:r>(` + trackMarkRe.String() + `)|` +
+		`(` + trackMarkRe.String() + `)<w:r(?:\s[^>]*)?>(?:` + runPropsPattern + `)?(?:<w:t(?:\s[^>]*)?></w:t>)?</w:r>`)
+
+// trackInsertion marks the printed value as an insertion. The marks are placed inside
+// the text of the runs injected by the template functions (e.g. styledText, hyperlink)
+// so that they don't break their post-processing.
+func trackInsertion(value any) string {
+	s := printedValue(value)
+	if s == "" || untrackedPlaceholderRe.MatchString(s) {
+		return s
+	}
+
+	start := len(leadingTagsRe.FindString(s))
+	if !textStartTagRe.MatchString(s[:start]) {
+		start = 0
+	}
+
+	end := trailingTagsRe.FindStringIndex(s)[0]
+	if !strings.HasPrefix(s[end:], "</w:t>") {
+		end = len(s)
+	}
+
+	// no text (e.g. a footnote reference): the whole value is marked
+	if start >= end {
+		start, end = 0, len(s)
+	}
+
+	return s[:start] + trackInsStart + s[start:end] + trackInsEnd + s[end:]
+}
+
+// printedValue formats the value the way the template execution prints it.
+func printedValue(value any) string {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+
+	if !v.IsValid() {
+		return "<no value>"
+	}
+
+	if !v.Type().Implements(errorType) && !v.Type().Implements(fmtStringerType) && v.CanAddr() {
+		if ptr := reflect.PointerTo(v.Type()); ptr.Implements(errorType) || ptr.Implements(fmtStringerType) {
+			v = v.Addr()
+		}
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+// trackChangesRewriter rewrites the printing actions to pipe their value to TrackInsertionFunc,
+// and the {{if}} branches to print the text of the branch not taken as a deletion.
+type trackChangesRewriter struct {
+	tree *parse.Tree
+}
+
+// rewriteTrackChanges marks the changes made by the parsed template, if they are tracked.
+func (c TemplateConfig) rewriteTrackChanges(tmpl *template.Template) {
+	if !c.TrackChanges || !trackedPartRe.MatchString(tmpl.Name()) {
+		return
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil || t.Tree.Root == nil {
+			continue
+		}
+
+		r := trackChangesRewriter{tree: t.Tree}
+		r.list(t.Tree.Root)
+	}
+}
+
+func (r *trackChangesRewriter) list(list *parse.ListNode) {
+	if list == nil {
+		return
+	}
+
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.ActionNode:
+			r.action(n)
+		case *parse.IfNode:
+			r.ifNode(n)
+		case *parse.RangeNode:
+			r.list(n.List)
+			r.list(n.ElseList)
+		case *parse.WithNode:
+			r.list(n.List)
+			r.list(n.ElseList)
+		}
+	}
+}
+
+// action pipes the value printed by the action to TrackInsertionFunc.
+func (r *trackChangesRewriter) action(n *parse.ActionNode) {
+	if len(n.Pipe.Decl) != 0 || len(n.Pipe.Cmds) == 0 {
+		return
+	}
+
+	// the actions generated by expandColRanges print the widths of the cells
+	if ident, ok := n.Pipe.Cmds[0].Args[0].(*parse.IdentifierNode); ok {
+		if _, ok := colRangeFuncs[ident.Ident]; ok {
+			return
+		}
+	}
+
+	n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      n.Pos,
+		Args:     []parse.Node{parse.NewIdentifier(TrackInsertionFunc).SetTree(r.tree).SetPos(n.Pos)},
+	})
+}
+
+// ifNode appends the text of the else branch to the if branch as a deletion, and the other way around.
+// The branches whose text doesn't hold balanced XML elements (e.g. a run split by an inline {{if}}
+// whose branches open different elements) are left as they are.
+func (r *trackChangesRewriter) ifNode(n *parse.IfNode) {
+	ifText := staticText(n.List)
+	elseText := staticText(n.ElseList)
+
+	r.list(n.List)
+	r.list(n.ElseList)
+
+	if xmlDepthDelta(ifText) != 0 || xmlDepthDelta(elseText) != 0 {
+		return
+	}
+
+	if elseText != "" {
+		n.List.Nodes = append(n.List.Nodes, r.textNode(trackDelStart+elseText+trackDelEnd, n.Pos))
+	}
+
+	if ifText != "" {
+		if n.ElseList == nil {
+			n.ElseList = &parse.ListNode{NodeType: parse.NodeList, Pos: n.Pos}
+		}
+		n.ElseList.Nodes = append([]parse.Node{r.textNode(trackDelStart+ifText+trackDelEnd, n.Pos)}, n.ElseList.Nodes...)
+	}
+}
+
+func (r *trackChangesRewriter) textNode(text string, pos parse.Pos) *parse.TextNode {
+	return &parse.TextNode{
+		NodeType: parse.NodeText,
+		Pos:      pos,
+		Text:     []byte(text),
+	}
+}
+
+// staticText returns the text of the list and of its nested blocks, without the actions.
+func staticText(list *parse.ListNode) string {
+	if list == nil {
+		return ""
+	}
+
+	sb := strings.Builder{}
+	for _, node := range list.Nodes {
+		switch n := node.(type) {
+		case *parse.TextNode:
+			sb.Write(n.Text)
+		case *parse.IfNode:
+			sb.WriteString(staticText(n.List))
+			sb.WriteString(staticText(n.ElseList))
+		case *parse.RangeNode:
+			sb.WriteString(staticText(n.List))
+			sb.WriteString(staticText(n.ElseList))
+		case *parse.WithNode:
+			sb.WriteString(staticText(n.List))
+			sb.WriteString(staticText(n.ElseList))
+		}
+	}
+
+	return sb.String()
+}
+
+// xmlDepthDelta returns the number of elements opened by the XML source minus the closed ones.
+func xmlDepthDelta(srcXML string) int {
+	delta := 0
+	for _, token := range tokenizeXml(srcXML) {
+		switch {
+		case token.isOpening():
+			delta++
+		case token.isClosing():
+			delta--
+		}
+	}
+
+	return delta
+}
+
+// SetTrackChanges sets the author and the date of the tracked changes,
+// an empty author means that the changes are not tracked.
+func (d *DocumentMeta) SetTrackChanges(changes TrackChanges) {
+	d.trackChanges = changes
+}
+
+// parseRevisions collects the greatest id of the revisions of the XML part,
+// so that the tracked changes don't collide with them.
+func (d *DocumentMeta) parseRevisions(srcXML string) error {
+	for _, tag := range revisionStartRe.FindAllString(srcXML, -1) {
+		m := bookmarkIdAttrRe.FindStringSubmatch(tag)
+		if m == nil {
+			continue
+		}
+
+		id, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("could not parse revision id '%s': %w", m[1], err)
+		}
+
+		if id > d.greaterRevisionId {
+			d.greaterRevisionId = id
+		}
+	}
+
+	return nil
+}
+
+// revision returns the element marking a new revision of the given kind ("ins" or "del"),
+// self-closing or not.
+func (d *DocumentMeta) revision(kind, date string, selfClosing bool) string {
+	d.greaterRevisionId++
+
+	tag := fmt.Sprintf(`<w:%s w:id="%d" w:author="%s" w:date="%s"`,
+		kind, d.greaterRevisionId, xmlTextEscaper.Replace(d.trackChanges.Author), date)
+	if selfClosing {
+		return tag + "/>"
+	}
+
+	return tag + ">"
+}
+
+// applyTrackChanges turns the marks of the inserted values and of the deleted {{if}} branches
+// into tracked changes: their runs are wrapped into <w:ins> and <w:del> elements, and the
+// paragraphs ending inside them get their mark inserted or deleted, as the table rows deleted whole.
+// A paragraph deleted whole at the end of its container gets the mark of the previous paragraph deleted.
+func (d *DocumentMeta) applyTrackChanges(srcXML string) string {
+	if d.trackChanges.Author == "" || !trackMarkRe.MatchString(srcXML) {
+		return srcXML
+	}
+
+	date := d.trackChanges.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	dateAttr := date.UTC().Format(commentDateLayout)
+
+	srcXML = splitRunsAtTrackMarks(srcXML)
+
+	type runFrame struct {
+		kind string
+	}
+
+	type paragraphFrame struct {
+		start int
+		// deleted reports whether the whole paragraph is in a deleted branch
+		deleted bool
+	}
+
+	type rowFrame struct {
+		start   int
+		deleted bool
+	}
+
+	tokens := tokenizeXml(srcXML)
+	out := make([]byte, 0, len(srcXML)+len(srcXML)/8)
+
+	kind := ""
+	revisions := 0
+	runs := []runFrame{}
+	paragraphs := []paragraphFrame{}
+	// previous is the last paragraph closed, -1 if none
+	previous := struct{ start, end int }{-1, -1}
+	rows := []rowFrame{}
+
+	wrapped := func() bool {
+		for _, run := range runs {
+			if run.kind != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i, token := range tokens {
+		if !token.isTag {
+			text := token.value
+			if trackMarkRe.MatchString(text) {
+				for _, mark := range trackMarkRe.FindAllString(text, -1) {
+					switch mark {
+					case trackInsStart:
+						kind = "ins"
+					case trackDelStart:
+						kind = "del"
+					default:
+						kind = ""
+					}
+				}
+				text = trackMarkRe.ReplaceAllString(text, "")
+			}
+			out = append(out, text...)
+			continue
+		}
+
+		value := token.value
+		if trackMarkRe.MatchString(value) {
+			// the values printed inside the attributes are left as they are
+			value = trackDelInTagRe.ReplaceAllString(value, "")
+			value = trackMarkRe.ReplaceAllString(value, "")
+		}
+
+		name := xmlToken{value: value, isTag: true}.qualifiedName()
+		opening := strings.HasPrefix(value, "<") && !strings.HasPrefix(value, "</") && !strings.HasSuffix(value, "/>")
+		closing := strings.HasPrefix(value, "</")
+
+		switch name {
+		case "w:ins", "w:del", "w:moveFrom", "w:moveTo":
+			if opening {
+				revisions++
+			} else if closing {
+				revisions--
+			}
+		case "w:r":
+			if opening {
+				frame := runFrame{}
+				if kind != "" && revisions == 0 && !wrapped() {
+					frame.kind = kind
+					out = append(out, d.revision(kind, dateAttr, false)...)
+				}
+				runs = append(runs, frame)
+				out = append(out, value...)
+				continue
+			}
+
+			if closing && len(runs) > 0 {
+				frame := runs[len(runs)-1]
+				runs = runs[:len(runs)-1]
+				out = append(out, value...)
+				if frame.kind != "" {
+					out = append(out, "</w:"+frame.kind+">"...)
+				}
+				continue
+			}
+		case "w:t", "w:instrText":
+			// the text of the deleted runs is deleted text
+			if len(runs) > 0 && runs[len(runs)-1].kind == "del" {
+				deleted := "w:delText"
+				if name == "w:instrText" {
+					deleted = "w:delInstrText"
+				}
+				value = strings.Replace(value, name, deleted, 1)
+			}
+		case "w:p":
+			if opening {
+				paragraphs = append(paragraphs, paragraphFrame{start: len(out), deleted: kind == "del"})
+			} else if closing && len(paragraphs) > 0 {
+				paragraph := paragraphs[len(paragraphs)-1]
+				paragraphs = paragraphs[:len(paragraphs)-1]
+				switch {
+				case kind != "" && !isLastInContainer(tokens, i):
+					marked := markParagraph(string(out[paragraph.start:]), d.revision(kind, dateAttr, true))
+					out = append(out[:paragraph.start], marked...)
+				case paragraph.deleted && kind == "del" && previous.end == paragraph.start:
+					// the mark of the last paragraph of its container can't be deleted, the one of the
+					// previous paragraph is, so that the deleted paragraph doesn't remain once accepted
+					marked := markParagraph(string(out[previous.start:previous.end]), d.revision("del", dateAttr, true))
+					out = append(append(out[:previous.start:previous.start], marked...), out[previous.end:]...)
+					paragraph.start += len(marked) - (previous.end - previous.start)
+				}
+
+				out = append(out, value...)
+				previous.start, previous.end = paragraph.start, len(out)
+				continue
+			}
+		case "w:tr":
+			if opening {
+				rows = append(rows, rowFrame{start: len(out), deleted: kind == "del"})
+			} else if closing && len(rows) > 0 {
+				row := rows[len(rows)-1]
+				rows = rows[:len(rows)-1]
+				if row.deleted && kind == "del" {
+					marked := markRow(string(out[row.start:]), d.revision("del", dateAttr, true))
+					out = append(out[:row.start], marked...)
+				}
+			}
+		}
+
+		out = append(out, value...)
+	}
+
+	return string(out)
+}
+
+// splitRunsAtTrackMarks moves the marks out of the runs, splitting them into runs with the same properties.
+// The texts injected with their own properties (e.g. by styledText) already have their own run,
+// split by flattenNestedTextRuns, so their properties are inside the marks.
+func splitRunsAtTrackMarks(srcXML string) string {
+	type runFrame struct {
+		open       string
+		props      string
+		propsDepth int
+		inner      []xmlToken
+	}
+
+	sb := strings.Builder{}
+	runs := []*runFrame{}
+
+	for _, token := range tokenizeXml(srcXML) {
+		if len(runs) == 0 {
+			if token.isOpening() && token.qualifiedName() == "w:r" {
+				runs = append(runs, &runFrame{open: token.value})
+			}
+			sb.WriteString(token.value)
+			continue
+		}
+
+		run := runs[len(runs)-1]
+		switch {
+		case token.isTag && token.qualifiedName() == "w:r" && token.isOpening():
+			runs = append(runs, &runFrame{open: token.value})
+		case token.isTag && token.qualifiedName() == "w:r" && token.isClosing():
+			runs = runs[:len(runs)-1]
+		case run.propsDepth > 0 || (token.isOpening() && token.qualifiedName() == "w:rPr" && run.props == "" && len(run.inner) == 0):
+			// the properties of the run, copied on the runs split from it
+			run.props += token.value
+			if token.isOpening() && token.qualifiedName() == "w:rPr" {
+				run.propsDepth++
+			} else if token.isClosing() && token.qualifiedName() == "w:rPr" {
+				run.propsDepth--
+			}
+		case token.isOpening():
+			run.inner = append(run.inner, token)
+		case token.isClosing():
+			if len(run.inner) > 0 {
+				run.inner = run.inner[:len(run.inner)-1]
+			}
+		case !token.isTag && trackMarkRe.MatchString(token.value):
+			last := 0
+			for _, loc := range trackMarkRe.FindAllStringIndex(token.value, -1) {
+				sb.WriteString(token.value[last:loc[0]])
+				for k := len(run.inner) - 1; k >= 0; k-- {
+					sb.WriteString("</" + run.inner[k].qualifiedName() + ">")
+				}
+				sb.WriteString("</w:r>" + token.value[loc[0]:loc[1]] + run.open + run.props)
+				for _, inner := range run.inner {
+					sb.WriteString(inner.value)
+				}
+				last = loc[1]
+			}
+			sb.WriteString(token.value[last:])
+			continue
+		}
+
+		sb.WriteString(token.value)
+	}
+
+	srcXML = sb.String()
+	for {
+		next := emptyRunAroundTrackMarkRe.ReplaceAllString(srcXML, "${1}${2}")
+		next = emptyTrackedRangeRe.ReplaceAllString(next, "")
+		if next == srcXML {
+			break
+		}
+		srcXML = next
+	}
+
+	return srcXML
+}
+
+// isLastInContainer reports whether the i-th token closes the last paragraph of its
+// container (e.g. a table cell or the body), whose mark can't be changed.
+func isLastInContainer(tokens []xmlToken, i int) bool {
+	for _, token := range tokens[i+1:] {
+		if !token.isTag {
+			continue
+		}
+
+		return token.isClosing() || token.qualifiedName() == "w:sectPr"
+	}
+
+	return true
+}
+
+// markParagraph adds the revision mark to the properties of the paragraph mark.
+func markParagraph(paragraph, mark string) string {
+	tokens := tokenizeXml(paragraph)
+	if len(tokens) < 2 {
+		return paragraph
+	}
+
+	head := len(tokens[0].value)
+	pPr := tokens[1]
+	switch {
+	case pPr.qualifiedName() != "w:pPr":
+		return paragraph[:head] + "<w:pPr><w:rPr>" + mark + "</w:rPr></w:pPr>" + paragraph[head:]
+	case !pPr.isOpening():
+		return paragraph[:head] + "<w:pPr><w:rPr>" + mark + "</w:rPr></w:pPr>" + paragraph[head+len(pPr.value):]
+	}
+
+	offset := head
+	depth := 0
+	for k := 1; k < len(tokens); k++ {
+		token := tokens[k]
+		if depth == 1 {
+			switch name := token.qualifiedName(); {
+			case name == "w:rPr" && token.isOpening():
+				// the paragraph mark is already a revision
+				if k+1 < len(tokens) && revisionStartRe.MatchString(tokens[k+1].value) {
+					return paragraph
+				}
+				rPr := offset + len(token.value)
+				return paragraph[:rPr] + mark + paragraph[rPr:]
+			case name == "w:rPr" && token.isTag:
+				return paragraph[:offset] + "<w:rPr>" + mark + "</w:rPr>" + paragraph[offset+len(token.value):]
+			case name == "w:sectPr", name == "w:pPrChange", name == "w:pPr" && token.isClosing():
+				return paragraph[:offset] + "<w:rPr>" + mark + "</w:rPr>" + paragraph[offset:]
+			}
+		}
+
+		if token.isOpening() {
+			depth++
+		} else if token.isClosing() {
+			depth--
+		}
+		offset += len(token.value)
+	}
+
+	return paragraph
+}
+
+// markRow adds the revision mark to the properties of the table row.
+func markRow(row, mark string) string {
+	tokens := tokenizeXml(row)
+	if len(tokens) < 2 {
+		return row
+	}
+
+	offset := len(tokens[0].value)
+	k := 1
+	if tokens[k].isOpening() && tokens[k].qualifiedName() == "w:tblPrEx" {
+		for ; k < len(tokens) && !(tokens[k].isClosing() && tokens[k].qualifiedName() == "w:tblPrEx"); k++ {
+			offset += len(tokens[k].value)
+		}
+		if k == len(tokens) {
+			return row
+		}
+		offset += len(tokens[k].value)
+		k++
+	}
+
+	if k == len(tokens) || tokens[k].qualifiedName() != "w:trPr" {
+		return row[:offset] + "<w:trPr>" + mark + "</w:trPr>" + row[offset:]
+	}
+	if !tokens[k].isOpening() {
+		return row[:offset] + "<w:trPr>" + mark + "</w:trPr>" + row[offset+len(tokens[k].value):]
+	}
+
+	for ; k < len(tokens); k++ {
+		if name := tokens[k].qualifiedName(); name == "w:trPrChange" || name == "w:trPr" && tokens[k].isClosing() {
+			return row[:offset] + mark + row[offset:]
+		}
+		offset += len(tokens[k].value)
+	}
+
+	return row
+}
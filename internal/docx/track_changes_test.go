@@ -0,0 +1,117 @@
+package docx
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackChangesKeepStyledText(t *testing.T) {
+	styled, err := styledText("Bob", []interface{}{"b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inlineStyled, err := inlineStyledText("Bob", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, paragraph, want string
+	}{
+		{
+			name:      "styledText",
+			paragraph: `<w:p><w:r><w:rPr><w:i/></w:rPr><w:t>` + trackInsertion(styled) + `</w:t></w:r></w:p>`,
+			want: `<w:p><w:ins w:id="1" w:author="A" w:date="2026-01-02T03:04:05Z">` +
+				`<w:r><w:rPr><w:i/><w:b /><w:bCs /></w:rPr><w:t>Bob</w:t></w:r></w:ins></w:p>`,
+		},
+		{
+			name:      "inlineStyledText",
+			paragraph: `<w:p><w:r><w:rPr><w:i/></w:rPr><w:t xml:space="preserve">Hello ` + trackInsertion(inlineStyled) + ` world</w:t></w:r></w:p>`,
+			want: `<w:p><w:r><w:rPr><w:i/></w:rPr><w:t xml:space="preserve">Hello </w:t></w:r>` +
+				`<w:ins w:id="1" w:author="A" w:date="2026-01-02T03:04:05Z"><w:r><w:rPr><w:i/><w:b /><w:bCs /></w:rPr><w:t>Bob</w:t></w:r></w:ins>` +
+				`<w:r><w:rPr><w:i/></w:rPr><w:t xml:space="preserve"> world</w:t></w:r></w:p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DocumentMeta{
+				bookmarkNames: map[string]struct{}{},
+				bookmarks:     map[string][]string{},
+				trackChanges:  TrackChanges{Author: "A", Date: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+			}
+
+			output, _, err := d.ProcessOutput("word/document.xml", []byte(`<w:body>`+tt.paragraph+`</w:body>`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := strings.TrimSuffix(strings.TrimPrefix(string(output), "<w:body>"), "</w:body>")
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackChangesDeletedParagraphs(t *testing.T) {
+	const (
+		del     = `<w:del w:id="%d" w:author="A" w:date="2026-01-02T03:04:05Z">`
+		delMark = `<w:pPr><w:rPr><w:del w:id="%d" w:author="A" w:date="2026-01-02T03:04:05Z"/></w:rPr></w:pPr>`
+	)
+
+	deleted := func(markId, runId int, text string) string {
+		mark := ""
+		if markId > 0 {
+			mark = fmt.Sprintf(delMark, markId)
+		}
+		return `<w:p>` + mark + fmt.Sprintf(del, runId) + `<w:r><w:delText xml:space="preserve">` + text + `</w:delText></w:r></w:del></w:p>`
+	}
+	kept := func(mark, text string) string {
+		return `<w:p>` + mark + `<w:r><w:t xml:space="preserve">` + text + `</w:t></w:r></w:p>`
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "paragraph followed by another one",
+			body: p("{{if .X}}") + p("deleted") + p("{{end}}") + p("kept"),
+			want: deleted(2, 1, "deleted") + kept("", "kept"),
+		},
+		{
+			name: "last paragraph of the body",
+			body: p("kept") + p("{{if .X}}") + p("deleted") + p("{{end}}"),
+			want: kept(fmt.Sprintf(delMark, 2), "kept") + deleted(0, 1, "deleted"),
+		},
+		{
+			name: "last paragraph of a table cell",
+			body: tbl([]int{2000}, tr(tc(p("kept"), p("{{if .X}}"), p("deleted"), p("{{end}}")))),
+			want: `<w:tbl><w:tblPr><w:tblW w:w="0" w:type="auto"/></w:tblPr><w:tblGrid><w:gridCol w:w="2000"/></w:tblGrid><w:tr><w:tc>` +
+				`<w:tcPr><w:tcW w:w="2000" w:type="dxa"/></w:tcPr>` + kept(fmt.Sprintf(delMark, 2), "kept") + deleted(0, 1, "deleted") + `</w:tc></w:tr></w:tbl>`,
+		},
+		{
+			name: "else branch",
+			body: p("{{if .X}}") + p("deleted") + p("{{else}}") + p("inserted") + p("{{end}}"),
+			want: deleted(2, 1, "deleted") + kept("", "inserted"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDocumentMeta(t, tt.body, nil)
+			d.SetTrackChanges(TrackChanges{Author: "A", Date: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)})
+
+			output := renderTestPart(t, d, TemplateConfig{TrackChanges: true}, "word/document.xml", testDocument(tt.body), map[string]any{"X": false})
+
+			got := output[strings.Index(output, "<w:body>")+len("<w:body>") : strings.Index(output, "<w:sectPr>")]
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package docx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizedExpression is a template expression in which the typographic characters
+// inserted by Word's AutoFormat (e.g. the smart quotes) were replaced by their ASCII equivalents.
+type NormalizedExpression struct {
+	// Part is the docx file part containing the expression (e.g. "word/document.xml")
+	Part string
+	// Original is the expression as written in the document (e.g. {{bold “text”}})
+	Original string
+	// Normalized is the expression as parsed (e.g. {{bold "text"}})
+	Normalized string
+}
+
+func (n NormalizedExpression) String() string {
+	return fmt.Sprintf("typographic characters replaced in file '%s': %s became %s", n.Part, n.Original, n.Normalized)
+}
+
+// typographicReplacements maps the typographic characters to their ASCII equivalents.
+var typographicReplacements = map[rune]string{
+	'\u201C': `"`,   // left double quotation mark
+	'\u201D': `"`,   // right double quotation mark
+	'\u201E': `"`,   // double low-9 quotation mark
+	'\u201F': `"`,   // double high-reversed-9 quotation mark
+	'\u2018': `'`,   // left single quotation mark
+	'\u2019': `'`,   // right single quotation mark (typographic apostrophe)
+	'\u201A': `'`,   // single low-9 quotation mark
+	'\u201B': `'`,   // single high-reversed-9 quotation mark
+	'\u2013': `-`,   // en dash
+	'\u2014': `-`,   // em dash
+	'\u00A0': ` `,   // non-breaking space
+	'\u202F': ` `,   // narrow non-breaking space
+	'\u2026': `...`, // horizontal ellipsis
+}
+
+// isTypographicDoubleQuote reports whether r is a double quote replaced by AutoFormat.
+func isTypographicDoubleQuote(r rune) bool {
+	return r == '\u201C' || r == '\u201D' || r == '\u201E' || r == '\u201F'
+}
+
+// hasFollowingQuote reports whether a double quote of the given kind, typographic or ASCII,
+// follows the position i of runes.
+func hasFollowingQuote(runes []rune, i int, typographic bool) bool {
+	for _, r := range runes[i+1:] {
+		if typographic && isTypographicDoubleQuote(r) || !typographic && r == '"' {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeTypography replaces the typographic characters of the code of a template action
+// with their ASCII equivalents. The content of the string literals is left untouched,
+// but for the typographic quotes delimiting them and the ASCII quotes they contain, which are escaped.
+// Since AutoFormat may replace only one of the quotes of a literal, a double quote of the other kind
+// closes it when no quote of the opening kind follows in the action.
+func normalizeTypography(code string) string {
+	var sb strings.Builder
+
+	// the quote closing the current string literal, 0 outside string literals
+	var closing rune
+	escaped := false
+	runes := []rune(code)
+	for i, r := range runes {
+		switch {
+		case closing == 0:
+			if isTypographicDoubleQuote(r) {
+				closing = '\u201D'
+			} else if r == '"' || r == '`' {
+				closing = r
+			}
+
+			if replacement, ok := typographicReplacements[r]; ok {
+				sb.WriteString(replacement)
+				continue
+			}
+		case escaped:
+			escaped = false
+			// an escaped quote in a literal delimited by typographic quotes
+			if closing == '\u201D' && isTypographicDoubleQuote(r) {
+				sb.WriteByte('"')
+				continue
+			}
+		case r == '\\' && closing != '`':
+			escaped = true
+		case closing == '\u201D' && isTypographicDoubleQuote(r):
+			closing = 0
+			sb.WriteByte('"')
+			continue
+		case closing == '\u201D' && r == '"':
+			if !hasFollowingQuote(runes, i, true) {
+				closing = 0
+				break
+			}
+
+			sb.WriteString(`\"`)
+			continue
+		case closing == '"' && r == '\u201D' && !hasFollowingQuote(runes, i, false):
+			closing = 0
+			sb.WriteByte('"')
+			continue
+		case closing == r:
+			closing = 0
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
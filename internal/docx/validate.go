@@ -0,0 +1,204 @@
+package docx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// maxValidationErrorsPerPart bounds the number of times a part is parsed or
+// executed again while collecting its errors.
+const maxValidationErrorsPerPart = 1000
+
+// ParseAll is like Parse but collects all the parsing errors: the expressions that
+// can't be parsed are reported and removed from the source, which is parsed again.
+// The returned template is nil if the part can't be parsed even without them
+// (e.g. a {{range}} missing its {{end}}). The expressions whose typographic characters
+// were normalized are returned too.
+func (c TemplateConfig) ParseAll(name, content string) (*Template, []error, []NormalizedExpression) {
+	errs := []error{}
+	source, locations, normalized := c.patch(name, content)
+
+	for i := 0; i < maxValidationErrorsPerPart; i++ {
+		tmpl, templateErr := c.parse(name, source, locations)
+		if templateErr == nil {
+			return tmpl, errs, normalized
+		}
+		errs = append(errs, templateErr)
+
+		// only remove the expression if it is broken on its own
+		start := strings.Index(source, templateErr.Expression)
+		if templateErr.Expression == "" || start < 0 || isControlAction(templateErr.Expression, c.delims()) {
+			break
+		}
+
+		// the invalid namespaced calls are only detected once parsed
+		var namespaceErr *namespaceError
+		if _, err := template.New(name).Delims(c.delims().Left, c.delims().Right).Funcs(c.parseFuncs()).Parse(templateErr.Expression); err == nil && !errors.As(templateErr, &namespaceErr) {
+			break
+		}
+
+		source = source[:start] + source[start+len(templateErr.Expression):]
+		locations = locations.remove(start, len(templateErr.Expression))
+	}
+
+	return nil, errs, normalized
+}
+
+// isControlAction reports whether the action opens or closes a block, removing it
+// would unbalance the template.
+func isControlAction(action string, d Delims) bool {
+	m := d.actionKeywordRe().FindStringSubmatch(action)
+	if m == nil {
+		return false
+	}
+
+	switch m[1] {
+	case "if", "range", "with", "define", "block", "end", "else":
+		return true
+	}
+
+	return false
+}
+
+// ValidateXmlTemplate is like ExecuteXmlTemplate but collects all the execution errors:
+// each time the execution fails, the failing action is removed from the parsed
+// template and the execution restarts. It modifies tmpl, so tmpl must not be shared.
+// The returned output is nil if the execution could not be completed even without the
+// failing actions, the returned error is not nil only if it can't go on (e.g. ctx is done).
+func ValidateXmlTemplate(ctx context.Context, tmpl *Template, data any, maxSize int64) ([]byte, []error, error) {
+	errs := []error{}
+
+	for i := 0; i < maxValidationErrorsPerPart; i++ {
+		output, err := ExecuteXmlTemplate(ctx, tmpl, data, maxSize)
+		if err == nil {
+			if output == nil {
+				output = []byte{}
+			}
+
+			return output, errs, nil
+		}
+
+		var templateErr *TemplateError
+		if !errors.As(err, &templateErr) {
+			return nil, errs, err
+		}
+		errs = append(errs, templateErr)
+
+		var execErr template.ExecError
+		offset := -1
+		if errors.As(templateErr.Err, &execErr) {
+			if m := execCallerRe.FindStringSubmatch(execErr.Error()); m != nil {
+				offset = execErrorOffset(tmpl.source, m)
+			}
+		}
+
+		if offset < 0 || !removeNodeAt(tmpl.Tree.Root, parse.Pos(offset)) {
+			return nil, errs, nil
+		}
+	}
+
+	return nil, errs, nil
+}
+
+// removeNodeAt removes from the tree the innermost action, or block
+// whose pipeline is failing, that starts at or before pos.
+func removeNodeAt(list *parse.ListNode, pos parse.Pos) bool {
+	if list == nil {
+		return false
+	}
+
+	i := -1
+	for j, node := range list.Nodes {
+		if node.Position() > pos {
+			break
+		}
+		i = j
+	}
+	if i < 0 {
+		return false
+	}
+
+	var branch *parse.BranchNode
+	switch n := list.Nodes[i].(type) {
+	case *parse.IfNode:
+		branch = &n.BranchNode
+	case *parse.RangeNode:
+		branch = &n.BranchNode
+	case *parse.WithNode:
+		branch = &n.BranchNode
+	case *parse.TextNode:
+		return false
+	}
+
+	if branch != nil {
+		if branch.ElseList != nil && len(branch.ElseList.Nodes) > 0 && branch.ElseList.Nodes[0].Position() <= pos {
+			return removeNodeAt(branch.ElseList, pos)
+		}
+
+		if branch.List != nil && len(branch.List.Nodes) > 0 && branch.List.Nodes[0].Position() <= pos {
+			return removeNodeAt(branch.List, pos)
+		}
+	}
+
+	list.Nodes = append(list.Nodes[:i], list.Nodes[i+1:]...)
+
+	return true
+}
+
+// MediaCheckedFuncs returns versions of the image and replaceImage template functions
+// failing when the filename is not a loaded media, used to validate templates.
+func MediaCheckedFuncs(mm MediaMap) template.FuncMap {
+	checked := func(funcName string, fn func(string) string) func(string) (string, error) {
+		return func(filename string) (string, error) {
+			if _, ok := mm[filename]; !ok {
+				return "", fmt.Errorf("func '%s': filename '%s' not found in loaded medias", funcName, filename)
+			}
+
+			return fn(filename), nil
+		}
+	}
+
+	return template.FuncMap{
+		"image":        checked("image", image),
+		"replaceImage": checked("replaceImage", replaceImage),
+	}
+}
+
+var unresolvedPlaceholderRe = regexp.MustCompile(`\[\[(IMAGE|REPLACE_IMAGE|SHAPE_BG_FILL_COLOR|TABLE_CELL_BG_COLOR|TABLE_CELL_GRID_SPAN|TABLE_CELL_VMERGE|TABLE_CELL_VMERGE_BY|TABLE|BULLET_LIST|NUMBERED_LIST|HYPERLINK|LINK_TO|BOOKMARK|FOOTNOTE|ENDNOTE|COMMENT|SECTION_BREAK):([^\]]*)\]\]`)
+
+// placeholderFuncs maps each placeholder to the template function generating it.
+var placeholderFuncs = map[string]string{
+	"IMAGE":                "image",
+	"REPLACE_IMAGE":        "replaceImage",
+	"SHAPE_BG_FILL_COLOR":  "shapeBgFillColor",
+	"TABLE_CELL_BG_COLOR":  "tableCellBgColor",
+	"TABLE_CELL_GRID_SPAN": "mergeCols",
+	"TABLE_CELL_VMERGE":    "mergeRows",
+	"TABLE_CELL_VMERGE_BY": "mergeRowsBy",
+	"TABLE":                "table",
+	"BULLET_LIST":          "bulletList",
+	"NUMBERED_LIST":        "numberedList",
+	"HYPERLINK":            "hyperlink",
+	"LINK_TO":              "linkTo",
+	"BOOKMARK":             "bookmark",
+	"FOOTNOTE":             "footnote",
+	"ENDNOTE":              "endnote",
+	"COMMENT":              "comment",
+	"SECTION_BREAK":        "sectionBreak",
+}
+
+// UnresolvedPlaceholders returns an error for each placeholder left in the processed output
+// of a part, e.g. a replaceImage used outside of an image alt text.
+func UnresolvedPlaceholders(name string, output []byte) []error {
+	errs := []error{}
+	for _, m := range unresolvedPlaceholderRe.FindAllSubmatch(output, -1) {
+		errs = append(errs, fmt.Errorf("unresolved %s(\"%s\") in file '%s': check that it is used where the function is supported", placeholderFuncs[string(m[1])], m[2], name))
+	}
+
+	return errs
+}
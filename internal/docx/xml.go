@@ -7,24 +7,24 @@ import (
 
 // PatchXml removes automatically insert content between template expressions
 // (EG: "{{ .Text }}" could have correctors highlights tags separating the expressions tokens).
-func PatchXml(srcXml string) string {
-	// Fix separated {{
-	re := regexp.MustCompile(`\{([^\}]*?)\{`)
-	srcXml = re.ReplaceAllString(srcXml, "{{")
-
-	// Fix separated }}
-	re = regexp.MustCompile(`\}([^\{]*?)\}`)
-	srcXml = re.ReplaceAllString(srcXml, "}}")
-
-	// Remove unnecessary XML tags inside template expressions and unescape XML entities
-	re = regexp.MustCompile(`\{\{[\s\S]*?\}\}`)
-	matches := re.FindAllString(srcXml, -1)
-	for _, match := range matches {
-		xmlRegex := regexp.MustCompile(`(<\s*\/?[\w-:.]+(\s+[^>]*?)?[\s\/]*>)`)
-		templateText := xmlRegex.ReplaceAllString(match, "")
+// The template expressions are found with the given delimiters, the typographic characters
+// inserted by Word inside them (e.g. smart quotes) are replaced and returned as normalized.
+func PatchXml(srcXml string, d Delims) (patched string, normalized []NormalizedExpression) {
+	patched, normalized, _ = patchXml(srcXml, d, 0)
+
+	return patched, normalized
+}
+
+// patchXml implements PatchXml, the tables with a column loop are shrunk to fit maxTableWidth
+// twips once rendered (0 means no limit). The returned locations map the patched source to srcXml.
+func patchXml(srcXml string, d Delims, maxTableWidth int) (patched string, normalized []NormalizedExpression, locations sourceMap) {
+	d = d.orDefault()
+
+	// patchAction returns the template text of an action found in the XML source
+	patchAction := func(action string) string {
 		// Unescape common XML/HTML entities that Word may inject inside attributes
 		// (e.g., {{shapeBgFillColor (index .Map &quot;Color2&quot;)}})
-		templateText = strings.NewReplacer(
+		templateText := strings.NewReplacer(
 			"&quot;", "\"",
 			"&#34;", "\"",
 			"&apos;", "'",
@@ -36,11 +36,37 @@ func PatchXml(srcXml string) string {
 			// &amp; MUST be last to avoid double-unescaping
 			"&amp;", "&",
 			"&#38;", "&",
-		).Replace(templateText)
+		).Replace(action)
+
+		// Word AutoFormat smart quotes, dashes... break the template syntax
+		if strings.HasPrefix(templateText, d.Left) && strings.HasSuffix(templateText, d.Right) && len(templateText) >= len(d.Left)+len(d.Right) {
+			code := templateText[len(d.Left) : len(templateText)-len(d.Right)]
+			if normalizedCode := normalizeTypography(code); normalizedCode != code {
+				normalized = append(normalized, NormalizedExpression{
+					Original:   templateText,
+					Normalized: d.action(normalizedCode),
+				})
+				templateText = d.action(normalizedCode)
+			}
+		}
 
-		srcXml = strings.ReplaceAll(srcXml, match, templateText)
+		return templateText
 	}
 
+	actionRe := d.xmlActionRe()
+	tokens := tokenizeXml(srcXml)
+	locateTokens(tokens)
+
+	// Gather the expressions split across runs, then patch the ones
+	// contained in a single tag (e.g. a shape description) or text
+	mergeRunActions(tokens, actionRe, patchAction)
+
+	tokens = removeControlParagraphs(tokens, d)
+
+	expandColRanges(tokens, d, maxTableWidth)
+
+	expandRowRanges(tokens, d)
+
 	// Word may strip quotes inside certain attribute values (e.g., alt/descr of shapes).
 	// That leads to invalid Go template syntax like: {{shapeBgFillColor 00FF00}}.
 	// To make templating robust, wrap bare hex arguments in quotes for known funcs.
@@ -48,13 +74,26 @@ func PatchXml(srcXml string) string {
 	//   - {{shapeBgFillColor 00FF00}}   -> {{shapeBgFillColor "00FF00"}}
 	//   - {{shapeBgFillColor #00FF00}}  -> {{shapeBgFillColor "#00FF00"}}
 	//   - {{tableCellBgColor 00FF00}}   -> {{tableCellBgColor "00FF00"}}
-	wrapBareHexArg := func(funcName string) {
-		pat := regexp.MustCompile(`(?i)\{\{\s*` + funcName + `\s+(#?[0-9A-Fa-f]{6})\s*\}\}`)
-		srcXml = pat.ReplaceAllString(srcXml, `{{`+funcName+` "$1"}}`)
+	// The actions are patched token by token, so that the locations of the tokens are kept.
+	escape := func(delim string) string { return strings.ReplaceAll(delim, "$", "$$") }
+	bareHexArgRes := map[string]*regexp.Regexp{}
+	for _, funcName := range []string{"shapeBgFillColor", "tableCellBgColor"} {
+		pat := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(d.Left) + `\s*` + funcName + `\s+(#?[0-9A-Fa-f]{6})\s*` + regexp.QuoteMeta(d.Right))
+		bareHexArgRes[escape(d.Left)+funcName+` "${1}"`+escape(d.Right)] = pat
 	}
 
-	wrapBareHexArg("shapeBgFillColor")
-	wrapBareHexArg("tableCellBgColor")
+	var sb strings.Builder
+	for _, token := range tokens {
+		if !token.patched {
+			token.value = actionRe.ReplaceAllStringFunc(token.value, patchAction)
+		}
+		for replacement, pat := range bareHexArgRes {
+			token.value = pat.ReplaceAllString(token.value, replacement)
+		}
+
+		locations.add(sb.Len(), token.location)
+		sb.WriteString(token.value)
+	}
 
-	return srcXml
+	return sb.String(), normalized, locations
 }
@@ -0,0 +1,178 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// goStructsGenerator accumulates the Go struct types describing a schema.
+type goStructsGenerator struct {
+	buf       bytes.Buffer
+	typeNames map[string]struct{}
+}
+
+// GoStructs returns the formatted Go source of the struct types describing the data
+// of the schema, in a file of the given package. The root struct has the given type name
+// and the nested structs are named after the path of their field (e.g. TemplateValuesTable).
+// Ranged values become slices, numbers float64 and integers int.
+//
+// The fields are exported Go identifiers, which differ from the template field names
+// that are not (e.g. Name for .name): text/template reads struct fields by their exact name,
+// so each struct has a TemplateValues method returning its values keyed by the template
+// field names, which must be passed to the template instead of the struct.
+func (s *Schema) GoStructs(packageName, typeName string) ([]byte, error) {
+	if !token.IsIdentifier(packageName) {
+		return nil, fmt.Errorf("invalid package name '%s'", packageName)
+	}
+
+	if !token.IsIdentifier(typeName) {
+		return nil, fmt.Errorf("invalid type name '%s'", typeName)
+	}
+
+	g := goStructsGenerator{
+		typeNames: map[string]struct{}{},
+	}
+
+	fmt.Fprintf(&g.buf, "package %s\n", packageName)
+	g.structType(typeName, s)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("unable to format generated Go structs: %w", err)
+	}
+
+	return src, nil
+}
+
+// templateValuesMethod is the name of the generated method converting a struct to the template values.
+const templateValuesMethod = "TemplateValues"
+
+// structType writes the struct type of an object schema, its TemplateValues method and its nested objects.
+func (g *goStructsGenerator) structType(typeName string, s *Schema) {
+	fieldNames := make([]string, 0, len(s.Fields))
+	for fieldName := range s.Fields {
+		fieldNames = append(fieldNames, fieldName)
+	}
+	sort.Strings(fieldNames)
+
+	g.typeNames[typeName] = struct{}{}
+
+	// the fields whose template names differ only by case (e.g. .name and .Name) get a numeric suffix,
+	// as the fields named like the method
+	goFieldNames := map[string]struct{}{
+		templateValuesMethod: {},
+	}
+
+	nested := []func(){}
+	fields := bytes.Buffer{}
+	values := bytes.Buffer{}
+	for _, fieldName := range fieldNames {
+		goFieldName := uniqueName(goFieldNames, exportedIdentifier(fieldName))
+		goType := g.goType(typeName+goFieldName, s.Fields[fieldName], &nested)
+
+		fmt.Fprintf(&fields, "\t%s %s `json:\"%s\"`\n", goFieldName, goType, fieldName)
+		fmt.Fprintf(&values, "\t\t%q: %s,\n", fieldName, templateValue("v."+goFieldName, s.Fields[fieldName], 1))
+	}
+
+	fmt.Fprintf(&g.buf, "\ntype %s struct {\n%s}\n", typeName, fields.String())
+	fmt.Fprintf(&g.buf, "\n// %s returns the values of %s keyed by their template field names.\n", templateValuesMethod, typeName)
+	fmt.Fprintf(&g.buf, "func (v %s) %s() map[string]any {\n\treturn map[string]any{\n%s\t}\n}\n", typeName, templateValuesMethod, values.String())
+
+	for _, writeNested := range nested {
+		writeNested()
+	}
+}
+
+// templateValue returns the Go expression converting the value of expr, described by the schema,
+// to a template value: the structs and the slices of structs are converted with their TemplateValues method.
+func templateValue(expr string, s *Schema, depth int) string {
+	switch {
+	case s.Type == SchemaObject:
+		return expr + "." + templateValuesMethod + "()"
+	case !hasStructs(s):
+		return expr
+	}
+
+	item := fmt.Sprintf("item%d", depth)
+	items := fmt.Sprintf("items%d", depth)
+
+	return fmt.Sprintf("func() []any {\n%[1]s := make([]any, len(%[2]s))\nfor i, %[3]s := range %[2]s {\n%[1]s[i] = %[4]s\n}\nreturn %[1]s\n}()",
+		items, expr, item, templateValue(item, s.Items, depth+1))
+}
+
+// hasStructs reports whether the Go type of the schema is a struct or a slice of structs.
+func hasStructs(s *Schema) bool {
+	switch s.Type {
+	case SchemaObject:
+		return true
+	case SchemaArray:
+		return s.Format != SchemaFormatStyles && s.Items != nil && hasStructs(s.Items)
+	}
+
+	return false
+}
+
+// goType returns the Go type of a schema, the struct types of the nested
+// objects named after typeName are appended to nested to be written later.
+func (g *goStructsGenerator) goType(typeName string, s *Schema, nested *[]func()) string {
+	switch s.Type {
+	case SchemaObject:
+		typeName = uniqueName(g.typeNames, typeName)
+		*nested = append(*nested, func() {
+			g.structType(typeName, s)
+		})
+
+		return typeName
+	case SchemaArray:
+		if s.Format == SchemaFormatStyles || s.Items == nil {
+			return "[]any"
+		}
+
+		return "[]" + g.goType(typeName, s.Items, nested)
+	case SchemaNumber:
+		return "float64"
+	case SchemaInteger:
+		return "int"
+	}
+
+	return "string"
+}
+
+// uniqueName returns name, with a numeric suffix if already used, and marks it as used.
+func uniqueName(used map[string]struct{}, name string) string {
+	unique := name
+	for i := 2; ; i++ {
+		if _, ok := used[unique]; !ok {
+			used[unique] = struct{}{}
+			return unique
+		}
+
+		unique = fmt.Sprintf("%s%d", name, i)
+	}
+}
+
+// exportedIdentifier returns the template field name as an exported Go identifier,
+// prefixed with X when it doesn't start with a letter (e.g. _id).
+func exportedIdentifier(name string) string {
+	var sb strings.Builder
+	for _, r := range name {
+		switch {
+		case sb.Len() == 0 && unicode.IsLetter(r):
+			sb.WriteRune(unicode.ToUpper(r))
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '_':
+			sb.WriteRune(r)
+		}
+	}
+
+	identifier := sb.String()
+	if !token.IsExported(identifier) {
+		identifier = "X" + identifier
+	}
+
+	return identifier
+}
@@ -0,0 +1,73 @@
+package template
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+const testGoStructsTemplate = `{{.name}} {{.Name}} {{.templateValues}} ` +
+	`{{range .items}}{{.label}}: {{range .tags}}{{.}} {{end}}{{end}}` +
+	`{{with .customer}}{{.id}}{{end}} ` +
+	`{{range .grid}}{{range .}}{{.cell}}{{end}}{{end}}`
+
+// TestGoStructs checks that the generated structs of testGoStructsTemplate match
+// gostruct_values_test.go, which is compiled with the tests to render the template with them.
+func TestGoStructs(t *testing.T) {
+	tmpl := template.Must(template.New("test").Parse(testGoStructsTemplate))
+
+	schema := NewSchema()
+	ExtractSchema(tmpl, schema)
+
+	src, err := schema.GoStructs("template", "testValues")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := os.ReadFile("gostruct_values_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(src) != string(want) {
+		t.Fatalf("generated structs differ from gostruct_values_test.go:\n%s", src)
+	}
+
+	values := testValues{
+		Name:            "upper",
+		Name2:           "lower",
+		TemplateValues2: "tv",
+		Items: []testValuesItems{
+			{Label: "a", Tags: []string{"x", "y"}},
+			{Label: "b"},
+		},
+		Customer: testValuesCustomer{Id: "42"},
+		Grid:     [][]testValuesGrid{{{Cell: "1"}, {Cell: "2"}}, {{Cell: "3"}}},
+	}
+
+	var output strings.Builder
+	if err := tmpl.Execute(&output, values.TemplateValues()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := output.String(), "lower upper tv a: x y b: 42 123"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestExportedIdentifier(t *testing.T) {
+	tests := map[string]string{
+		"name":     "Name",
+		"Name":     "Name",
+		"_id":      "X_id",
+		"first_n2": "First_n2",
+		"été":      "Été",
+	}
+
+	for name, want := range tests {
+		if got := exportedIdentifier(name); got != want {
+			t.Errorf("exportedIdentifier(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package template
+
+type testValues struct {
+	Name            string             `json:"Name"`
+	Customer        testValuesCustomer `json:"customer"`
+	Grid            [][]testValuesGrid `json:"grid"`
+	Items           []testValuesItems  `json:"items"`
+	Name2           string             `json:"name"`
+	TemplateValues2 string             `json:"templateValues"`
+}
+
+// TemplateValues returns the values of testValues keyed by their template field names.
+func (v testValues) TemplateValues() map[string]any {
+	return map[string]any{
+		"Name":     v.Name,
+		"customer": v.Customer.TemplateValues(),
+		"grid": func() []any {
+			items1 := make([]any, len(v.Grid))
+			for i, item1 := range v.Grid {
+				items1[i] = func() []any {
+					items2 := make([]any, len(item1))
+					for i, item2 := range item1 {
+						items2[i] = item2.TemplateValues()
+					}
+					return items2
+				}()
+			}
+			return items1
+		}(),
+		"items": func() []any {
+			items1 := make([]any, len(v.Items))
+			for i, item1 := range v.Items {
+				items1[i] = item1.TemplateValues()
+			}
+			return items1
+		}(),
+		"name":           v.Name2,
+		"templateValues": v.TemplateValues2,
+	}
+}
+
+type testValuesCustomer struct {
+	Id string `json:"id"`
+}
+
+// TemplateValues returns the values of testValuesCustomer keyed by their template field names.
+func (v testValuesCustomer) TemplateValues() map[string]any {
+	return map[string]any{
+		"id": v.Id,
+	}
+}
+
+type testValuesGrid struct {
+	Cell string `json:"cell"`
+}
+
+// TemplateValues returns the values of testValuesGrid keyed by their template field names.
+func (v testValuesGrid) TemplateValues() map[string]any {
+	return map[string]any{
+		"cell": v.Cell,
+	}
+}
+
+type testValuesItems struct {
+	Label string   `json:"label"`
+	Tags  []string `json:"tags"`
+}
+
+// TemplateValues returns the values of testValuesItems keyed by their template field names.
+func (v testValuesItems) TemplateValues() map[string]any {
+	return map[string]any{
+		"label": v.Label,
+		"tags":  v.Tags,
+	}
+}
@@ -0,0 +1,362 @@
+package template
+
+import (
+	"text/template"
+	"text/template/parse"
+
+	"github.com/JJJJJJack/go-template-docx/internal/docx"
+)
+
+// SchemaType is the type of a template value, named as in JSON Schema.
+type SchemaType string
+
+const (
+	SchemaObject  SchemaType = "object"
+	SchemaArray   SchemaType = "array"
+	SchemaString  SchemaType = "string"
+	SchemaNumber  SchemaType = "number"
+	SchemaInteger SchemaType = "integer"
+)
+
+// SchemaFormat tells what a string template value is used for.
+type SchemaFormat string
+
+const (
+	// SchemaFormatImage is the filename of a media loaded with Media, used by image and replaceImage.
+	SchemaFormatImage SchemaFormat = "image"
+	// SchemaFormatHexColor is a RRGGBB or #RRGGBB color, used by the color functions.
+	SchemaFormatHexColor SchemaFormat = "hex-color"
+	// SchemaFormatStyles is a list of styles, used by styledText.
+	SchemaFormatStyles SchemaFormat = "styles"
+)
+
+// Schema is the structure of the data of a template, inferred from the way
+// the values are used: the fields accessed, the range and with scopes and
+// the template functions they are passed to.
+// Values whose usage doesn't imply any type are strings.
+type Schema struct {
+	Type   SchemaType         `json:"type"`
+	Format SchemaFormat       `json:"format,omitempty"`
+	Fields map[string]*Schema `json:"fields,omitempty"`
+	Items  *Schema            `json:"items,omitempty"`
+
+	hint schemaHint
+}
+
+// schemaHint is the type implied by the template function a value is passed to.
+type schemaHint struct {
+	Type   SchemaType
+	Format SchemaFormat
+}
+
+// funcArgsHints maps the built-in template functions to the type hints of their arguments.
+var funcArgsHints = map[string][]schemaHint{
+	"toNumberCell":     {{Type: SchemaNumber}},
+	"fontSize":         {{}, {Type: SchemaInteger}},
+	"styledText":       {{}, {Type: SchemaArray, Format: SchemaFormatStyles}},
+	"color":            {{}, {Type: SchemaString, Format: SchemaFormatHexColor}},
+	"shadeTextBg":      {{}, {Type: SchemaString, Format: SchemaFormatHexColor}},
+	"shapeBgFillColor": {{Type: SchemaString, Format: SchemaFormatHexColor}},
+	"tableCellBgColor": {{Type: SchemaString, Format: SchemaFormatHexColor}},
+	"mergeCols":        {{Type: SchemaInteger}},
+	"bulletList":       {{Type: SchemaArray}},
+	"numberedList":     {{Type: SchemaArray}},
+	"image":            {{Type: SchemaString, Format: SchemaFormatImage}},
+	"replaceImage":     {{Type: SchemaString, Format: SchemaFormatImage}},
+	"index":            {{Type: SchemaArray}},
+}
+
+// NewSchema returns an empty object schema to extract the templates data schema into.
+func NewSchema() *Schema {
+	return &Schema{
+		Type:   SchemaObject,
+		Fields: map[string]*Schema{},
+	}
+}
+
+// field returns the schema of the given field, creating it if needed.
+func (s *Schema) field(name string) *Schema {
+	if s.Fields == nil {
+		s.Fields = map[string]*Schema{}
+	}
+
+	f, ok := s.Fields[name]
+	if !ok {
+		f = &Schema{}
+		s.Fields[name] = f
+	}
+
+	return f
+}
+
+// path returns the schema of the given chain of fields (e.g. .User.Name).
+func (s *Schema) path(fields []string) *Schema {
+	for _, name := range fields {
+		s = s.field(name)
+	}
+
+	return s
+}
+
+// items returns the schema of the elements of s, which becomes an array.
+func (s *Schema) items() *Schema {
+	if s.Items == nil {
+		s.Items = &Schema{}
+	}
+
+	return s.Items
+}
+
+// applyHint sets the type implied by a template function, the first hint wins.
+func (s *Schema) applyHint(hint schemaHint) {
+	if s == nil || hint.Type == "" || s.hint.Type != "" {
+		return
+	}
+
+	s.hint = hint
+	if hint.Type == SchemaArray {
+		s.items()
+	}
+}
+
+// resolve sets the type of s and of its children: accessing fields makes an object
+// and ranging makes an array, over the type hints of the template functions.
+func (s *Schema) resolve() {
+	s.Format = ""
+
+	switch {
+	case len(s.Fields) > 0:
+		s.Type = SchemaObject
+	case s.Items != nil:
+		s.Type = SchemaArray
+		if s.hint.Type == SchemaArray {
+			s.Format = s.hint.Format
+		}
+	case s.hint.Type != "" && s.hint.Type != SchemaArray:
+		s.Type = s.hint.Type
+		s.Format = s.hint.Format
+	case s.Type == "":
+		s.Type = SchemaString
+	}
+
+	for _, f := range s.Fields {
+		f.resolve()
+	}
+
+	if s.Items != nil {
+		s.Items.resolve()
+	}
+}
+
+// schemaScope is the dot value and the variables visible while walking the parse tree.
+type schemaScope struct {
+	dot  *Schema
+	vars map[string]*Schema
+}
+
+// inner returns a copy of the scope with the given dot, variables declared
+// inside a block are not visible after its end.
+func (sc schemaScope) inner(dot *Schema) schemaScope {
+	vars := make(map[string]*Schema, len(sc.vars))
+	for name, v := range sc.vars {
+		vars[name] = v
+	}
+
+	return schemaScope{
+		dot:  dot,
+		vars: vars,
+	}
+}
+
+// collectSchema walks the parse tree and collects the schema of the values, like collectVariables
+func collectSchema(node parse.Node, sc schemaScope) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, elem := range n.Nodes {
+				collectSchema(elem, sc)
+			}
+		}
+	case *parse.ActionNode:
+		v := sc.pipe(n.Pipe)
+		if len(n.Pipe.Decl) == 1 && v != nil {
+			// Example: {{$user := .User}}
+			sc.vars[n.Pipe.Decl[0].Ident[0]] = v
+		}
+	case *parse.RangeNode:
+		var items *Schema
+		if v := sc.pipe(n.Pipe); v != nil {
+			items = v.items()
+		}
+		if items == nil {
+			items = &Schema{}
+		}
+
+		inner := sc.inner(items)
+		if len(n.Pipe.Decl) > 0 {
+			// Example: {{range $i, $row := .Rows}}
+			inner.vars[n.Pipe.Decl[len(n.Pipe.Decl)-1].Ident[0]] = items
+		}
+
+		collectSchema(n.List, inner)
+		collectSchema(n.ElseList, sc.inner(sc.dot))
+	case *parse.IfNode:
+		sc.pipe(n.Pipe)
+		collectSchema(n.List, sc.inner(sc.dot))
+		collectSchema(n.ElseList, sc.inner(sc.dot))
+	case *parse.WithNode:
+		v := sc.pipe(n.Pipe)
+		if v == nil {
+			v = &Schema{}
+		}
+
+		inner := sc.inner(v)
+		if len(n.Pipe.Decl) == 1 {
+			inner.vars[n.Pipe.Decl[0].Ident[0]] = v
+		}
+
+		collectSchema(n.List, inner)
+		collectSchema(n.ElseList, sc.inner(sc.dot))
+	case *parse.TemplateNode:
+		// reference to another template, but values come from that template's Tree
+	}
+}
+
+// pipe collects the schema of the values used in a pipeline and returns
+// the schema of its result if it is a value of the data (e.g. {{.User}}).
+func (sc schemaScope) pipe(p *parse.PipeNode) *Schema {
+	if p == nil {
+		return nil
+	}
+
+	var piped *Schema
+	for i, cmd := range p.Cmds {
+		piped = sc.command(cmd, piped, i > 0)
+	}
+
+	return piped
+}
+
+// command collects the schema of the values used in a pipeline command, where piped is the
+// result of the previous command if any, and returns the schema of its result.
+func (sc schemaScope) command(cmd *parse.CommandNode, piped *Schema, isPiped bool) *Schema {
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+
+	// fields rewritten by the missing key policy
+	if field, ok := docx.MissingKeyLookupNode(cmd); ok {
+		return sc.value(field)
+	}
+
+	// Example: {{toNumberCell .Amount}} or {{.Amount | toNumberCell}}
+	if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+		hints := funcArgsHints[ident.Ident]
+		args := cmd.Args[1:]
+
+		for i, arg := range args {
+			v := sc.value(arg)
+			if i < len(hints) {
+				v.applyHint(hints[i])
+			}
+		}
+
+		if isPiped && len(args) < len(hints) {
+			piped.applyHint(hints[len(args)])
+		}
+
+		return nil
+	}
+
+	for _, arg := range cmd.Args[1:] {
+		sc.value(arg)
+	}
+
+	return sc.value(cmd.Args[0])
+}
+
+// value returns the schema of a value of the data, or nil if the node is not one.
+func (sc schemaScope) value(node parse.Node) *Schema {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return sc.dot
+	case *parse.FieldNode:
+		// Example: {{.User.Name}}
+		return sc.dot.path(n.Ident)
+	case *parse.VariableNode:
+		// Example: {{$.Title}} or {{$user.Name}}
+		v, ok := sc.vars[n.Ident[0]]
+		if !ok {
+			return nil
+		}
+
+		return v.path(n.Ident[1:])
+	case *parse.ChainNode:
+		// Example: {{(.User).Name}}
+		v := sc.value(n.Node)
+		if v == nil {
+			return nil
+		}
+
+		return v.path(n.Field)
+	case *parse.PipeNode:
+		return sc.pipe(n)
+	}
+
+	return nil
+}
+
+// ExtractSchema extracts the data schema of ALL templates in a set into root,
+// which can be shared by the templates of many parts (e.g. document, headers and charts).
+func ExtractSchema(t *template.Template, root *Schema) {
+	for _, tpl := range t.Templates() {
+		if tpl.Tree != nil && tpl.Tree.Root != nil {
+			collectSchema(tpl.Tree.Root, schemaScope{
+				dot: root,
+				vars: map[string]*Schema{
+					"$": root,
+				},
+			})
+		}
+	}
+
+	root.resolve()
+}
+
+// JSONSchema returns the schema as a JSON Schema (draft 2020-12) document.
+func (s *Schema) JSONSchema() map[string]any {
+	js := s.jsonSchema()
+	js["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+
+	return js
+}
+
+func (s *Schema) jsonSchema() map[string]any {
+	js := map[string]any{
+		"type": string(s.Type),
+	}
+
+	switch s.Type {
+	case SchemaObject:
+		properties := make(map[string]any, len(s.Fields))
+		for name, f := range s.Fields {
+			properties[name] = f.jsonSchema()
+		}
+		js["properties"] = properties
+	case SchemaArray:
+		if s.Items != nil {
+			js["items"] = s.Items.jsonSchema()
+		}
+	}
+
+	switch s.Format {
+	case SchemaFormatImage:
+		js["format"] = string(s.Format)
+		js["description"] = "filename of a loaded media"
+	case SchemaFormatHexColor:
+		js["format"] = string(s.Format)
+		js["pattern"] = "^#?[0-9A-Fa-f]{6}$"
+	}
+
+	return js
+}
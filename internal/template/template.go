@@ -3,6 +3,8 @@ package template
 import (
 	"text/template"
 	"text/template/parse"
+
+	"github.com/JJJJJJack/go-template-docx/internal/docx"
 )
 
 // collectVariables walks the parse tree and collects variables
@@ -35,6 +37,11 @@ func collectVariables(node parse.Node, vars map[string]struct{}) {
 			collectVariables(cmd, vars)
 		}
 	case *parse.CommandNode:
+		if field, ok := docx.MissingKeyLookupNode(n); ok {
+			collectVariables(field, vars)
+			return
+		}
+
 		for _, arg := range n.Args {
 			collectVariables(arg, vars)
 		}
@@ -0,0 +1,71 @@
+package gotemplatedocx
+
+import (
+	"io"
+
+	"github.com/JJJJJJack/go-template-docx/internal/docx"
+	goziputils "github.com/JJJJJJack/go-zip-utils"
+)
+
+// Limits bounds the resources a template can use while being rendered.
+// A zero value for a field means no limit.
+type Limits struct {
+	// MaxOutputSize is the maximum size in bytes of the output DOCX file
+	MaxOutputSize int64
+	// MaxPartSize is the maximum size in bytes of the XML rendered for a single part
+	// (e.g. word/document.xml, a header or a chart)
+	MaxPartSize int64
+	// MaxImages is the maximum number of images inserted or replaced in the document
+	MaxImages int
+	// MaxInputSize is the maximum decompressed size in bytes of the input DOCX file,
+	// including its embedded XLSX files
+	MaxInputSize int64
+}
+
+// LimitError is returned when rendering exceeds one of the configured Limits,
+// it can be inspected with errors.As.
+type LimitError = docx.LimitError
+
+// SetLimits sets the resource limits enforced when the template is compiled and rendered.
+func (dt *docxTemplate) SetLimits(limits Limits) {
+	dt.limits = limits
+}
+
+// limitedWriter fails the writes that would make the written bytes exceed max.
+type limitedWriter struct {
+	w       io.Writer
+	written int64
+	max     int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if lw.written+int64(len(p)) > lw.max {
+		return 0, &LimitError{
+			Limit: docx.LimitMaxOutputSize,
+			Max:   lw.max,
+		}
+	}
+
+	n, err := lw.w.Write(p)
+	lw.written += int64(n)
+
+	return n, err
+}
+
+// checkInputSize adds the declared decompressed size of the files to *total and
+// fails if it exceeds maxSize (0 means no limit). The declared sizes can be trusted
+// since archive/zip refuses to read more bytes than declared.
+func checkInputSize(zipMap goziputils.ZipMap, total *uint64, maxSize int64) error {
+	for _, f := range zipMap {
+		*total += f.UncompressedSize64
+	}
+
+	if maxSize > 0 && *total > uint64(maxSize) {
+		return &LimitError{
+			Limit: docx.LimitMaxInputSize,
+			Max:   maxSize,
+		}
+	}
+
+	return nil
+}
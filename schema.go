@@ -0,0 +1,108 @@
+package gotemplatedocx
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/JJJJJJack/go-template-docx/internal/docx"
+	docxtemplate "github.com/JJJJJJack/go-template-docx/internal/template"
+)
+
+// TemplateSchema is the structure of the values expected by a template, see GetTemplateSchema.
+type TemplateSchema = docxtemplate.Schema
+
+type SchemaType = docxtemplate.SchemaType
+
+const (
+	SchemaObject  = docxtemplate.SchemaObject
+	SchemaArray   = docxtemplate.SchemaArray
+	SchemaString  = docxtemplate.SchemaString
+	SchemaNumber  = docxtemplate.SchemaNumber
+	SchemaInteger = docxtemplate.SchemaInteger
+)
+
+type SchemaFormat = docxtemplate.SchemaFormat
+
+const (
+	SchemaFormatImage    = docxtemplate.SchemaFormatImage
+	SchemaFormatHexColor = docxtemplate.SchemaFormatHexColor
+	SchemaFormatStyles   = docxtemplate.SchemaFormatStyles
+)
+
+// GetTemplateSchema returns the structure of the values used by all the templated parts
+// of the DOCX file: the fields of each range and with scope are nested in the schema
+// of the ranged (array) or with value, and the type of the fields passed to the template
+// functions is inferred from them (e.g. toNumberCell numbers or image filenames).
+func (dt *docxTemplate) GetTemplateSchema() (*TemplateSchema, error) {
+	compiled, err := dt.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return compiled.Schema(), nil
+}
+
+// GetTemplateJSONSchema returns the schema of GetTemplateSchema as a JSON Schema document,
+// e.g. to generate the input forms of the template.
+func (dt *docxTemplate) GetTemplateJSONSchema() ([]byte, error) {
+	schema, err := dt.GetTemplateSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonSchema, err := json.MarshalIndent(schema.JSONSchema(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal JSON schema: %w", err)
+	}
+
+	return jsonSchema, nil
+}
+
+// GenerateGoStructs returns the Go source of the struct types of the template values,
+// in a file of the given package, from the schema of GetTemplateSchema: the root struct
+// has the given type name, range and with scopes become nested structs, ranged fields slices
+// and the fields passed to toNumberCell float64. Each struct has a TemplateValues method returning
+// its values keyed by the template field names, which must be rendered instead of the struct.
+func (dt *docxTemplate) GenerateGoStructs(packageName, typeName string) ([]byte, error) {
+	schema, err := dt.GetTemplateSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	return schema.GoStructs(packageName, typeName)
+}
+
+// Schema returns the structure of the values used by the compiled template, see GetTemplateSchema.
+func (ct *compiledTemplate) Schema() *TemplateSchema {
+	schema := docxtemplate.NewSchema()
+	for _, tmpl := range ct.templates() {
+		docxtemplate.ExtractSchema(tmpl.Template, schema)
+	}
+
+	return schema
+}
+
+// templates returns the parsed templates of all the templated parts.
+func (ct *compiledTemplate) templates() []*docx.Template {
+	templates := []*docx.Template{}
+
+	for _, cx := range ct.xlsxFiles {
+		templates = append(templates, cx.sharedStringsTmpl)
+	}
+
+	for _, header := range ct.headers {
+		templates = append(templates, header.tmpl)
+	}
+
+	for _, footer := range ct.footers {
+		templates = append(templates, footer.tmpl)
+	}
+
+	templates = append(templates, ct.documentPart.tmpl)
+
+	for _, chart := range ct.charts {
+		templates = append(templates, chart.tmpl)
+	}
+
+	return templates
+}
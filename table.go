@@ -0,0 +1,11 @@
+package gotemplatedocx
+
+import "github.com/JJJJJJack/go-template-docx/internal/docx"
+
+// Table is a table generated from Go values by the table template function, e.g. {{table .Report}},
+// with its columns definitions and table style.
+type Table = docx.Table
+
+// TableColumn is the definition of a column of a Table: its field, header label, width,
+// alignment and number format.
+type TableColumn = docx.TableColumn
@@ -0,0 +1,167 @@
+package gotemplatedocx
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/JJJJJJack/go-template-docx/internal/docx"
+)
+
+var funcIdentifierRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// funcRegistry is the set of template functions of a single docxTemplate.
+// The built-in functions are shared by every registry and never modified,
+// while custom and namespaced functions are owned by the registry. The namespaced functions are kept
+// apart and merged last, so that a custom function can never replace one of them.
+// The merged FuncMap returned by config is never modified afterwards: any later
+// change builds a new one (copy-on-write), so it can be safely shared with compiled templates.
+type funcRegistry struct {
+	mu                  sync.Mutex
+	custom              template.FuncMap
+	namespaced          template.FuncMap
+	namespacedOwners    map[string]string
+	namespaces          map[string]struct{}
+	disabledBuiltins    map[string]struct{}
+	allBuiltinsDisabled bool
+	snapshot            template.FuncMap
+}
+
+func newFuncRegistry() *funcRegistry {
+	return &funcRegistry{
+		custom:           template.FuncMap{},
+		namespaced:       template.FuncMap{},
+		namespacedOwners: map[string]string{},
+		namespaces:       map[string]struct{}{},
+		disabledBuiltins: map[string]struct{}{},
+	}
+}
+
+// add registers the functions of funcMap, shadowing existing ones with the same name.
+func (r *funcRegistry) add(funcMap template.FuncMap) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for funcName, fn := range funcMap {
+		r.custom[funcName] = fn
+	}
+	r.snapshot = nil
+}
+
+// addNamespaced registers the functions of funcMap under the given namespace,
+// so that they can be called as {{namespace.funcName}} in the templates.
+func (r *funcRegistry) addNamespaced(namespace string, funcMap template.FuncMap) error {
+	// the "__" separates the namespace and the function name in the registered name
+	if !funcIdentifierRe.MatchString(namespace) || strings.Contains(namespace, "__") {
+		return fmt.Errorf("invalid template functions namespace '%s'", namespace)
+	}
+
+	for funcName := range funcMap {
+		if !funcIdentifierRe.MatchString(funcName) || strings.Contains(funcName, "__") {
+			return fmt.Errorf("invalid template function name '%s' in namespace '%s'", funcName, namespace)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// e.g. "a_" and "_b" registered as "a___b" like "a" and "__b"
+	for funcName := range funcMap {
+		owner, ok := r.namespacedOwners[docx.NamespacedFuncName(namespace, funcName)]
+		if ok && owner != namespace {
+			return fmt.Errorf("template function '%s.%s' collides with a function of namespace '%s'", namespace, funcName, owner)
+		}
+	}
+
+	for funcName, fn := range funcMap {
+		r.namespaced[docx.NamespacedFuncName(namespace, funcName)] = fn
+		r.namespacedOwners[docx.NamespacedFuncName(namespace, funcName)] = namespace
+	}
+	r.namespaces[namespace] = struct{}{}
+	r.snapshot = nil
+
+	return nil
+}
+
+// disableBuiltins removes the given built-in functions, or all of them if no name is provided.
+func (r *funcRegistry) disableBuiltins(funcNames ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(funcNames) == 0 {
+		r.allBuiltinsDisabled = true
+	}
+
+	for _, funcName := range funcNames {
+		r.disabledBuiltins[funcName] = struct{}{}
+	}
+	r.snapshot = nil
+}
+
+// config returns the template configuration holding the current functions.
+// The returned FuncMap must not be modified.
+func (r *funcRegistry) config() docx.TemplateConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.snapshot == nil {
+		r.snapshot = make(template.FuncMap, len(docx.TemplateFuncs)+len(r.custom)+len(r.namespaced))
+
+		if !r.allBuiltinsDisabled {
+			for funcName, fn := range docx.TemplateFuncs {
+				if _, disabled := r.disabledBuiltins[funcName]; disabled {
+					continue
+				}
+
+				r.snapshot[funcName] = fn
+			}
+		}
+
+		for funcName, fn := range r.custom {
+			r.snapshot[funcName] = fn
+		}
+
+		for funcName, fn := range r.namespaced {
+			r.snapshot[funcName] = fn
+		}
+	}
+
+	namespaces := make([]string, 0, len(r.namespaces))
+	for namespace := range r.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+
+	return docx.TemplateConfig{
+		Funcs:      r.snapshot,
+		Namespaces: namespaces,
+	}
+}
+
+// validationConfig is like config but the built-in image functions, if enabled and not shadowed,
+// fail when the filename is not one of the given loaded medias.
+func (r *funcRegistry) validationConfig(mm docx.MediaMap) docx.TemplateConfig {
+	config := r.config()
+
+	funcs := make(template.FuncMap, len(config.Funcs))
+	for funcName, fn := range config.Funcs {
+		funcs[funcName] = fn
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for funcName, fn := range docx.MediaCheckedFuncs(mm) {
+		_, disabled := r.disabledBuiltins[funcName]
+		_, shadowed := r.custom[funcName]
+		if r.allBuiltinsDisabled || disabled || shadowed {
+			continue
+		}
+
+		funcs[funcName] = fn
+	}
+	config.Funcs = funcs
+
+	return config
+}
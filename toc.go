@@ -0,0 +1,9 @@
+package gotemplatedocx
+
+// SetUpdateTableOfContents rebuilds the tables of contents of the document (the TOC fields) from the headings
+// of the rendered document, each heading getting a bookmark targeted by its entry, and asks Word to update
+// the fields when the document is opened, refreshing the page numbers. The lists of figures and tables
+// (the TOC fields with a \c switch) are rebuilt from the caption paragraphs of their sequence.
+func (dt *docxTemplate) SetUpdateTableOfContents(update bool) {
+	dt.updateTableOfContents = update
+}
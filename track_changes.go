@@ -0,0 +1,14 @@
+package gotemplatedocx
+
+import "github.com/JJJJJJack/go-template-docx/internal/docx"
+
+// TrackChanges is the author and the date of the tracked changes, see SetTrackChanges.
+type TrackChanges = docx.TrackChanges
+
+// SetTrackChanges renders the changes made by the template as tracked changes of the given author,
+// that can be reviewed and accepted or rejected in Word: the values printed in the document, headers
+// and footers are insertions and the text of the false {{if}} branches is a deletion.
+// The Date of the changes is the rendering time if zero, an empty Author disables the tracked changes.
+func (dt *docxTemplate) SetTrackChanges(changes TrackChanges) {
+	dt.trackChanges = changes
+}
@@ -0,0 +1,81 @@
+package gotemplatedocx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ValidationReport lists all the problems found in a DOCX template by Validate.
+// The template errors can be inspected with errors.As and a *TemplateError.
+type ValidationReport struct {
+	Errors []error
+	// Normalized lists the expressions in which the typographic characters inserted by Word
+	// (e.g. smart quotes) were automatically replaced, they are not errors but should be fixed.
+	Normalized []NormalizedExpression
+}
+
+// Valid reports whether no problem was found.
+func (r *ValidationReport) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// String returns the problems found and the normalized expressions, one per line.
+func (r *ValidationReport) String() string {
+	var sb strings.Builder
+	if r.Valid() {
+		sb.WriteString("no problems found")
+	} else {
+		fmt.Fprintf(&sb, "%d problem(s) found:", len(r.Errors))
+		for _, err := range r.Errors {
+			sb.WriteString("\n- ")
+			sb.WriteString(err.Error())
+		}
+	}
+
+	if len(r.Normalized) > 0 {
+		fmt.Fprintf(&sb, "\n%d expression(s) normalized:", len(r.Normalized))
+		for _, n := range r.Normalized {
+			sb.WriteString("\n- ")
+			sb.WriteString(n.String())
+		}
+	}
+
+	return sb.String()
+}
+
+func (r *ValidationReport) add(errs ...error) {
+	r.Errors = append(r.Errors, errs...)
+}
+
+// Validate runs the whole pipeline (pre-processing, parsing, execution, images and
+// shapes placeholders, charts update) with the provided values in a dry-run mode, without
+// producing any output, and reports every problem found instead of stopping at the first one:
+// missing keys, unknown functions, images not loaded with Media, invalid colors...
+// The returned error is not nil only if the DOCX file itself can't be processed.
+func (dt *docxTemplate) Validate(templateValues any) (*ValidationReport, error) {
+	return dt.ValidateContext(context.Background(), templateValues)
+}
+
+// ValidateContext is like Validate but stops with ctx's error as soon as ctx is done.
+func (dt *docxTemplate) ValidateContext(ctx context.Context, templateValues any) (*ValidationReport, error) {
+	templateValues, err := unmarshalTemplateValues(templateValues)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ValidationReport{}
+
+	compiled, err := dt.compile(report)
+	if err != nil {
+		return nil, err
+	}
+
+	err = compiled.render(ctx, io.Discard, templateValues, report)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
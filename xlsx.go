@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"sort"
 
+	"github.com/JJJJJJack/go-template-docx/internal/docx"
 	"github.com/JJJJJJack/go-template-docx/internal/xlsx"
 	goziputils "github.com/JJJJJJack/go-zip-utils"
 )
@@ -14,13 +16,26 @@ type chartCellAndValue map[string]string
 
 type xlsxChartsMap map[string]chartCellAndValue
 
-// modifyXlsxInMemoryFromZipFile modifies an internal file inside an XLSX embedded in a zip.File.
-// It returns a modified XLSX as []byte.
-func (dt *docxTemplate) modifyXlsxInMemoryFromZipFile(xlsxFile *zip.File, templateValues any) ([]byte, error) {
-	var sharedStringsNumbers map[int]string
-	// key: old index, value: new index
-	var sharedStringsNewIndexes map[int]int
+// compiledSheet is a worksheet of an embedded XLSX with its content read once at compile time.
+type compiledSheet struct {
+	file    *zip.File
+	content []byte
+}
+
+// compiledXlsx is an embedded XLSX whose shared strings are parsed once at compile time.
+type compiledXlsx struct {
+	file              *zip.File
+	copiedFiles       []*zip.File
+	sharedStringsFile *zip.File
+	sharedStringsTmpl *docx.Template
+	sheets            []compiledSheet
+}
 
+// compileXlsx reads an XLSX embedded in a zip.File and parses its templated files.
+// Its decompressed size is added to *inputTotalSize and checked against maxInputSize.
+// Its templated files are parsed with the missing key policy and the delimiters of docxConfig.
+// When report is not nil the parsing errors are collected into it, see compilePart.
+func compileXlsx(xlsxFile *zip.File, inputTotalSize *uint64, maxInputSize int64, docxConfig docx.TemplateConfig, report *ValidationReport) (*compiledXlsx, error) {
 	// Read XLSX zip into memory
 	xlsxData, err := goziputils.ReadZipFileContent(xlsxFile)
 	if err != nil {
@@ -32,8 +47,14 @@ func (dt *docxTemplate) modifyXlsxInMemoryFromZipFile(xlsxFile *zip.File, templa
 		return nil, fmt.Errorf("failed to create XLSX zip map: %w", err)
 	}
 
-	var buf bytes.Buffer
-	zipWriter := zip.NewWriter(&buf)
+	err = checkInputSize(xlsxZipMap, inputTotalSize, maxInputSize)
+	if err != nil {
+		return nil, err
+	}
+
+	cx := compiledXlsx{
+		file: xlsxFile,
+	}
 
 	// Copy all files except the ones that will be processed
 	sheetNMatcher := regexp.MustCompile(`xl/worksheets/sheet\d*\.xml`)
@@ -48,34 +69,40 @@ func (dt *docxTemplate) modifyXlsxInMemoryFromZipFile(xlsxFile *zip.File, templa
 			continue
 		}
 
-		err := goziputils.CopyFile(zipWriter, f)
-		if err != nil {
-			return nil, fmt.Errorf("unable to copy original embedding xlsx file '%s': %w", f.Name, err)
-		}
+		cx.copiedFiles = append(cx.copiedFiles, f)
 	}
+	sort.Slice(cx.copiedFiles, func(i, j int) bool {
+		return cx.copiedFiles[i].Name < cx.copiedFiles[j].Name
+	})
 
 	// work on sharedStrings.xml
-	sharedStringsFile := xlsxZipMap[sharedStringsFilename]
-	if sharedStringsFile == nil {
+	cx.sharedStringsFile = xlsxZipMap[sharedStringsFilename]
+	if cx.sharedStringsFile == nil {
 		return nil, fmt.Errorf("shared strings file '%s' not found in embedded XLSX", sharedStringsFilename)
 	}
 
-	sharedStringsContent, err := goziputils.ReadZipFileContent(sharedStringsFile)
+	sharedStringsContent, err := goziputils.ReadZipFileContent(cx.sharedStringsFile)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file '%s': %w", sharedStringsFile.Name, err)
+		return nil, fmt.Errorf("error reading file '%s': %w", cx.sharedStringsFile.Name, err)
 	}
 
-	sharedStringsContent, err = xlsx.ApplyTemplateToCells(sharedStringsFile, templateValues, sharedStringsContent)
-	if err != nil {
-		return nil, fmt.Errorf("error applying template to file '%s': %w", sharedStringsFile.Name, err)
-	}
-
-	sharedStringsContent, sharedStringsNumbers, sharedStringsNewIndexes, err = xlsx.GetReferencedSharedStringsByIndexAndCleanup(sharedStringsContent)
-	if err != nil {
-		return nil, fmt.Errorf("error cleaning up shared strings in file '%s': %w", sharedStringsFile.Name, err)
+	config := xlsx.CellsTemplateConfig()
+	config.MissingKey = docxConfig.MissingKeyPolicy(xlsxFile.Name)
+	config.Delims = docxConfig.Delims
+
+	if report != nil {
+		var errs []error
+		var normalized []docx.NormalizedExpression
+		cx.sharedStringsTmpl, errs, normalized = config.ParseAll(cx.sharedStringsFile.Name, string(sharedStringsContent))
+		report.add(errs...)
+		report.Normalized = append(report.Normalized, normalized...)
+	} else {
+		cx.sharedStringsTmpl, err = config.Parse(cx.sharedStringsFile.Name, string(sharedStringsContent))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing template of file '%s': %w", cx.sharedStringsFile.Name, err)
+		}
 	}
 
-	sharedStringsCount := uint(0)
 	for i := 1; ; i++ {
 		sheetN := fmt.Sprintf("xl/worksheets/sheet%d.xml", i)
 
@@ -89,36 +116,76 @@ func (dt *docxTemplate) modifyXlsxInMemoryFromZipFile(xlsxFile *zip.File, templa
 			return nil, fmt.Errorf("error reading zip file content '%s': %w", f.Name, err)
 		}
 
-		var chartValues map[string]string
-		fileContent, chartValues, err = xlsx.UpdateSheet(fileContent, sharedStringsNumbers, sharedStringsNewIndexes)
+		cx.sheets = append(cx.sheets, compiledSheet{
+			file:    f,
+			content: fileContent,
+		})
+	}
+
+	return &cx, nil
+}
+
+// render applies the template values to the embedded XLSX and returns it as []byte,
+// the numeric values written in the sheets cells are stored in the render state charts meta.
+// When validating, the returned bytes are nil if the shared strings could not be executed.
+func (cx *compiledXlsx) render(rs *renderState) ([]byte, error) {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+
+	for _, f := range cx.copiedFiles {
+		err := zipWriter.Copy(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to copy original embedding xlsx file '%s': %w", f.Name, err)
+		}
+	}
+
+	sharedStringsContent, ok, err := rs.execute(compiledPart{
+		file: cx.sharedStringsFile,
+		tmpl: cx.sharedStringsTmpl,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error applying template to file '%s': %w", cx.sharedStringsFile.Name, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	sharedStringsContent, sharedStringsNumbers, sharedStringsNewIndexes, err := xlsx.GetReferencedSharedStringsByIndexAndCleanup(sharedStringsContent)
+	if err != nil {
+		return nil, fmt.Errorf("error cleaning up shared strings in file '%s': %w", cx.sharedStringsFile.Name, err)
+	}
+
+	sharedStringsCount := uint(0)
+	for _, sheet := range cx.sheets {
+		fileContent, chartValues, err := xlsx.UpdateSheet(sheet.content, sharedStringsNumbers, sharedStringsNewIndexes)
 		if err != nil {
-			return nil, fmt.Errorf("error replacing shared strings indexes in file '%s': %w", f.Name, err)
+			return nil, fmt.Errorf("error replacing shared strings indexes in file '%s': %w", sheet.file.Name, err)
 		}
 
-		dt.xlsxChartsMeta[xlsxFile.Name] = chartValues
+		rs.xlsxChartsMeta[cx.file.Name] = chartValues
 
 		sharedStringsRefs, err := xlsx.GetCountFromXml(fileContent)
 		if err != nil {
-			return nil, fmt.Errorf("error getting shared strings refs count from file '%s': %w", f.Name, err)
+			return nil, fmt.Errorf("error getting shared strings refs count from file '%s': %w", sheet.file.Name, err)
 		}
 
 		sharedStringsCount += sharedStringsRefs
 
-		err = goziputils.RewriteFileIntoZipWriter(zipWriter, f, fileContent)
+		err = goziputils.RewriteFileIntoZipWriter(zipWriter, sheet.file, fileContent)
 		if err != nil {
-			return nil, fmt.Errorf("error writing file '%s': %w", f.Name, err)
+			return nil, fmt.Errorf("error writing file '%s': %w", sheet.file.Name, err)
 		}
 	}
 
 	// need to be here, after all sheets have been processed we know the real count
 	sharedStringsContent, err = xlsx.UpdateSharedStringsCounts(sharedStringsContent, sharedStringsCount)
 	if err != nil {
-		return nil, fmt.Errorf("error recounting sharedStrings file '%s': %w", sharedStringsFile.Name, err)
+		return nil, fmt.Errorf("error recounting sharedStrings file '%s': %w", cx.sharedStringsFile.Name, err)
 	}
 
-	err = goziputils.RewriteFileIntoZipWriter(zipWriter, sharedStringsFile, sharedStringsContent)
+	err = goziputils.RewriteFileIntoZipWriter(zipWriter, cx.sharedStringsFile, sharedStringsContent)
 	if err != nil {
-		return nil, fmt.Errorf("error writing sharedStrings file '%s': %w", sharedStringsFile.Name, err)
+		return nil, fmt.Errorf("error writing sharedStrings file '%s': %w", cx.sharedStringsFile.Name, err)
 	}
 
 	if err := zipWriter.Close(); err != nil {
@@ -128,13 +195,21 @@ func (dt *docxTemplate) modifyXlsxInMemoryFromZipFile(xlsxFile *zip.File, templa
 	return buf.Bytes(), nil
 }
 
-func (dt *docxTemplate) writeXlsxIntoZip(f *zip.File, docxZipWriter *zip.Writer, templateValues any) error {
-	xlsxBytes, err := dt.modifyXlsxInMemoryFromZipFile(f, templateValues)
+// writeIntoZip renders the embedded XLSX and writes it into the docx zip writer.
+func (cx *compiledXlsx) writeIntoZip(rs *renderState) error {
+	if err := rs.ctx.Err(); err != nil {
+		return err
+	}
+
+	xlsxBytes, err := cx.render(rs)
 	if err != nil {
 		return fmt.Errorf("error modifying XLSX in memory: %w", err)
 	}
+	if xlsxBytes == nil {
+		return nil
+	}
 
-	err = goziputils.RewriteFileIntoZipWriter(docxZipWriter, f, xlsxBytes)
+	err = goziputils.RewriteFileIntoZipWriter(rs.zipWriter, cx.file, xlsxBytes)
 	if err != nil {
 		return fmt.Errorf("error creating entry in zip: %w", err)
 	}